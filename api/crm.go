@@ -1,137 +1,420 @@
 package api
 
 import (
-	"encoding/json"
 	"fmt"
 	"github.com/deeprave/go-crm/crm"
 	"github.com/gorilla/mux"
 	"io"
+	"mime"
 	"net/http"
 	"strconv"
+	"strings"
 )
 
-var customers crm.CustomerTable
-
 // generic utils
 
+const (
+	mediaTypeJSON       = "application/json"
+	mediaTypeMergePatch = "application/merge-patch+json"
+	mediaTypeJSONPatch  = "application/json-patch+json"
+	mediaTypeNDJSON     = "application/x-ndjson"
+)
+
+// streamBatchSize is the number of customers fetched from the store at a time
+// when a list is streamed
+const streamBatchSize = 500
+
+// isMediaType checks whether the request content type is one of the given
+// media types; a request without a content type is assumed to be json
+func isMediaType(request *http.Request, mediaTypes ...string) bool {
+	contentType := request.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = mediaTypeJSON
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err == nil {
+		for _, candidate := range mediaTypes {
+			if mediaType == candidate {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func setJson(writer http.ResponseWriter) {
-	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Content-Type", mediaTypeJSON)
+}
+
+// writeCustomer sends a customer record, along with its entity tag
+func writeCustomer(writer http.ResponseWriter, customer *crm.Customer, status int) {
+	writeProjected(writer, customer, status, nil, jsonEncoding)
 }
 
-func Error(writer http.ResponseWriter, errString string, status int) {
-	setJson(writer)
+// writeProjected sends the fields of a customer record selected by a projection,
+// in the representation given by an encoding
+func writeProjected(writer http.ResponseWriter, customer *crm.Customer, status int, projection crm.Projection, encoding Encoding) {
+	setETag(writer, customer)
+	writer.Header().Set("Content-Type", encoding.MediaType)
 	writer.WriteHeader(status)
-	errorMessage := map[string]string{"message": errString}
-	_ = json.NewEncoder(writer).Encode(errorMessage)
+	_ = encoding.One(projection, writer, customer)
 }
 
-// API handlers
+// setVary notes that responses depend on the Accept header, for caches
+func setVary(writer http.ResponseWriter) {
+	writer.Header().Add("Vary", "Accept")
+}
 
-func getCustomers(writer http.ResponseWriter, _ *http.Request) {
-	setJson(writer)
-	data, _ := customers.GetAllCustomers().ToJSON()
-	_, _ = writer.Write([]byte(data))
+// customerId returns the id of the customer addressed by the request path
+func customerId(request *http.Request) (int64, error) {
+	idString, ok := mux.Vars(request)["id"]
+	if !ok {
+		return 0, fmt.Errorf("customer %w", crm.ErrNotFound)
+	}
+	return crm.ParseId(idString)
 }
 
-func getCustomer(writer http.ResponseWriter, request *http.Request) {
-	params := mux.Vars(request)
-	if idString, ok := params["id"]; ok {
-		id, err := strconv.ParseInt(idString, 10, 64)
-		if err == nil {
-			c := customers.GetCustomerById(id)
-			if c != nil {
-				setJson(writer)
-				data, _ := c.ToJSON()
-				_, _ = writer.Write([]byte(data))
-				return
+// queryCustomers runs a query, by the store itself if it is able
+func (s *Server) queryCustomers(query crm.Query) (crm.Page, error) {
+	if querier, ok := s.store.(crm.Querier); ok {
+		return querier.Query(query)
+	}
+	all, err := s.store.List()
+	if err != nil {
+		return crm.Page{}, err
+	}
+	query.Region = s.validator.Region
+	return query.Run(all)
+}
+
+// customerBatches reads the customers selected by a query from the store, a
+// batch at a time unless the query is limited to a page. A sorted list is
+// sorted once: the store is asked for the ids of all the customers in order,
+// and they are fetched in batches from those, or, if it cannot do that, for
+// them all at once. Only a list in id order is read page by page, which the
+// store does without sorting.
+type customerBatches struct {
+	server  *Server
+	query   crm.Query
+	page    crm.Page      // the current batch
+	paged   bool          // whether batches are read as pages of the query
+	store   crm.IdQuerier // the store fetching the customers by id, if reading them so
+	pending []int64       // the ids of the customers still to fetch
+}
+
+// readCustomers starts reading the customers selected by a query, fetching the first batch
+func (s *Server) readCustomers(query crm.Query) (*customerBatches, error) {
+	var err error
+	batches := &customerBatches{server: s, query: query}
+	store, byId := s.store.(crm.IdQuerier)
+	_, querier := s.store.(crm.Querier)
+	switch {
+	case query.Limit != 0:
+		batches.page, err = s.queryCustomers(query)
+	case len(query.Sort) != 0 && byId:
+		batches.store = store
+		if batches.pending, batches.page.Total, err = store.QueryIds(query); err == nil {
+			batches.fetch()
+		}
+	case len(query.Sort) == 0 && querier:
+		batches.paged = true
+		batches.query.Limit = streamBatchSize
+		batches.page, err = s.queryCustomers(batches.query)
+	default:
+		batches.page, err = s.queryCustomers(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return batches, nil
+}
+
+// fetch fetches the next batch of customers by id
+func (b *customerBatches) fetch() {
+	size := len(b.pending)
+	if size > streamBatchSize {
+		size = streamBatchSize
+	}
+	b.page.Customers = b.store.GetMany(b.pending[:size])
+	b.pending = b.pending[size:]
+}
+
+// more reports whether there is another batch after this one
+func (b *customerBatches) more() bool {
+	if b.store != nil {
+		return len(b.pending) != 0
+	}
+	return b.paged && b.page.Next != ""
+}
+
+// next moves to the next batch
+func (b *customerBatches) next() error {
+	if b.store != nil {
+		b.fetch()
+		return nil
+	}
+	var err error
+	b.query.Cursor = b.page.Next
+	b.page, err = b.server.queryCustomers(b.query)
+	return err
+}
+
+// writeCustomers streams the customers selected by a query to the client as they
+// are encoded, in the representation negotiated with the client.
+// Unless the query is limited to a page, customers are fetched from the store in
+// batches (see customerBatches), so a list of any size is sent using the memory
+// needed for a batch, and its ids if it is sorted.
+// An error is returned only if nothing has yet been written; one occurring while
+// streaming is logged and the response cut short.
+func (s *Server) writeCustomers(writer http.ResponseWriter, request *http.Request, query crm.Query, projection crm.Projection) error {
+	setVary(writer)
+	encoding, err := s.negotiate(request)
+	if err != nil {
+		return err
+	}
+	batches, err := s.readCustomers(query)
+	if err != nil {
+		return err
+	}
+	if query.Limit == 0 {
+		setPageLinks(writer, request, crm.Page{Total: batches.page.Total})
+	} else {
+		setPageLinks(writer, request, batches.page)
+	}
+
+	writer.Header().Set("Content-Type", encoding.MediaType)
+	encoder := encoding.List(writer, projection)
+	flusher, _ := writer.(http.Flusher)
+	for {
+		for index := range batches.page.Customers {
+			if err = encoder.Encode(&batches.page.Customers[index]); err != nil {
+				s.logger.Printf("%s %s: %v", request.Method, request.URL.Path, err)
+				return nil
 			}
 		}
+		if !batches.more() {
+			break
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if err = batches.next(); err != nil {
+			s.logger.Printf("%s %s: %v", request.Method, request.URL.Path, err)
+			return nil
+		}
+	}
+	if err = encoder.Close(); err != nil {
+		s.logger.Printf("%s %s: %v", request.Method, request.URL.Path, err)
+	}
+	return nil
+}
+
+// setPageLinks sets the total count of a query's results, and Link headers
+// to the first page and (unless this is the last) the next page
+func setPageLinks(writer http.ResponseWriter, request *http.Request, page crm.Page) {
+	writer.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+	link := *request.URL
+	query := link.Query()
+	query.Del("cursor")
+	link.RawQuery = query.Encode()
+	links := []string{fmt.Sprintf("<%s>; rel=\"first\"", link.RequestURI())}
+	if page.Next != "" {
+		query.Set("cursor", page.Next)
+		link.RawQuery = query.Encode()
+		links = append(links, fmt.Sprintf("<%s>; rel=\"next\"", link.RequestURI()))
 	}
-	Error(writer, fmt.Sprintf("bad request"), http.StatusNotFound)
+	writer.Header().Set("Link", strings.Join(links, ", "))
 }
 
-func addCustomer(writer http.ResponseWriter, request *http.Request) {
+// API handlers
+
+// getCustomers lists the customers selected by the query parameters (see crm.ParseQuery),
+// giving the fields selected by the fields or exclude parameters (see crm.ParseProjection)
+func (s *Server) getCustomers(writer http.ResponseWriter, request *http.Request) {
 	var (
-		err  error
-		body []byte
-		c    crm.Customer
+		err        error
+		query      crm.Query
+		projection crm.Projection
 	)
-	defer func(Body io.ReadCloser) {
-		_ = Body.Close()
-	}(request.Body)
-
-	if body, err = io.ReadAll(request.Body); err == nil {
-		if err = c.FromJSON(body); err == nil {
-			n := customers.NewCustomer(c.Name, c.Role, c.Email, c.Phone)
-			setJson(writer)
-			data, _ := n.ToJSON()
-			writer.WriteHeader(http.StatusCreated)
-			_, _ = writer.Write([]byte(data))
-			return
+	if query, err = crm.ParseQuery(request.URL.Query()); err == nil {
+		if projection, err = crm.ParseProjection(request.URL.Query()); err == nil {
+			if err = s.writeCustomers(writer, request, query, projection); err == nil {
+				return
+			}
 		}
 	}
-	Error(writer, err.Error(), http.StatusBadRequest)
+	s.writeError(writer, request, err)
 }
 
-func updateCustomer(writer http.ResponseWriter, request *http.Request) {
-	params := mux.Vars(request)
-	err := fmt.Errorf("bad request")
-	if idString, ok := params["id"]; ok {
-		id, err := strconv.ParseInt(idString, 10, 64)
-		if err == nil {
-			var customer = &crm.Customer{}
-			if body, err := io.ReadAll(request.Body); err == nil {
-				if err = customer.FromJSON(body); err == nil {
-					if customer, err = customers.UpdateCustomerById(id, customer); err == nil {
-						setJson(writer)
-						writer.WriteHeader(http.StatusOK)
-						data, _ := customer.ToJSON()
-						_, _ = writer.Write([]byte(data))
+func (s *Server) getCustomer(writer http.ResponseWriter, request *http.Request) {
+	var (
+		err        error
+		id         int64
+		projection crm.Projection
+		encoding   Encoding
+		customer   *crm.Customer
+	)
+	if id, err = customerId(request); err == nil {
+		if projection, err = crm.ParseProjection(request.URL.Query()); err == nil {
+			setVary(writer)
+			if encoding, err = s.negotiate(request); err == nil {
+				if customer, err = s.store.Get(id); err == nil {
+					if etagMatches(request.Header.Get("If-None-Match"), customer, true) {
+						setETag(writer, customer)
+						writer.WriteHeader(http.StatusNotModified)
 						return
 					}
+					writeProjected(writer, customer, http.StatusOK, projection, encoding)
+					return
 				}
 			}
 		}
 	}
-	Error(writer, err.Error(), http.StatusBadRequest)
+	s.writeError(writer, request, err)
 }
 
-func deleteCustomer(writer http.ResponseWriter, request *http.Request) {
-	params := mux.Vars(request)
-	err := fmt.Errorf("not found")
-	if idString, ok := params["id"]; ok {
-		id, err := strconv.ParseInt(idString, 10, 64)
-		if err == nil {
-			customer, err := customers.DeleteCustomerById(id)
+func (s *Server) addCustomer(writer http.ResponseWriter, request *http.Request) {
+	var (
+		err      error
+		body     []byte
+		c        crm.Customer
+		customer *crm.Customer
+	)
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(request.Body)
+
+	if body, err = io.ReadAll(request.Body); err == nil {
+		if err = BadRequest(c.FromJSON(body)); err == nil {
+			if err = s.validator.Validate(&c); err == nil {
+				if customer, err = s.store.Create(&c); err == nil {
+					writeCustomer(writer, customer, http.StatusCreated)
+					return
+				}
+			}
+		}
+	}
+	s.writeError(writer, request, err)
+}
+
+// updateCustomer handles both PUT, which replaces the customer in full, and
+// PATCH, which applies either an RFC 7396 JSON Merge Patch or (given
+// Content-Type: application/json-patch+json) an RFC 6902 JSON Patch
+// to the existing record
+func (s *Server) updateCustomer(writer http.ResponseWriter, request *http.Request) {
+	var (
+		err      error
+		id       int64
+		body     []byte
+		customer *crm.Customer
+	)
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(request.Body)
+
+	if id, err = customerId(request); err == nil {
+		if body, err = io.ReadAll(request.Body); err == nil {
+			switch {
+			case request.Method == http.MethodPatch && isMediaType(request, mediaTypeJSONPatch):
+				customer, err = s.patchCustomer(request, id, body)
+			case request.Method == http.MethodPatch && isMediaType(request, mediaTypeJSON, mediaTypeMergePatch):
+				customer, err = s.mergeCustomer(request, id, body)
+			case request.Method == http.MethodPut && isMediaType(request, mediaTypeJSON):
+				customer, err = s.replaceCustomer(request, id, body)
+			default:
+				err = errUnsupportedMediaType
+			}
 			if err == nil {
-				setJson(writer)
-				data, _ := customer.ToJSON()
-				// 202=not yet enacted, likely to succeed, 204=no return data
-				// we are returning the deleted customer however
-				writer.WriteHeader(http.StatusOK)
-				_, _ = writer.Write([]byte(data))
+				writeCustomer(writer, customer, http.StatusOK)
 				return
 			}
 		}
 	}
-	Error(writer, err.Error(), http.StatusNotFound)
+	s.writeError(writer, request, err)
+}
+
+// replaceCustomer replaces a customer with the record in body.
+// A version given in the body must match that of the current record.
+func (s *Server) replaceCustomer(request *http.Request, id int64, body []byte) (*crm.Customer, error) {
+	return s.modifyCustomer(request, id, func(current *crm.Customer) (*crm.Customer, error) {
+		customer := &crm.Customer{}
+		if err := customer.FromJSON(body); err != nil {
+			return nil, err
+		}
+		if customer.Id != 0 && customer.Id != id {
+			return nil, &crm.ValidationError{Fields: []crm.FieldError{{Field: "id", Message: "cannot be changed"}}}
+		}
+		if customer.Version != 0 && customer.Version != current.Version {
+			return nil, fmt.Errorf("customer id %d %w: expected version %d, found %d",
+				id, crm.ErrVersionMismatch, customer.Version, current.Version)
+		}
+		return customer, nil
+	})
 }
 
-func ReadCustomerData(filename string) error {
-	return customers.ReadCustomerData(filename)
+// patchCustomer applies the json patch operations in body to a customer
+func (s *Server) patchCustomer(request *http.Request, id int64, body []byte) (*crm.Customer, error) {
+	return s.modifyCustomer(request, id, func(current *crm.Customer) (*crm.Customer, error) {
+		return current.JSONPatch(body)
+	})
 }
 
-func ApiRoutes(basePath string) *mux.Router {
-	router := mux.NewRouter()
+// mergeCustomer applies the merge patch in body to a customer
+func (s *Server) mergeCustomer(request *http.Request, id int64, body []byte) (*crm.Customer, error) {
+	return s.modifyCustomer(request, id, func(current *crm.Customer) (*crm.Customer, error) {
+		return current.MergePatch(body)
+	})
+}
 
-	router.HandleFunc(basePath, getCustomers).Methods(http.MethodGet)
-	router.HandleFunc(basePath+"/{id}", getCustomer).Methods(http.MethodGet)
-	router.HandleFunc(basePath, addCustomer).Methods(http.MethodPost)
-	router.HandleFunc(basePath+"/{id}", updateCustomer).Methods(http.MethodPatch, http.MethodPut)
-	router.HandleFunc(basePath+"/{id}", deleteCustomer).Methods(http.MethodDelete)
-	return router
+// modifyCustomer fetches a customer, checks any If-Match precondition, applies
+// a modification and stores the validated result. The update is made against
+// the version fetched, so a concurrent change is detected rather than overwritten.
+func (s *Server) modifyCustomer(request *http.Request, id int64, modify func(*crm.Customer) (*crm.Customer, error)) (*crm.Customer, error) {
+	current, err := s.store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkIfMatch(request, current); err != nil {
+		return nil, err
+	}
+	customer, err := modify(current)
+	if err != nil {
+		return nil, BadRequest(err)
+	}
+	if err = s.validator.Validate(customer); err != nil {
+		return nil, err
+	}
+	customer.Version = current.Version
+	customer, err = s.store.Update(id, customer)
+	return customer, preconditionError(request, err)
 }
 
-func ApiMiddleware(router *mux.Router) *mux.Router {
-	return router
+func (s *Server) deleteCustomer(writer http.ResponseWriter, request *http.Request) {
+	var (
+		err      error
+		id       int64
+		version  int64
+		customer *crm.Customer
+	)
+	if id, err = customerId(request); err == nil {
+		if request.Header.Get("If-Match") != "" {
+			if customer, err = s.store.Get(id); err == nil {
+				err = checkIfMatch(request, customer)
+				version = customer.Version
+			}
+		}
+		if err == nil {
+			if customer, err = s.store.Delete(id, version); err == nil {
+				// 202=not yet enacted, likely to succeed, 204=no return data
+				// we are returning the deleted customer however
+				setJson(writer)
+				writer.WriteHeader(http.StatusOK)
+				data, _ := customer.ToJSON()
+				_, _ = writer.Write([]byte(data))
+				return
+			}
+			err = preconditionError(request, err)
+		}
+	}
+	s.writeError(writer, request, err)
 }
@@ -2,21 +2,25 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/deeprave/go-crm/crm"
 	"github.com/gorilla/mux"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
 )
 
 // Tests for API handlers
-// start by reading in a test dataset
-func setupData(t *testing.T) {
-	if err := ReadCustomerData("../crm/data/customers.json"); err != nil {
+// start by reading in a test dataset into an isolated server
+func setupData(t *testing.T) *Server {
+	server := NewServer(&crm.CustomerTable{})
+	if err := server.ReadCustomerData("../crm/data/customers.json"); err != nil {
 		t.Fatal(err)
 	}
+	return server
 }
 
 // some infrastructuret to help testing customer record against a fixture
@@ -54,11 +58,11 @@ func testCustomerValues(t *testing.T, customer *crm.Customer, c crm.Customer) {
 }
 
 func TestGetCustomers(t *testing.T) {
-	setupData(t)
+	server := setupData(t)
 	request := httptest.NewRequest(http.MethodGet, "/customer", nil)
 	writer := httptest.NewRecorder()
 	//
-	getCustomers(writer, request)
+	server.getCustomers(writer, request)
 	//
 	result := writer.Result()
 	defer result.Body.Close()
@@ -79,14 +83,14 @@ func TestGetCustomers(t *testing.T) {
 }
 
 func TestGetCustomer(t *testing.T) {
-	setupData(t)
+	server := setupData(t)
 
 	urlVars := map[string]string{"id": "5"}
 	request := httptest.NewRequest(http.MethodGet, "/customers/{id}", nil)
 	request = mux.SetURLVars(request, urlVars)
 	writer := httptest.NewRecorder()
 	//
-	getCustomer(writer, request)
+	server.getCustomer(writer, request)
 	//
 	result := writer.Result()
 	defer result.Body.Close()
@@ -100,21 +104,21 @@ func TestGetCustomer(t *testing.T) {
 		if err = json.Unmarshal(data, customer); err != nil {
 			t.Errorf("unexpected json error: %v", err)
 		} else {
-			fixture := crm.Customer{5, "Bianca Bruxner", "student", "bbruxner@dayrep.com", "(07) 4938 5904", false}
+			fixture := crm.Customer{Id: 5, Name: "Bianca Bruxner", Role: "student", Email: "bbruxner@dayrep.com", Phone: "(07) 4938 5904"}
 			testCustomerValues(t, customer, fixture)
 		}
 	}
 }
 
 func TestAddCustomer(t *testing.T) {
-	setupData(t)
+	server := setupData(t)
 
-	reader := strings.NewReader("{\"name\":\"Bill Gates\",\"role\":\"teacher\",\"email\":\"bill.gates@microsoft.com\",\"phone\":\"(555) 555 5555\"}\n")
+	reader := strings.NewReader("{\"name\":\"Bill Gates\",\"role\":\"teacher\",\"email\":\"bill.gates@microsoft.com\",\"phone\":\"+1 (555) 555 5555\"}\n")
 	request := httptest.NewRequest(http.MethodPost, "/customers/{id}", reader)
 	request.Header.Set("Content-Type", "application/json")
 	writer := httptest.NewRecorder()
 	//
-	addCustomer(writer, request)
+	server.addCustomer(writer, request)
 	//
 	result := writer.Result()
 	defer result.Body.Close()
@@ -129,23 +133,23 @@ func TestAddCustomer(t *testing.T) {
 			t.Errorf("unexpected json error: %v", err)
 		} else {
 			// cheat here, steal the id from the created record
-			fixture := crm.Customer{customer.Id, "Bill Gates", "teacher", "bill.gates@microsoft.com", "(555) 555 5555", false}
+			fixture := crm.Customer{Id: customer.Id, Name: "Bill Gates", Role: "teacher", Email: "bill.gates@microsoft.com", Phone: "+1 (555) 555 5555"}
 			testCustomerValues(t, customer, fixture)
 		}
 	}
 }
 
 func TestUpdateCustomer(t *testing.T) {
-	setupData(t)
+	server := setupData(t)
 
-	reader := strings.NewReader("{\"name\":\"Bill Gates\",\"role\":\"teacher\",\"email\":\"bill.gates@microsoft.com\",\"phone\":\"(555) 555 5555\"}\n")
+	reader := strings.NewReader("{\"name\":\"Bill Gates\",\"role\":\"teacher\",\"email\":\"bill.gates@microsoft.com\",\"phone\":\"+1 (555) 555 5555\"}\n")
 	urlVars := map[string]string{"id": "5"}
 	request := httptest.NewRequest(http.MethodPut, "/customers/{id}", reader)
 	request = mux.SetURLVars(request, urlVars)
 	request.Header.Set("Content-Type", "application/json")
 	writer := httptest.NewRecorder()
 	//
-	updateCustomer(writer, request)
+	server.updateCustomer(writer, request)
 	//
 	result := writer.Result()
 	defer result.Body.Close()
@@ -159,21 +163,21 @@ func TestUpdateCustomer(t *testing.T) {
 		if err = json.Unmarshal(data, customer); err != nil {
 			t.Errorf("unexpected json error: %v", err)
 		} else {
-			fixture := crm.Customer{5, "Bill Gates", "teacher", "bill.gates@microsoft.com", "(555) 555 5555", false}
+			fixture := crm.Customer{Id: 5, Name: "Bill Gates", Role: "teacher", Email: "bill.gates@microsoft.com", Phone: "+1 (555) 555 5555"}
 			testCustomerValues(t, customer, fixture)
 		}
 	}
 }
 
 func TestDeleteCustomer(t *testing.T) {
-	setupData(t)
+	server := setupData(t)
 
 	urlVars := map[string]string{"id": "5"}
 	request := httptest.NewRequest(http.MethodDelete, "/customers/{id}", nil)
 	request = mux.SetURLVars(request, urlVars)
 	writer := httptest.NewRecorder()
 	//
-	deleteCustomer(writer, request)
+	server.deleteCustomer(writer, request)
 	//
 	result := writer.Result()
 	defer result.Body.Close()
@@ -187,14 +191,14 @@ func TestDeleteCustomer(t *testing.T) {
 		if err = json.Unmarshal(data, customer); err != nil {
 			t.Errorf("unexpected json error: %v", err)
 		} else {
-			fixture := crm.Customer{5, "Bianca Bruxner", "student", "bbruxner@dayrep.com", "(07) 4938 5904", false}
+			fixture := crm.Customer{Id: 5, Name: "Bianca Bruxner", Role: "student", Email: "bbruxner@dayrep.com", Phone: "(07) 4938 5904"}
 			testCustomerValues(t, customer, fixture)
 
 			request = httptest.NewRequest(http.MethodGet, "/customers/{id}", nil)
 			request = mux.SetURLVars(request, urlVars)
 			writer = httptest.NewRecorder()
 			//
-			getCustomer(writer, request)
+			server.getCustomer(writer, request)
 			//
 			result = writer.Result()
 			defer result.Body.Close()
@@ -204,3 +208,332 @@ func TestDeleteCustomer(t *testing.T) {
 		}
 	}
 }
+
+// serve runs a single request through a handler, returning the result and decoded customer (if any)
+func serve(t *testing.T, handler http.HandlerFunc, request *http.Request, urlVars map[string]string) (*http.Response, *crm.Customer) {
+	if urlVars != nil {
+		request = mux.SetURLVars(request, urlVars)
+	}
+	writer := httptest.NewRecorder()
+	handler(writer, request)
+	result := writer.Result()
+	t.Cleanup(func() { _ = result.Body.Close() })
+	customer := &crm.Customer{}
+	if result.StatusCode < http.StatusBadRequest {
+		if data, err := io.ReadAll(result.Body); err != nil {
+			t.Errorf("unexpected read error: %v", err)
+		} else if len(data) > 0 {
+			if err = json.Unmarshal(data, customer); err != nil {
+				t.Errorf("unexpected json error: %v", err)
+			}
+		}
+	}
+	return result, customer
+}
+
+func TestReplaceCustomer(t *testing.T) {
+	server := setupData(t)
+	urlVars := map[string]string{"id": "5"}
+
+	// a replacement clears any field not provided
+	request := httptest.NewRequest(http.MethodPut, "/customers/{id}", strings.NewReader(`{"name":"Bianca Bruxner","role":"staff"}`))
+	result, customer := serve(t, server.updateCustomer, request, urlVars)
+	if result.StatusCode != http.StatusOK {
+		t.Errorf("expected status code %d, got %d", http.StatusOK, result.StatusCode)
+	}
+	testCustomerValues(t, customer, crm.Customer{Id: 5, Name: "Bianca Bruxner", Role: "staff"})
+
+	// and must be a valid customer
+	request = httptest.NewRequest(http.MethodPut, "/customers/{id}", strings.NewReader(`{"role":"staff"}`))
+	if result, _ = serve(t, server.updateCustomer, request, urlVars); result.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("expected status code %d, got %d", http.StatusUnprocessableEntity, result.StatusCode)
+	}
+
+	// whose id cannot be changed
+	request = httptest.NewRequest(http.MethodPut, "/customers/{id}", strings.NewReader(`{"id":6,"name":"Bianca Bruxner"}`))
+	if result, _ = serve(t, server.updateCustomer, request, urlVars); result.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("expected status code %d, got %d", http.StatusUnprocessableEntity, result.StatusCode)
+	}
+}
+
+func TestMergePatchCustomer(t *testing.T) {
+	server := setupData(t)
+	urlVars := map[string]string{"id": "5"}
+
+	request := httptest.NewRequest(http.MethodPatch, "/customers/{id}", strings.NewReader(`{"contacted":true}`))
+	request.Header.Set("Content-Type", "application/merge-patch+json")
+	if result, customer := serve(t, server.updateCustomer, request, urlVars); result.StatusCode != http.StatusOK {
+		t.Errorf("expected status code %d, got %d", http.StatusOK, result.StatusCode)
+	} else if !customer.Contacted {
+		t.Errorf("customer was not marked as contacted")
+	}
+
+	// null clears a field, and contacted may be set back to false
+	request = httptest.NewRequest(http.MethodPatch, "/customers/{id}", strings.NewReader(`{"phone":null,"contacted":false}`))
+	request.Header.Set("Content-Type", "application/merge-patch+json")
+	result, customer := serve(t, server.updateCustomer, request, urlVars)
+	if result.StatusCode != http.StatusOK {
+		t.Errorf("expected status code %d, got %d", http.StatusOK, result.StatusCode)
+	}
+	testCustomerValues(t, customer, crm.Customer{Id: 5, Name: "Bianca Bruxner", Role: "student", Email: "bbruxner@dayrep.com"})
+
+	// the patched record must still be valid
+	request = httptest.NewRequest(http.MethodPatch, "/customers/{id}", strings.NewReader(`{"name":null}`))
+	if result, _ = serve(t, server.updateCustomer, request, urlVars); result.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("expected status code %d, got %d", http.StatusUnprocessableEntity, result.StatusCode)
+	}
+
+	request = httptest.NewRequest(http.MethodPatch, "/customers/{id}", strings.NewReader(`name=Bianca`))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if result, _ = serve(t, server.updateCustomer, request, urlVars); result.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("expected status code %d, got %d", http.StatusUnsupportedMediaType, result.StatusCode)
+	}
+}
+
+func TestJSONPatchCustomer(t *testing.T) {
+	server := setupData(t)
+	urlVars := map[string]string{"id": "5"}
+
+	patch := `[{"op":"test","path":"/role","value":"student"},{"op":"replace","path":"/role","value":"staff"},{"op":"remove","path":"/phone"}]`
+	request := httptest.NewRequest(http.MethodPatch, "/customers/{id}", strings.NewReader(patch))
+	request.Header.Set("Content-Type", "application/json-patch+json")
+	result, customer := serve(t, server.updateCustomer, request, urlVars)
+	if result.StatusCode != http.StatusOK {
+		t.Errorf("expected status code %d, got %d", http.StatusOK, result.StatusCode)
+	}
+	testCustomerValues(t, customer, crm.Customer{Id: 5, Name: "Bianca Bruxner", Role: "staff", Email: "bbruxner@dayrep.com"})
+
+	// a failed test leaves the record untouched
+	patch = `[{"op":"replace","path":"/email","value":"bianca@people.au"},{"op":"test","path":"/role","value":"student"}]`
+	request = httptest.NewRequest(http.MethodPatch, "/customers/{id}", strings.NewReader(patch))
+	request.Header.Set("Content-Type", "application/json-patch+json")
+	if result, _ = serve(t, server.updateCustomer, request, urlVars); result.StatusCode != http.StatusConflict {
+		t.Errorf("expected status code %d, got %d", http.StatusConflict, result.StatusCode)
+	}
+	if customer, _ = server.Store().Get(5); customer.Email != "bbruxner@dayrep.com" {
+		t.Errorf("customer email changed to %s by a failed patch", customer.Email)
+	}
+
+	patch = `[{"op":"copy","from":"/name","path":"/role"}]`
+	request = httptest.NewRequest(http.MethodPatch, "/customers/{id}", strings.NewReader(patch))
+	request.Header.Set("Content-Type", "application/json-patch+json")
+	if result, _ = serve(t, server.updateCustomer, request, urlVars); result.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("expected status code %d, got %d", http.StatusUnprocessableEntity, result.StatusCode)
+	}
+
+	// a patch document is not a replacement
+	request = httptest.NewRequest(http.MethodPut, "/customers/{id}", strings.NewReader(`[]`))
+	request.Header.Set("Content-Type", "application/json-patch+json")
+	if result, _ = serve(t, server.updateCustomer, request, urlVars); result.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("expected status code %d, got %d", http.StatusUnsupportedMediaType, result.StatusCode)
+	}
+}
+
+// plainStore hides the optional capabilities of the store it wraps
+type plainStore struct {
+	crm.CustomerStore
+}
+
+func TestGetCustomersByPhone(t *testing.T) {
+	server := setupData(t)
+	for _, store := range []crm.CustomerStore{server.Store(), plainStore{server.Store()}} {
+		server := NewServer(store)
+		for phone, expected := range map[string]int{"+61 7 4938 5904": 1, "0749385904": 1, "0400 000 000": 0, "": 0} {
+			request := httptest.NewRequest(http.MethodGet, "/customers?phone="+url.QueryEscape(phone), nil)
+			writer := httptest.NewRecorder()
+			server.getCustomers(writer, request)
+			customers := crm.Customers{}
+			if err := json.NewDecoder(writer.Result().Body).Decode(&customers); err != nil {
+				t.Errorf("unexpected json error: %v", err)
+			} else if len(customers) != expected {
+				t.Errorf("%T: phone %q returned %d customers, expected %d", store, phone, len(customers), expected)
+			} else if expected == 1 && customers[0].Id != 5 {
+				t.Errorf("%T: phone %q returned customer %d, expected 5", store, phone, customers[0].Id)
+			}
+		}
+	}
+}
+
+func TestGetCustomersQuery(t *testing.T) {
+	server := setupData(t)
+	router := server.Router()
+
+	get := func(target string) (*httptest.ResponseRecorder, crm.Customers) {
+		writer := httptest.NewRecorder()
+		router.ServeHTTP(writer, httptest.NewRequest(http.MethodGet, target, nil))
+		customers := crm.Customers{}
+		if writer.Code == http.StatusOK {
+			if err := json.Unmarshal(writer.Body.Bytes(), &customers); err != nil {
+				t.Errorf("unexpected json error: %v", err)
+			}
+		}
+		return writer, customers
+	}
+
+	writer, customers := get("/customers?email~=dayrep&sort=-id")
+	if len(customers) != 3 || customers[0].Id != 17 || customers[2].Id != 2 {
+		t.Errorf("unexpected customers %v", customers)
+	}
+	if total := writer.Header().Get("X-Total-Count"); total != "3" {
+		t.Errorf("X-Total-Count is %q, expected 3", total)
+	}
+
+	// follow the next links through every page
+	target, seen := "/customers?role=student&limit=5", 0
+	for pages := 0; target != "" && pages < 5; pages++ {
+		writer, customers = get(target)
+		if writer.Header().Get("X-Total-Count") != "14" {
+			t.Errorf("X-Total-Count is %q, expected 14", writer.Header().Get("X-Total-Count"))
+		}
+		seen += len(customers)
+		links := writer.Header().Get("Link")
+		if !strings.Contains(links, `</customers?limit=5&role=student>; rel="first"`) {
+			t.Errorf("missing first link in %q", links)
+		}
+		target = ""
+		if start := strings.Index(links, `, <`); start >= 0 {
+			target = strings.TrimSuffix(links[start+3:], `>; rel="next"`)
+		}
+	}
+	if seen != 14 {
+		t.Errorf("pages held %d customers, expected 14", seen)
+	}
+
+	if writer, _ = get("/customers?contacted=maybe"); writer.Code != http.StatusBadRequest {
+		t.Errorf("expected status code %d, got %d", http.StatusBadRequest, writer.Code)
+	} else if problem := readProblem(t, writer); problem.Code != "invalid_query" {
+		t.Errorf("unexpected problem code %q", problem.Code)
+	}
+}
+
+func TestGetCustomersFilter(t *testing.T) {
+	server := setupData(t)
+	router := server.Router()
+
+	filter := url.QueryEscape("role eq 'student' and not contacted and not (email endswith '@dayrep.com') and id lt 5")
+	writer := httptest.NewRecorder()
+	router.ServeHTTP(writer, httptest.NewRequest(http.MethodGet, "/customers?filter="+filter, nil))
+	customers := crm.Customers{}
+	if writer.Code != http.StatusOK {
+		t.Errorf("expected status code %d, got %d", http.StatusOK, writer.Code)
+	} else if err := json.Unmarshal(writer.Body.Bytes(), &customers); err != nil {
+		t.Errorf("unexpected json error: %v", err)
+	} else if len(customers) != 2 || customers[0].Id != 1 || customers[1].Id != 3 {
+		t.Errorf("unexpected customers %v", customers)
+	}
+
+	filter = url.QueryEscape("role eq 'student' and contacted eq maybe")
+	writer = httptest.NewRecorder()
+	router.ServeHTTP(writer, httptest.NewRequest(http.MethodGet, "/customers?filter="+filter, nil))
+	if writer.Code != http.StatusBadRequest {
+		t.Errorf("expected status code %d, got %d", http.StatusBadRequest, writer.Code)
+	}
+	if problem := readProblem(t, writer); problem.Code != "invalid_filter" || problem.Position != 36 {
+		t.Errorf("unexpected problem %+v", problem)
+	}
+}
+
+func TestGetCustomersFields(t *testing.T) {
+	server := setupData(t)
+	router := server.Router()
+
+	tests := []struct {
+		target, expected string
+		status           int
+	}{
+		{"/customers?fields=id,name&sort=-id&limit=2", `[{"id":19,"name":"Jett Roth"},{"id":18,"name":"Samantha Hemmant"}]` + "\n", http.StatusOK},
+		{"/customers/5?fields=name,id", `{"id":5,"name":"Bianca Bruxner"}` + "\n", http.StatusOK},
+		{"/customers/5?exclude=phone,phone_e164,version,role", `{"id":5,"name":"Bianca Bruxner","email":"bbruxner@dayrep.com"}` + "\n", http.StatusOK},
+		{"/customers?fields=id,nickname", "", http.StatusBadRequest},
+		{"/customers?fields=id&colour=red", "", http.StatusBadRequest},
+		{"/customers/5?fields=id&exclude=name", "", http.StatusBadRequest},
+		{"/customers/99?fields=id", "", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		writer := httptest.NewRecorder()
+		router.ServeHTTP(writer, httptest.NewRequest(http.MethodGet, test.target, nil))
+		if writer.Code != test.status {
+			t.Errorf("GET %s: expected status code %d, got %d", test.target, test.status, writer.Code)
+		} else if test.expected != "" && writer.Body.String() != test.expected {
+			t.Errorf("GET %s: expected %s, got %s", test.target, test.expected, writer.Body.String())
+		}
+	}
+
+	// the next page keeps the projection
+	writer := httptest.NewRecorder()
+	router.ServeHTTP(writer, httptest.NewRequest(http.MethodGet, "/customers?fields=id&limit=2", nil))
+	if link := writer.Header().Get("Link"); !strings.Contains(link, "fields=id") {
+		t.Errorf("expected the projection in the links, got %s", link)
+	}
+}
+
+func TestGetCustomersStream(t *testing.T) {
+	table := &crm.CustomerTable{}
+	for i := 0; i < 1234; i++ {
+		table.NewCustomer(fmt.Sprintf("Customer %d", i), "student", "", "")
+	}
+	for _, store := range []crm.CustomerStore{table, plainStore{table}} {
+		router := NewServer(store).Router()
+
+		// json, fetched from the store in batches
+		writer := httptest.NewRecorder()
+		router.ServeHTTP(writer, httptest.NewRequest(http.MethodGet, "/customers?role=student", nil))
+		var customers crm.Customers
+		if err := json.Unmarshal(writer.Body.Bytes(), &customers); err != nil {
+			t.Fatalf("%T: unexpected json error: %v", store, err)
+		}
+		if len(customers) != 1234 || customers[0].Id != 1 || customers[1233].Id != 1234 {
+			t.Errorf("%T: expected 1234 customers in order, got %d", store, len(customers))
+		}
+		// a store that cannot run queries itself is listed once, rather than for every batch
+		_, querier := store.(crm.Querier)
+		if writer.Flushed != querier || writer.Header().Get("X-Total-Count") != "1234" || strings.Contains(writer.Header().Get("Link"), "next") {
+			t.Errorf("%T: unexpected headers %v (flushed %v)", store, writer.Header(), writer.Flushed)
+		}
+
+		// sorted, and sent in batches of the customers sorted once
+		writer = httptest.NewRecorder()
+		router.ServeHTTP(writer, httptest.NewRequest(http.MethodGet, "/customers?sort=-name&fields=id,name", nil))
+		customers = nil
+		if err := json.Unmarshal(writer.Body.Bytes(), &customers); err != nil {
+			t.Fatalf("%T: unexpected json error: %v", store, err)
+		}
+		if len(customers) != 1234 || customers[0].Name != "Customer 999" || customers[1233].Name != "Customer 0" {
+			t.Errorf("%T: expected 1234 customers by name, got %d", store, len(customers))
+		}
+		for index := 1; index < len(customers); index++ {
+			if strings.ToLower(customers[index-1].Name) < strings.ToLower(customers[index].Name) {
+				t.Errorf("%T: %q is before %q", store, customers[index-1].Name, customers[index].Name)
+				break
+			}
+		}
+		if writer.Flushed != querier || writer.Header().Get("X-Total-Count") != "1234" {
+			t.Errorf("%T: unexpected headers %v (flushed %v)", store, writer.Header(), writer.Flushed)
+		}
+
+		// newline delimited json, one customer per line
+		request := httptest.NewRequest(http.MethodGet, "/customers?fields=id&limit=600&cursor=", nil)
+		request.Header.Set("Accept", "application/x-ndjson, application/json;q=0.5")
+		writer = httptest.NewRecorder()
+		router.ServeHTTP(writer, request)
+		if contentType := writer.Header().Get("Content-Type"); contentType != mediaTypeNDJSON {
+			t.Errorf("%T: expected content type %s, got %s", store, mediaTypeNDJSON, contentType)
+		}
+		lines := strings.Split(strings.TrimSuffix(writer.Body.String(), "\n"), "\n")
+		if len(lines) != 600 || lines[0] != `{"id":1}` || lines[599] != `{"id":600}` {
+			t.Errorf("%T: unexpected ndjson with %d lines", store, len(lines))
+		}
+		if !strings.Contains(writer.Header().Get("Link"), `rel="next"`) {
+			t.Errorf("%T: expected a link to the next page, got %s", store, writer.Header().Get("Link"))
+		}
+	}
+
+	// an error before streaming starts is reported as usual
+	request := httptest.NewRequest(http.MethodGet, "/customers?cursor=nonsense", nil)
+	request.Header.Set("Accept", mediaTypeNDJSON)
+	writer := httptest.NewRecorder()
+	NewServer(table).Router().ServeHTTP(writer, request)
+	if writer.Code != http.StatusBadRequest {
+		t.Errorf("expected status code %d, got %d", http.StatusBadRequest, writer.Code)
+	}
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/deeprave/go-crm/crm"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// mergeRequest is the body of a merge request: the customer to be folded
+// into the one addressed, and the rule for each field
+type mergeRequest struct {
+	Source int64           `json:"source"`
+	Policy crm.MergePolicy `json:"policy,omitempty"`
+}
+
+// getDuplicates lists pairs of customers that are likely to be the same person.
+// The "threshold" query parameter sets the name similarity required (0 to 1).
+func (s *Server) getDuplicates(writer http.ResponseWriter, request *http.Request) {
+	var (
+		err        error
+		customers  crm.Customers
+		duplicates []crm.DuplicateMatch
+	)
+	threshold := crm.DefaultDuplicateThreshold
+	if value := request.URL.Query().Get("threshold"); value != "" {
+		if threshold, err = strconv.ParseFloat(value, 64); err == nil && (threshold <= 0 || threshold > 1) {
+			err = fmt.Errorf("threshold must be greater than 0 and at most 1")
+		}
+	}
+	if err = BadRequest(err); err == nil {
+		if finder, ok := s.store.(crm.DuplicateFinder); ok {
+			duplicates = finder.FindDuplicates(threshold)
+		} else if customers, err = s.store.List(); err == nil {
+			duplicates = crm.FindDuplicates(customers, threshold, s.validator.Region)
+		}
+		if err == nil {
+			setJson(writer)
+			_ = json.NewEncoder(writer).Encode(duplicates)
+			return
+		}
+	}
+	s.writeError(writer, request, err)
+}
+
+// mergeCustomers folds the source customer named in the body into the customer
+// addressed, deleting the source. An If-Match precondition applies to the target.
+func (s *Server) mergeCustomers(writer http.ResponseWriter, request *http.Request) {
+	var (
+		err      error
+		id       int64
+		version  int64
+		body     []byte
+		merge    mergeRequest
+		customer *crm.Customer
+	)
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(request.Body)
+
+	merger, ok := s.store.(crm.Merger)
+	if !ok {
+		s.writeError(writer, request, fmt.Errorf("merge %w", errNotSupported))
+		return
+	}
+	if id, err = customerId(request); err == nil {
+		if body, err = io.ReadAll(request.Body); err == nil {
+			if err = BadRequest(json.Unmarshal(body, &merge)); err == nil && merge.Source <= 0 {
+				err = &crm.ValidationError{Fields: []crm.FieldError{{Field: "source", Message: "is required"}}}
+			}
+			if err == nil {
+				if request.Header.Get("If-Match") != "" {
+					if customer, err = s.store.Get(id); err == nil {
+						err = checkIfMatch(request, customer)
+						version = customer.Version
+					}
+				}
+				if err == nil {
+					if customer, _, err = merger.Merge(id, merge.Source, merge.Policy, version); err == nil {
+						writeCustomer(writer, customer, http.StatusOK)
+						return
+					}
+					err = preconditionError(request, err)
+				}
+			}
+		}
+	}
+	s.writeError(writer, request, err)
+}
+
+// getMerges lists the customers that have been merged into the customer addressed
+func (s *Server) getMerges(writer http.ResponseWriter, request *http.Request) {
+	var (
+		err error
+		id  int64
+	)
+	merger, ok := s.store.(crm.Merger)
+	if !ok {
+		s.writeError(writer, request, fmt.Errorf("merge %w", errNotSupported))
+		return
+	}
+	if id, err = customerId(request); err == nil {
+		if _, err = s.store.Get(id); err == nil {
+			setJson(writer)
+			_ = json.NewEncoder(writer).Encode(merger.Merges(id))
+			return
+		}
+	}
+	s.writeError(writer, request, err)
+}
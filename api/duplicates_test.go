@@ -0,0 +1,95 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/deeprave/go-crm/crm"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGetDuplicates(t *testing.T) {
+	server := setupData(t)
+	if _, err := server.Store().Create(&crm.Customer{Name: "B. Bruxner"}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	for _, store := range []crm.CustomerStore{server.Store(), plainStore{server.Store()}} {
+		router := NewServer(store).Router()
+		request := httptest.NewRequest(http.MethodGet, "/customers/duplicates", nil)
+		writer := httptest.NewRecorder()
+		router.ServeHTTP(writer, request)
+		if writer.Code != http.StatusOK {
+			t.Fatalf("%T: expected status code %d, got %d", store, http.StatusOK, writer.Code)
+		}
+		var matches []crm.DuplicateMatch
+		if err := json.Unmarshal(writer.Body.Bytes(), &matches); err != nil {
+			t.Errorf("unexpected json error: %v", err)
+		} else if len(matches) != 1 || matches[0].Customer.Id != 5 || matches[0].Duplicate.Id != 20 {
+			t.Errorf("%T: unexpected duplicates %v", store, matches)
+		}
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/customers/duplicates?threshold=2", nil)
+	writer := httptest.NewRecorder()
+	server.Router().ServeHTTP(writer, request)
+	if writer.Code != http.StatusBadRequest {
+		t.Errorf("expected status code %d, got %d", http.StatusBadRequest, writer.Code)
+	}
+}
+
+func TestMergeCustomers(t *testing.T) {
+	server := setupData(t)
+	router := server.Router()
+	source, err := server.Store().Create(&crm.Customer{Name: "B. Bruxner", Role: "staff", Email: "bianca@people.au"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	tests := []struct {
+		target, body, ifMatch string
+		status                int
+	}{
+		{"/customers/5/merge", `{"source":20,"policy":{"id":"source"}}`, "", http.StatusUnprocessableEntity},
+		{"/customers/5/merge", `{"policy":{}}`, "", http.StatusUnprocessableEntity},
+		{"/customers/5/merge", `{"source":`, "", http.StatusBadRequest},
+		{"/customers/5/merge", `{"source":4}`, "", http.StatusNotFound},
+		{"/customers/5/merge", `{"source":20}`, `"7"`, http.StatusPreconditionFailed},
+		{"/customers/5/merge", `{"source":20,"policy":{"email":"source"}}`, `"1"`, http.StatusOK},
+		{"/customers/5/merge", `{"source":20}`, "", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest(http.MethodPost, test.target, strings.NewReader(test.body))
+		if test.ifMatch != "" {
+			request.Header.Set("If-Match", test.ifMatch)
+		}
+		writer := httptest.NewRecorder()
+		router.ServeHTTP(writer, request)
+		if writer.Code != test.status {
+			t.Errorf("POST %s %s: expected status code %d, got %d", test.target, test.body, test.status, writer.Code)
+		}
+	}
+
+	if customer, _ := server.Store().Get(5); customer.Email != "bianca@people.au" || customer.Role != "student" {
+		t.Errorf("unexpected merged customer %v", customer)
+	}
+	request := httptest.NewRequest(http.MethodGet, "/customers/5/merges", nil)
+	writer := httptest.NewRecorder()
+	router.ServeHTTP(writer, request)
+	var records []crm.MergeRecord
+	if err = json.Unmarshal(writer.Body.Bytes(), &records); err != nil {
+		t.Errorf("unexpected json error: %v", err)
+	} else if len(records) != 1 || records[0].SourceId != source.Id || records[0].Source.Name != "B. Bruxner" {
+		t.Errorf("unexpected merge records %v", records)
+	}
+
+	// stores without merge support
+	router = NewServer(plainStore{server.Store()}).Router()
+	request = httptest.NewRequest(http.MethodPost, "/customers/5/merge", strings.NewReader(`{"source":6}`))
+	writer = httptest.NewRecorder()
+	router.ServeHTTP(writer, request)
+	if writer.Code != http.StatusNotImplemented {
+		t.Errorf("expected status code %d, got %d", http.StatusNotImplemented, writer.Code)
+	}
+}
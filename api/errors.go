@@ -0,0 +1,122 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/deeprave/go-crm/crm"
+	"net/http"
+)
+
+// Errors raised by the api itself, in addition to those defined by crm
+var (
+	errBadRequest           = errors.New("bad request")
+	errContentTooLarge      = errors.New("request body too large")
+	errUnsupportedMediaType = errors.New("unsupported content type")
+	errNotAcceptable        = errors.New("no acceptable representation")
+	errPreconditionFailed   = errors.New("precondition failed")
+	errRouteNotFound        = errors.New("no such resource")
+	errMethodNotAllowed     = errors.New("method not allowed")
+	errNotSupported         = errors.New("is not supported by the customer store")
+	errJobNotFound          = errors.New("no such job")
+	errJobNotFinished       = errors.New("job has not succeeded")
+	errJobsUnavailable      = errors.New("no more jobs can be accepted")
+)
+
+// requestError wraps an error caused by the content of a request
+type requestError struct {
+	err  error
+	kind error
+}
+
+func (e *requestError) Error() string {
+	return e.err.Error()
+}
+
+func (e *requestError) Unwrap() error {
+	return e.err
+}
+
+func (e *requestError) Is(target error) bool {
+	return target == e.kind
+}
+
+// BadRequest classifies an error arising from the content of a request:
+// errors not already identified as a domain error are reported as a bad request,
+// except for a body exceeding the limit set by http.MaxBytesReader
+func BadRequest(err error) error {
+	if err == nil || statusOf(err) != http.StatusInternalServerError {
+		return err
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return &requestError{err: fmt.Errorf("the request body is larger than %d bytes", tooLarge.Limit), kind: errContentTooLarge}
+	}
+	return &requestError{err: err, kind: errBadRequest}
+}
+
+// problemKind describes how a kind of error is reported to clients
+type problemKind struct {
+	err    error
+	status int
+	code   string
+	title  string
+}
+
+// problemKinds maps errors to problems; the first matching entry applies,
+// so more specific errors must precede those they also match
+var problemKinds = []problemKind{
+	{crm.ErrNotFound, http.StatusNotFound, "not_found", "Customer not found"},
+	{errJobNotFound, http.StatusNotFound, "job_not_found", "Job not found"},
+	{crm.ErrInvalidID, http.StatusBadRequest, "invalid_id", "Invalid customer id"},
+	{crm.ErrInvalidFilter, http.StatusBadRequest, "invalid_filter", "Invalid filter expression"},
+	{crm.ErrInvalidQuery, http.StatusBadRequest, "invalid_query", "Invalid query"},
+	{crm.ErrInvalidImport, http.StatusBadRequest, "invalid_import", "Import file cannot be read"},
+	{errBadRequest, http.StatusBadRequest, "bad_request", "Malformed request"},
+	{crm.ErrValidation, http.StatusUnprocessableEntity, "validation_failed", "Customer failed validation"},
+	{errPreconditionFailed, http.StatusPreconditionFailed, "precondition_failed", "Customer has been modified"},
+	{crm.ErrVersionMismatch, http.StatusConflict, "version_conflict", "Customer was modified concurrently"},
+	{crm.ErrPatchTestFailed, http.StatusConflict, "patch_test_failed", "Patch test operation failed"},
+	{crm.ErrDuplicateEmail, http.StatusConflict, "duplicate_email", "Email is used by another customer"},
+	{crm.ErrConflict, http.StatusConflict, "conflict", "Conflict with the current state of the customer"},
+	{errJobNotFinished, http.StatusConflict, "job_not_finished", "Job has no result"},
+	{errContentTooLarge, http.StatusRequestEntityTooLarge, "content_too_large", "Request body too large"},
+	{errUnsupportedMediaType, http.StatusUnsupportedMediaType, "unsupported_media_type", "Unsupported content type"},
+	{errNotAcceptable, http.StatusNotAcceptable, "not_acceptable", "No acceptable representation"},
+	{errRouteNotFound, http.StatusNotFound, "route_not_found", "Resource not found"},
+	{errMethodNotAllowed, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed"},
+	{errNotSupported, http.StatusNotImplemented, "not_implemented", "Not supported"},
+	{errJobsUnavailable, http.StatusServiceUnavailable, "jobs_unavailable", "Job cannot be accepted"},
+}
+
+var internalError = problemKind{nil, http.StatusInternalServerError, "internal_error", "Internal server error"}
+
+func kindOf(err error) problemKind {
+	for _, kind := range problemKinds {
+		if errors.Is(err, kind.err) {
+			return kind
+		}
+	}
+	return internalError
+}
+
+// statusOf maps an error to the corresponding http status
+func statusOf(err error) int {
+	return kindOf(err).status
+}
+
+// writeError sends the problem response for an error.
+// Internal errors are logged rather than described to the client, and
+// duplicates link to the existing customer so clients can offer it instead.
+func (s *Server) writeError(writer http.ResponseWriter, request *http.Request, err error) {
+	problem := NewProblem(request, err)
+	if problem.Status == http.StatusInternalServerError {
+		s.logger.Printf("%s %s: %v", request.Method, request.URL.Path, err)
+	}
+	var duplicate *crm.DuplicateError
+	if errors.As(err, &duplicate) {
+		problem.ExistingId = duplicate.ExistingId
+		problem.Existing = fmt.Sprintf("%s/%d", s.basePath, duplicate.ExistingId)
+		writer.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"duplicate\"", problem.Existing))
+	}
+	problem.Write(writer)
+}
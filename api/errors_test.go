@@ -0,0 +1,85 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/deeprave/go-crm/crm"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStatusOf(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{fmt.Errorf("customer id 5 %w", crm.ErrNotFound), http.StatusNotFound},
+		{fmt.Errorf("%w: \"abc\"", crm.ErrInvalidID), http.StatusBadRequest},
+		{&crm.ValidationError{}, http.StatusUnprocessableEntity},
+		{crm.ErrVersionMismatch, http.StatusConflict},
+		{crm.ErrPatchTestFailed, http.StatusConflict},
+		{fmt.Errorf("%v: %w", crm.ErrVersionMismatch, errPreconditionFailed), http.StatusPreconditionFailed},
+		{errUnsupportedMediaType, http.StatusUnsupportedMediaType},
+		{BadRequest(errors.New("unexpected EOF")), http.StatusBadRequest},
+		{BadRequest(crm.ErrPatchTestFailed), http.StatusConflict},
+		{BadRequest(fmt.Errorf("multipart: NextPart: %w", &http.MaxBytesError{Limit: 10})), http.StatusRequestEntityTooLarge},
+		{fmt.Errorf("%w: \"7\"", errJobNotFound), http.StatusNotFound},
+		{errJobNotFinished, http.StatusConflict},
+		{errJobsUnavailable, http.StatusServiceUnavailable},
+		{errors.New("disk full"), http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		if status := statusOf(test.err); status != test.status {
+			t.Errorf("statusOf(%v): expected %d, got %d", test.err, test.status, status)
+		}
+	}
+}
+
+// readProblem decodes a problem response
+func readProblem(t *testing.T, writer *httptest.ResponseRecorder) *Problem {
+	if ctype := writer.Header().Get("Content-Type"); ctype != "application/problem+json" {
+		t.Errorf("Content-Type does not match: got %v want %v", ctype, "application/problem+json")
+	}
+	problem := &Problem{}
+	if err := json.Unmarshal(writer.Body.Bytes(), problem); err != nil {
+		t.Errorf("unexpected json error: %v", err)
+	}
+	return problem
+}
+
+func TestErrorResponses(t *testing.T) {
+	server := setupData(t)
+	router := server.Router()
+
+	tests := []struct {
+		method, target, body string
+		status               int
+		message              string
+	}{
+		{http.MethodGet, "/customers/abc", "", http.StatusBadRequest, "invalid id: \"abc\""},
+		{http.MethodGet, "/customers/4", "", http.StatusNotFound, "customer id 4 not found"},
+		{http.MethodPut, "/customers/4", `{"name":"Peter Rabbit"}`, http.StatusNotFound, "customer id 4 not found"},
+		{http.MethodPut, "/customers/abc", `{"name":"Peter Rabbit"}`, http.StatusBadRequest, "invalid id: \"abc\""},
+		{http.MethodPut, "/customers/5", `{"name":`, http.StatusBadRequest, "unexpected EOF"},
+		{http.MethodPut, "/customers/5", `{"role":"staff"}`, http.StatusUnprocessableEntity, "customer name is required"},
+		{http.MethodDelete, "/customers/4", "", http.StatusNotFound, "customer id 4 not found"},
+		{http.MethodDelete, "/customers/abc", "", http.StatusBadRequest, "invalid id: \"abc\""},
+		{http.MethodPost, "/customers", `{"name":`, http.StatusBadRequest, "unexpected EOF"},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest(test.method, test.target, strings.NewReader(test.body))
+		writer := httptest.NewRecorder()
+		router.ServeHTTP(writer, request)
+		if writer.Code != test.status {
+			t.Errorf("%s %s: expected status code %d, got %d", test.method, test.target, test.status, writer.Code)
+		}
+		if problem := readProblem(t, writer); problem.Detail != test.message {
+			t.Errorf("%s %s: expected detail %q, got %q", test.method, test.target, test.message, problem.Detail)
+		} else if problem.Status != test.status {
+			t.Errorf("%s %s: expected problem status %d, got %d", test.method, test.target, test.status, problem.Status)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/deeprave/go-crm/crm"
+	"net/http"
+	"strings"
+)
+
+// Each customer carries a version which is used as its entity tag,
+// allowing clients to make conditional requests:
+//   - If-None-Match on GET returns 304 Not Modified if the customer is unchanged
+//   - If-Match on PUT, PATCH and DELETE returns 412 Precondition Failed if the
+//     customer has been changed since the client fetched it
+
+func etag(c *crm.Customer) string {
+	return fmt.Sprintf("\"%d\"", c.Version)
+}
+
+func setETag(writer http.ResponseWriter, c *crm.Customer) {
+	writer.Header().Set("ETag", etag(c))
+}
+
+// etagMatches reports whether a customer matches any of the entity tags in an
+// If-Match or If-None-Match header. Weak comparison ignores the W/ prefix.
+func etagMatches(header string, c *crm.Customer, weak bool) bool {
+	tag := etag(c)
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch verifies any If-Match precondition against the current customer
+func checkIfMatch(request *http.Request, current *crm.Customer) error {
+	if header := request.Header.Get("If-Match"); header != "" && !etagMatches(header, current, false) {
+		return fmt.Errorf("customer id %d has been modified: %w", current.Id, errPreconditionFailed)
+	}
+	return nil
+}
+
+// preconditionError reports a version mismatch as a failed precondition when
+// the client made the request conditional, otherwise as a conflict
+func preconditionError(request *http.Request, err error) error {
+	if errors.Is(err, crm.ErrVersionMismatch) && request.Header.Get("If-Match") != "" {
+		return fmt.Errorf("%v: %w", err, errPreconditionFailed)
+	}
+	return err
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGetCustomerETag(t *testing.T) {
+	server := setupData(t)
+	urlVars := map[string]string{"id": "5"}
+
+	request := httptest.NewRequest(http.MethodGet, "/customers/{id}", nil)
+	result, _ := serve(t, server.getCustomer, request, urlVars)
+	tag := result.Header.Get("ETag")
+	if tag != `"1"` {
+		t.Errorf("expected ETag %q, got %q", `"1"`, tag)
+	}
+
+	request = httptest.NewRequest(http.MethodGet, "/customers/{id}", nil)
+	request.Header.Set("If-None-Match", "W/"+tag)
+	if result, _ = serve(t, server.getCustomer, request, urlVars); result.StatusCode != http.StatusNotModified {
+		t.Errorf("expected status code %d, got %d", http.StatusNotModified, result.StatusCode)
+	}
+
+	request = httptest.NewRequest(http.MethodPatch, "/customers/{id}", strings.NewReader(`{"contacted":true}`))
+	result, _ = serve(t, server.updateCustomer, request, urlVars)
+	if result.Header.Get("ETag") != `"2"` {
+		t.Errorf("expected ETag %q after update, got %q", `"2"`, result.Header.Get("ETag"))
+	}
+
+	request = httptest.NewRequest(http.MethodGet, "/customers/{id}", nil)
+	request.Header.Set("If-None-Match", tag)
+	if result, _ = serve(t, server.getCustomer, request, urlVars); result.StatusCode != http.StatusOK {
+		t.Errorf("expected status code %d, got %d", http.StatusOK, result.StatusCode)
+	}
+}
+
+func TestUpdateCustomerIfMatch(t *testing.T) {
+	server := setupData(t)
+	urlVars := map[string]string{"id": "5"}
+
+	// first editor succeeds
+	request := httptest.NewRequest(http.MethodPatch, "/customers/{id}", strings.NewReader(`{"role":"staff"}`))
+	request.Header.Set("If-Match", `"1"`)
+	if result, customer := serve(t, server.updateCustomer, request, urlVars); result.StatusCode != http.StatusOK {
+		t.Errorf("expected status code %d, got %d", http.StatusOK, result.StatusCode)
+	} else if customer.Version != 2 {
+		t.Errorf("expected version 2, got %d", customer.Version)
+	}
+
+	// second editor working from the same version is refused
+	request = httptest.NewRequest(http.MethodPut, "/customers/{id}", strings.NewReader(`{"name":"Bianca Bruxner","role":"student"}`))
+	request.Header.Set("If-Match", `"1"`)
+	if result, _ := serve(t, server.updateCustomer, request, urlVars); result.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("expected status code %d, got %d", http.StatusPreconditionFailed, result.StatusCode)
+	}
+	if customer, _ := server.Store().Get(5); customer.Role != "staff" {
+		t.Errorf("customer role changed to %s by a failed update", customer.Role)
+	}
+
+	// as is a replacement carrying a stale version
+	request = httptest.NewRequest(http.MethodPut, "/customers/{id}", strings.NewReader(`{"name":"Bianca Bruxner","version":1}`))
+	if result, _ := serve(t, server.updateCustomer, request, urlVars); result.StatusCode != http.StatusConflict {
+		t.Errorf("expected status code %d, got %d", http.StatusConflict, result.StatusCode)
+	}
+}
+
+func TestDeleteCustomerIfMatch(t *testing.T) {
+	server := setupData(t)
+	urlVars := map[string]string{"id": "5"}
+
+	request := httptest.NewRequest(http.MethodDelete, "/customers/{id}", nil)
+	request.Header.Set("If-Match", `"2", "3"`)
+	if result, _ := serve(t, server.deleteCustomer, request, urlVars); result.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("expected status code %d, got %d", http.StatusPreconditionFailed, result.StatusCode)
+	}
+
+	request = httptest.NewRequest(http.MethodDelete, "/customers/{id}", nil)
+	request.Header.Set("If-Match", `"1"`)
+	if result, _ := serve(t, server.deleteCustomer, request, urlVars); result.StatusCode != http.StatusOK {
+		t.Errorf("expected status code %d, got %d", http.StatusOK, result.StatusCode)
+	}
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/deeprave/go-crm/crm"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	mediaTypeCSV   = "text/csv"
+	mediaTypeVCard = "text/vcard"
+)
+
+// importTypes maps the media types that may be imported to the decoders reading them
+var importTypes = map[string]func(upload *importRequest) (crm.CustomerDecoder, error){
+	mediaTypeCSV: func(upload *importRequest) (crm.CustomerDecoder, error) {
+		return crm.NewCSVDecoder(bytes.NewReader(upload.data), upload.mapping)
+	},
+	mediaTypeVCard: readVCards,
+	"text/x-vcard": readVCards,
+}
+
+func readVCards(upload *importRequest) (crm.CustomerDecoder, error) {
+	return crm.NewVCardDecoder(bytes.NewReader(upload.data))
+}
+
+// maxImportSize limits the size of an import file
+const maxImportSize = 32 << 20
+
+// importRequest is an import file uploaded by a client, with the import options
+type importRequest struct {
+	mediaType string
+	data      []byte
+	mapping   crm.ColumnMapping
+	dryRun    bool
+}
+
+// readImport reads an import file from a request, either as the body itself
+// or as the "file" part of a multipart/form-data upload, which is taken to be
+// csv unless it has a content type or its name ends in .vcf. The options are given
+// by query parameters or form fields: "mapping" names a predefined column mapping
+// or gives one as a json object (see crm.ParseMapping), and "dry_run" reports
+// what the import would do without changing anything.
+func readImport(writer http.ResponseWriter, request *http.Request) (*importRequest, error) {
+	var (
+		err    error
+		params map[string]string
+		upload importRequest
+	)
+	request.Body = http.MaxBytesReader(writer, request.Body, maxImportSize)
+	if upload.mediaType, params, err = mime.ParseMediaType(request.Header.Get("Content-Type")); err != nil {
+		return nil, fmt.Errorf("%w: %v", errUnsupportedMediaType, err)
+	}
+	if upload.mediaType == "multipart/form-data" {
+		if params["boundary"] == "" {
+			return nil, BadRequest(fmt.Errorf("multipart upload has no boundary"))
+		}
+		if err = request.ParseMultipartForm(maxImportSize); err != nil {
+			return nil, BadRequest(err)
+		}
+		file, header, err := request.FormFile("file")
+		if err != nil {
+			return nil, BadRequest(fmt.Errorf("the upload has no file: %w", err))
+		}
+		defer func() {
+			_ = file.Close()
+		}()
+		upload.mediaType = mediaTypeCSV
+		if strings.HasSuffix(strings.ToLower(header.Filename), ".vcf") {
+			upload.mediaType = mediaTypeVCard
+		}
+		if contentType := header.Header.Get("Content-Type"); contentType != "" && contentType != "application/octet-stream" {
+			if upload.mediaType, _, err = mime.ParseMediaType(contentType); err != nil {
+				return nil, fmt.Errorf("%w: %v", errUnsupportedMediaType, err)
+			}
+		}
+		upload.data, err = io.ReadAll(file)
+	} else {
+		upload.data, err = io.ReadAll(request.Body)
+	}
+	if err != nil {
+		return nil, BadRequest(err)
+	}
+	if importTypes[upload.mediaType] == nil {
+		return nil, fmt.Errorf("%w: cannot import %s", errUnsupportedMediaType, upload.mediaType)
+	}
+	if value := request.FormValue("dry_run"); value != "" {
+		if upload.dryRun, err = strconv.ParseBool(value); err != nil {
+			return nil, BadRequest(fmt.Errorf("dry_run must be true or false, not %q", value))
+		}
+	}
+	if upload.mapping, err = crm.ParseMapping(request.FormValue("mapping")); err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+// decoder returns a decoder reading customers from the import file, by its media type
+func (r *importRequest) decoder() (crm.CustomerDecoder, error) {
+	return importTypes[r.mediaType](r)
+}
+
+// importCustomers creates customers from an uploaded file, validating each, and
+// reports what became of every row. Rows that fail do not prevent the others
+// being imported.
+func (s *Server) importCustomers(writer http.ResponseWriter, request *http.Request) {
+	var (
+		err     error
+		upload  *importRequest
+		decoder crm.CustomerDecoder
+		report  *crm.ImportReport
+	)
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(request.Body)
+
+	if upload, err = readImport(writer, request); err == nil {
+		if decoder, err = upload.decoder(); err == nil {
+			importer := crm.Importer{Validator: s.validator, DryRun: upload.dryRun}
+			if report, err = importer.Import(s.store, decoder); err == nil {
+				setJson(writer)
+				_ = json.NewEncoder(writer).Encode(report)
+				return
+			}
+		}
+	}
+	s.writeError(writer, request, err)
+}
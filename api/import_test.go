@@ -0,0 +1,201 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/deeprave/go-crm/crm"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const importCSV = `name,role,email,phone,contacted
+Ada Lovelace,engineer,ada@example.com,(02) 5550 1234,yes
+Tyson Again,student,tysondanks@teleworm.us,,
+,,,,
+No Email,student,not-an-email,,
+`
+
+func importReport(t *testing.T, writer *httptest.ResponseRecorder) crm.ImportReport {
+	t.Helper()
+	if writer.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, writer.Code, writer.Body.String())
+	}
+	var report crm.ImportReport
+	if err := json.Unmarshal(writer.Body.Bytes(), &report); err != nil {
+		t.Fatalf("unexpected json error: %v", err)
+	}
+	return report
+}
+
+func customerCount(t *testing.T, server *Server) int {
+	t.Helper()
+	customers, err := server.Store().List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(customers)
+}
+
+func TestImportCustomers(t *testing.T) {
+	server := setupData(t)
+	count := customerCount(t, server)
+
+	request := httptest.NewRequest(http.MethodPost, "/customers/import?dry_run=true", strings.NewReader(importCSV))
+	request.Header.Set("Content-Type", "text/csv; charset=utf-8")
+	writer := httptest.NewRecorder()
+	server.Router().ServeHTTP(writer, request)
+	report := importReport(t, writer)
+	if !report.DryRun || report.Created != 1 || report.Skipped != 2 || report.Failed != 1 || len(report.Rows) != 4 {
+		t.Errorf("unexpected dry run report %+v", report)
+	}
+	if customerCount(t, server) != count {
+		t.Errorf("dry run created customers")
+	}
+
+	request = httptest.NewRequest(http.MethodPost, "/customers/import", strings.NewReader(importCSV))
+	request.Header.Set("Content-Type", "text/csv")
+	writer = httptest.NewRecorder()
+	server.Router().ServeHTTP(writer, request)
+	report = importReport(t, writer)
+	if report.DryRun || report.Created != 1 || report.Skipped != 2 || report.Failed != 1 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if row := report.Rows[0]; row.Status != crm.ImportCreated || row.Id == 0 {
+		t.Errorf("unexpected row %+v", row)
+	} else if customer, err := server.Store().Get(row.Id); err != nil || customer.Name != "Ada Lovelace" || !customer.Contacted {
+		t.Errorf("unexpected customer %v (error %v)", customer, err)
+	}
+	if row := report.Rows[1]; row.Status != crm.ImportSkipped || row.Id != 1 {
+		t.Errorf("expected the existing email to be skipped, got %+v", row)
+	}
+	if row := report.Rows[3]; row.Status != crm.ImportFailed || len(row.Errors) != 1 || row.Errors[0].Field != "email" {
+		t.Errorf("expected the invalid email to fail, got %+v", row)
+	}
+	if customerCount(t, server) != count+1 {
+		t.Errorf("expected one customer to be created")
+	}
+}
+
+func TestImportCustomersUpload(t *testing.T) {
+	server := NewServer(&crm.CustomerTable{})
+	body := bytes.NewBuffer(nil)
+	form := multipart.NewWriter(body)
+	_ = form.WriteField("mapping", "outlook")
+	part, _ := form.CreateFormFile("file", "contacts.csv")
+	_, _ = part.Write([]byte("First Name,Middle Name,Last Name,Job Title,E-mail Address,Mobile Phone\n" +
+		"Grace,Brewster,Hopper,admiral,grace@example.com,0412 345 678\n"))
+	_ = form.Close()
+
+	request := httptest.NewRequest(http.MethodPost, "/customers/import", body)
+	request.Header.Set("Content-Type", form.FormDataContentType())
+	writer := httptest.NewRecorder()
+	server.Router().ServeHTTP(writer, request)
+	report := importReport(t, writer)
+	if report.Created != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	customer, err := server.Store().Get(report.Rows[0].Id)
+	if err != nil || customer.Name != "Grace Brewster Hopper" || customer.Role != "admiral" || customer.Phone != "0412 345 678" {
+		t.Errorf("unexpected customer %v (error %v)", customer, err)
+	}
+}
+
+func TestImportCustomersErrors(t *testing.T) {
+	tests := []struct {
+		target      string
+		contentType string
+		body        string
+		status      int
+	}{
+		{"/customers/import", "application/json", `[{"name":"Ada"}]`, http.StatusUnsupportedMediaType},
+		{"/customers/import", "", importCSV, http.StatusUnsupportedMediaType},
+		{"/customers/import", "text/csv", "", http.StatusBadRequest},
+		{"/customers/import", "text/csv", "colour,size\nred,large\n", http.StatusBadRequest},
+		{"/customers/import?mapping=thunderbird", "text/csv", importCSV, http.StatusBadRequest},
+		{"/customers/import?mapping={\"Name\":\"nickname\"}", "text/csv", importCSV, http.StatusBadRequest},
+		{"/customers/import?dry_run=maybe", "text/csv", importCSV, http.StatusBadRequest},
+		{"/customers/import", "multipart/form-data; boundary=x", "--x--\r\n", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		server := NewServer(&crm.CustomerTable{})
+		request := httptest.NewRequest(http.MethodPost, test.target, strings.NewReader(test.body))
+		if test.contentType != "" {
+			request.Header.Set("Content-Type", test.contentType)
+		}
+		writer := httptest.NewRecorder()
+		server.Router().ServeHTTP(writer, request)
+		if writer.Code != test.status {
+			t.Errorf("POST %s (%s): expected status code %d, got %d: %s",
+				test.target, test.contentType, test.status, writer.Code, writer.Body.String())
+		}
+		if customerCount(t, server) != 0 {
+			t.Errorf("POST %s (%s): customers were created", test.target, test.contentType)
+		}
+	}
+}
+
+func TestImportTooLarge(t *testing.T) {
+	server := NewServer(&crm.CustomerTable{})
+	rows := strings.Repeat("Ada Lovelace,engineer,ada@example.com,(02) 5550 1234,yes\n", maxImportSize/50)
+	body := bytes.NewBuffer(nil)
+	form := multipart.NewWriter(body)
+	part, _ := form.CreateFormFile("file", "contacts.csv")
+	_, _ = part.Write([]byte(rows))
+	_ = form.Close()
+	for contentType, body := range map[string]string{"text/csv": importCSV + rows, form.FormDataContentType(): body.String()} {
+		request := httptest.NewRequest(http.MethodPost, "/customers/import", strings.NewReader(body))
+		request.Header.Set("Content-Type", contentType)
+		writer := httptest.NewRecorder()
+		server.Router().ServeHTTP(writer, request)
+		if writer.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s: expected status code %d, got %d", contentType, http.StatusRequestEntityTooLarge, writer.Code)
+		} else if problem := readProblem(t, writer); problem.Code != "content_too_large" {
+			t.Errorf("%s: unexpected problem %+v", contentType, problem)
+		}
+	}
+}
+
+func TestImportVCards(t *testing.T) {
+	server := NewServer(&crm.CustomerTable{})
+	cards := "BEGIN:VCARD\r\nVERSION:3.0\r\nN:Vane;Harriet;;;\r\nEMAIL;TYPE=INTERNET:hvane@example.com\r\n" +
+		"TEL;TYPE=CELL:0412 345 678\r\nTITLE:author\r\nEND:VCARD\r\n" +
+		"BEGIN:VCARD\r\nVERSION:4.0\r\nFN:Peter Wimsey\r\nEMAIL:pwimsey@example.com\r\nROLE:detective\r\nEND:VCARD\r\n"
+
+	request := httptest.NewRequest(http.MethodPost, "/customers/import", strings.NewReader(cards))
+	request.Header.Set("Content-Type", "text/vcard")
+	writer := httptest.NewRecorder()
+	server.Router().ServeHTTP(writer, request)
+	report := importReport(t, writer)
+	if report.Created != 2 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	customer, err := server.Store().Get(report.Rows[0].Id)
+	if err != nil || customer.Name != "Harriet Vane" || customer.Role != "author" || customer.PhoneE164 != "+61412345678" {
+		t.Errorf("unexpected customer %v (error %v)", customer, err)
+	}
+
+	// an uploaded file named .vcf is read as vCards, whatever its content type
+	body := bytes.NewBuffer(nil)
+	form := multipart.NewWriter(body)
+	part, _ := form.CreateFormFile("file", "contacts.vcf")
+	_, _ = part.Write([]byte(strings.ReplaceAll(strings.ReplaceAll(cards, "hvane", "harriet"), "pwimsey", "peter")))
+	_ = form.Close()
+	request = httptest.NewRequest(http.MethodPost, "/customers/import?dry_run=1", body)
+	request.Header.Set("Content-Type", form.FormDataContentType())
+	writer = httptest.NewRecorder()
+	server.Router().ServeHTTP(writer, request)
+	if report = importReport(t, writer); report.Created != 2 || !report.DryRun {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	request = httptest.NewRequest(http.MethodPost, "/customers/import", strings.NewReader(importCSV))
+	request.Header.Set("Content-Type", "text/vcard")
+	writer = httptest.NewRecorder()
+	server.Router().ServeHTTP(writer, request)
+	if writer.Code != http.StatusBadRequest || readProblem(t, writer).Code != "invalid_import" {
+		t.Errorf("expected csv sent as vCards to be rejected, got %d", writer.Code)
+	}
+}
@@ -0,0 +1,620 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/deeprave/go-crm/crm"
+	"github.com/gorilla/mux"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jobsPath is the path under which jobs are served
+const jobsPath = "/jobs"
+
+// Job kinds
+const (
+	jobImport = "import"
+	jobExport = "export"
+)
+
+// Job statuses
+const (
+	JobQueued    = "queued"    // waiting for a worker
+	JobRunning   = "running"   // being run by a worker
+	JobSucceeded = "succeeded" // finished, and its result may be downloaded
+	JobFailed    = "failed"    // stopped by an error
+	JobCancelled = "cancelled" // cancelled before it finished
+)
+
+const (
+	// DefaultJobWorkers is the number of jobs run at once unless changed by WithJobWorkers
+	DefaultJobWorkers = 2
+	// maxQueuedJobs is the number of jobs that may wait for a worker
+	maxQueuedJobs = 100
+	// maxJobErrors limits the errors listed in the status of a job
+	maxJobErrors = 100
+	// jobRetention is how long a finished job is kept before it is discarded
+	jobRetention = time.Hour
+	// jobPruneInterval is how often finished jobs are looked for to be discarded
+	jobPruneInterval = time.Minute
+)
+
+// JobStatus reports the state and progress of a job. Processed counts the
+// customers read or written so far, of Total if that is known, and Counts
+// breaks them down: created, skipped and failed for an import, and exported
+// for an export. Result is the path from which the result may be downloaded,
+// once the job has succeeded.
+type JobStatus struct {
+	Id        int64          `json:"id"`
+	Kind      string         `json:"kind"`
+	Status    string         `json:"status"`
+	Created   time.Time      `json:"created"`
+	Started   *time.Time     `json:"started,omitempty"`
+	Finished  *time.Time     `json:"finished,omitempty"`
+	Processed int            `json:"processed"`
+	Total     int            `json:"total,omitempty"`
+	Progress  int            `json:"progress"` // percent complete
+	Counts    map[string]int `json:"counts"`
+	Errors    []string       `json:"errors,omitempty"`
+	Result    string         `json:"result,omitempty"`
+}
+
+// WithJobWorkers sets the number of jobs that may be run at once
+func WithJobWorkers(workers int) Option {
+	return func(s *Server) {
+		if workers > 0 {
+			s.jobs.workers = workers
+		}
+	}
+}
+
+// jobResult is the output of a job that has succeeded, spooled to a temporary
+// file so that large exports are not held in memory
+type jobResult struct {
+	mediaType string
+	filename  string
+	path      string
+}
+
+// spoolResult writes the output of a job to a temporary file, which is removed
+// when the job is discarded, or at once if writing fails
+func spoolResult(mediaType, filename string, write func(writer io.Writer) error) (*jobResult, error) {
+	file, err := os.CreateTemp("", "crm-job-*")
+	if err != nil {
+		return nil, err
+	}
+	buffered := bufio.NewWriter(file)
+	if err = write(buffered); err == nil {
+		err = buffered.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return nil, err
+	}
+	return &jobResult{mediaType: mediaType, filename: filename, path: file.Name()}, nil
+}
+
+// job is a long-running import or export, run by a worker of the job queue
+type job struct {
+	mu     sync.Mutex
+	status JobStatus
+	result *jobResult
+	ctx    context.Context
+	cancel context.CancelFunc
+	run    func(ctx context.Context, j *job) (*jobResult, error)
+}
+
+// snapshot returns a copy of the job's status
+func (j *job) snapshot() JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	status := j.status
+	status.Counts = make(map[string]int, len(j.status.Counts))
+	for key, count := range j.status.Counts {
+		status.Counts[key] = count
+	}
+	status.Errors = append([]string(nil), j.status.Errors...)
+	return status
+}
+
+// update changes the job's status while holding its lock
+func (j *job) update(change func(status *JobStatus)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	change(&j.status)
+	if j.status.Total > 0 {
+		j.status.Progress = j.status.Processed * 100 / j.status.Total
+	}
+}
+
+// addError records an error in the job's status, up to maxJobErrors
+func (status *JobStatus) addError(message string) {
+	if len(status.Errors) < maxJobErrors {
+		status.Errors = append(status.Errors, message)
+	}
+}
+
+func (status *JobStatus) finished() bool {
+	return status.Finished != nil
+}
+
+// execute runs the job unless it was cancelled while it was queued
+func (j *job) execute() {
+	j.mu.Lock()
+	if j.status.finished() {
+		j.mu.Unlock()
+		return
+	}
+	started := time.Now()
+	j.status.Status, j.status.Started = JobRunning, &started
+	j.mu.Unlock()
+
+	result, err := j.call()
+	j.update(func(status *JobStatus) {
+		finished := time.Now()
+		status.Finished = &finished
+		switch {
+		case err != nil && j.ctx.Err() != nil:
+			status.Status = JobCancelled
+		case err != nil:
+			status.Status = JobFailed
+			status.addError(err.Error())
+		default:
+			status.Status, status.Progress = JobSucceeded, 100
+			status.Result = fmt.Sprintf("%s/%d/result", jobsPath, status.Id)
+			j.result = result
+		}
+	})
+	j.cancel()
+}
+
+// call runs the job, turning a panic into an error, so that the job fails
+// rather than taking the worker, and the server, with it
+func (j *job) call() (result *jobResult, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			result, err = nil, fmt.Errorf("internal error: %v", r)
+		}
+	}()
+	return j.run(j.ctx, j)
+}
+
+// discard removes the job's result
+func (j *job) discard() {
+	j.mu.Lock()
+	result := j.result
+	j.result = nil
+	j.mu.Unlock()
+	if result != nil {
+		_ = os.Remove(result.path)
+	}
+}
+
+// stop cancels the job, which finishes at once if it has not yet started.
+// It reports whether the job was still active.
+func (j *job) stop() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.status.finished() {
+		return false
+	}
+	j.cancel()
+	if j.status.Status == JobQueued {
+		finished := time.Now()
+		j.status.Status, j.status.Finished = JobCancelled, &finished
+	}
+	return true
+}
+
+// jobQueue holds the jobs of a server, and runs them using a pool of workers
+// started when the first job is submitted, along with one discarding old jobs
+type jobQueue struct {
+	mu       sync.Mutex
+	jobs     map[int64]*job
+	sequence int64
+	queue    chan *job
+	done     chan struct{}
+	workers  int
+	start    sync.Once
+	running  sync.WaitGroup
+	closed   bool
+}
+
+func newJobQueue() *jobQueue {
+	return &jobQueue{
+		jobs:    map[int64]*job{},
+		queue:   make(chan *job, maxQueuedJobs),
+		done:    make(chan struct{}),
+		workers: DefaultJobWorkers,
+	}
+}
+
+// submit queues a job to be run, returning its initial status
+func (q *jobQueue) submit(kind string, run func(ctx context.Context, j *job) (*jobResult, error)) (JobStatus, error) {
+	q.start.Do(func() {
+		for worker := 0; worker < q.workers; worker++ {
+			q.running.Add(1)
+			go q.work()
+		}
+		q.running.Add(1)
+		go q.pruneEvery(jobPruneInterval)
+	})
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return JobStatus{}, fmt.Errorf("%w: the server is closing", errJobsUnavailable)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &job{
+		status: JobStatus{Id: q.sequence + 1, Kind: kind, Status: JobQueued, Created: time.Now(), Counts: map[string]int{}},
+		ctx:    ctx,
+		cancel: cancel,
+		run:    run,
+	}
+	select {
+	case q.queue <- j:
+	default:
+		cancel()
+		return JobStatus{}, fmt.Errorf("%w: %d jobs are already waiting", errJobsUnavailable, maxQueuedJobs)
+	}
+	q.sequence++
+	q.jobs[j.status.Id] = j
+	return j.snapshot(), nil
+}
+
+func (q *jobQueue) work() {
+	defer q.running.Done()
+	for j := range q.queue {
+		j.execute()
+	}
+}
+
+// pruneEvery discards the jobs that finished more than jobRetention ago, at an
+// interval, until the queue is closed
+func (q *jobQueue) pruneEvery(interval time.Duration) {
+	defer q.running.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			q.mu.Lock()
+			q.prune(time.Now().Add(-jobRetention))
+			q.mu.Unlock()
+		case <-q.done:
+			return
+		}
+	}
+}
+
+// prune discards the jobs that finished before a time
+func (q *jobQueue) prune(before time.Time) {
+	for id, j := range q.jobs {
+		status := j.snapshot()
+		if status.finished() && status.Finished.Before(before) {
+			delete(q.jobs, id)
+			j.discard()
+		}
+	}
+}
+
+// get returns a job by the id given in the request path
+func (q *jobQueue) get(request *http.Request) (*job, error) {
+	idString := mux.Vars(request)["id"]
+	id, err := strconv.ParseInt(idString, 10, 64)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if j, ok := q.jobs[id]; ok && err == nil {
+		return j, nil
+	}
+	return nil, fmt.Errorf("%w: %q", errJobNotFound, idString)
+}
+
+// remove discards a job, along with its result
+func (q *jobQueue) remove(id int64) {
+	q.mu.Lock()
+	j := q.jobs[id]
+	delete(q.jobs, id)
+	q.mu.Unlock()
+	if j != nil {
+		j.discard()
+	}
+}
+
+// list returns the status of every job, in id order
+func (q *jobQueue) list() []JobStatus {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	statuses := make([]JobStatus, 0, len(q.jobs))
+	for _, j := range q.jobs {
+		statuses = append(statuses, j.snapshot())
+	}
+	sort.Slice(statuses, func(i, k int) bool {
+		return statuses[i].Id < statuses[k].Id
+	})
+	return statuses
+}
+
+// close cancels every job, waits for the workers to stop, and removes the results
+func (q *jobQueue) close() {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		for _, j := range q.jobs {
+			j.stop()
+		}
+		close(q.queue)
+		close(q.done)
+	}
+	q.mu.Unlock()
+	q.running.Wait()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, j := range q.jobs {
+		j.discard()
+	}
+}
+
+// Close cancels any jobs still queued or running, and waits for those running to stop
+func (s *Server) Close() error {
+	s.jobs.close()
+	return nil
+}
+
+// formatName is the short name of a media type that may be given in place of it,
+// e.g. "csv" for text/csv or "ndjson" for application/x-ndjson
+func formatName(mediaType string) string {
+	return strings.TrimPrefix(mediaType[strings.Index(mediaType, "/")+1:], "x-")
+}
+
+// encodingFor returns the encoding named by a format: a media type or its short name
+func (s *Server) encodingFor(format string) (Encoding, error) {
+	if format == "" {
+		return s.encodings[0], nil
+	}
+	names := make([]string, 0, len(s.encodings))
+	for _, encoding := range s.encodings {
+		if strings.EqualFold(format, encoding.MediaType) || strings.EqualFold(format, formatName(encoding.MediaType)) {
+			return encoding, nil
+		}
+		names = append(names, formatName(encoding.MediaType))
+	}
+	return Encoding{}, fmt.Errorf("%w: unknown format %q, expected one of %s", crm.ErrInvalidQuery, format, strings.Join(names, ", "))
+}
+
+// writeJob sends the status of a job
+func writeJob(writer http.ResponseWriter, status JobStatus, code int) {
+	setJson(writer)
+	writer.WriteHeader(code)
+	_ = json.NewEncoder(writer).Encode(status)
+}
+
+// acceptJob sends the status of a job just submitted, with its location
+func acceptJob(writer http.ResponseWriter, status JobStatus) {
+	writer.Header().Set("Location", fmt.Sprintf("%s/%d", jobsPath, status.Id))
+	writeJob(writer, status, http.StatusAccepted)
+}
+
+// countCustomers counts the customers a decoder reads, so that an import's progress
+// can be reported; a failure stops the count, as it will stop the import
+func countCustomers(decoder crm.CustomerDecoder) int {
+	count := 0
+	for {
+		customer, err := decoder.Decode()
+		if err == io.EOF || (err != nil && customer == nil) {
+			return count
+		}
+		count++
+	}
+}
+
+// importJob starts a job importing customers from an uploaded file, taking the
+// same file and options as importCustomers. The report is the job's result.
+func (s *Server) importJob(writer http.ResponseWriter, request *http.Request) {
+	var (
+		err    error
+		upload *importRequest
+		status JobStatus
+	)
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(request.Body)
+
+	if upload, err = readImport(writer, request); err == nil {
+		// the file is checked now, so that one that cannot be read is rejected at once
+		if _, err = upload.decoder(); err == nil {
+			if status, err = s.jobs.submit(jobImport, func(ctx context.Context, j *job) (*jobResult, error) {
+				return s.runImport(ctx, j, upload)
+			}); err == nil {
+				acceptJob(writer, status)
+				return
+			}
+		}
+	}
+	s.writeError(writer, request, err)
+}
+
+// runImport counts the customers in the file, so that progress can be reported,
+// then reads the file again to import them
+func (s *Server) runImport(ctx context.Context, j *job, upload *importRequest) (*jobResult, error) {
+	decoder, err := upload.decoder()
+	if err != nil {
+		return nil, err
+	}
+	total := countCustomers(decoder)
+	if decoder, err = upload.decoder(); err != nil {
+		return nil, err
+	}
+	j.update(func(status *JobStatus) {
+		status.Total = total
+	})
+	importer := crm.Importer{
+		Validator: s.validator,
+		DryRun:    upload.dryRun,
+		Progress: func(row crm.ImportRow) {
+			j.update(func(status *JobStatus) {
+				status.Processed++
+				status.Counts[row.Status]++
+				if row.Status == crm.ImportFailed {
+					message := fmt.Sprintf("row %d: %s", row.Row, row.Reason)
+					for _, field := range row.Errors {
+						message += fmt.Sprintf("; %s %s", field.Field, field.Message)
+					}
+					status.addError(message)
+				}
+			})
+		},
+	}
+	report, err := importer.ImportContext(ctx, s.store, decoder)
+	if err != nil {
+		return nil, err
+	}
+	return spoolResult(mediaTypeJSON, "import-report.json", func(writer io.Writer) error {
+		return json.NewEncoder(writer).Encode(report)
+	})
+}
+
+// exportJob starts a job exporting the customers selected by the query parameters
+// (see crm.ParseQuery), with the fields selected by fields or exclude, in the
+// format named by the format parameter (json by default). The customers written
+// are the job's result.
+func (s *Server) exportJob(writer http.ResponseWriter, request *http.Request) {
+	var (
+		err        error
+		query      crm.Query
+		projection crm.Projection
+		encoding   Encoding
+		status     JobStatus
+	)
+	values := request.URL.Query()
+	if query, err = crm.ParseQuery(values, "format"); err == nil {
+		if projection, err = crm.ParseProjection(values); err == nil {
+			if encoding, err = s.encodingFor(values.Get("format")); err == nil {
+				if status, err = s.jobs.submit(jobExport, func(ctx context.Context, j *job) (*jobResult, error) {
+					return s.runExport(ctx, j, query, projection, encoding)
+				}); err == nil {
+					acceptJob(writer, status)
+					return
+				}
+			}
+		}
+	}
+	s.writeError(writer, request, err)
+}
+
+// runExport writes customers fetched from the store in batches, as writeCustomers does
+func (s *Server) runExport(ctx context.Context, j *job, query crm.Query, projection crm.Projection, encoding Encoding) (*jobResult, error) {
+	batches, err := s.readCustomers(query)
+	if err != nil {
+		return nil, err
+	}
+	extension := formatName(encoding.MediaType)
+	if extension == "vcard" {
+		extension = "vcf"
+	}
+	return spoolResult(encoding.MediaType, "customers."+extension, func(writer io.Writer) error {
+		return exportCustomers(ctx, j, batches, query, encoding.List(writer, projection))
+	})
+}
+
+// exportCustomers encodes the batches of customers of an export, counting them in the job's status
+func exportCustomers(ctx context.Context, j *job, batches *customerBatches, query crm.Query, encoder crm.CustomerEncoder) error {
+	for {
+		j.update(func(status *JobStatus) {
+			status.Total = batches.page.Total
+			if query.Limit != 0 {
+				status.Total = len(batches.page.Customers)
+			}
+		})
+		for index := range batches.page.Customers {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := encoder.Encode(&batches.page.Customers[index]); err != nil {
+				return err
+			}
+			j.update(func(status *JobStatus) {
+				status.Processed++
+				status.Counts["exported"]++
+			})
+		}
+		if !batches.more() {
+			break
+		}
+		if err := batches.next(); err != nil {
+			return err
+		}
+	}
+	return encoder.Close()
+}
+
+func (s *Server) getJobs(writer http.ResponseWriter, _ *http.Request) {
+	setJson(writer)
+	_ = json.NewEncoder(writer).Encode(s.jobs.list())
+}
+
+func (s *Server) getJob(writer http.ResponseWriter, request *http.Request) {
+	j, err := s.jobs.get(request)
+	if err == nil {
+		writeJob(writer, j.snapshot(), http.StatusOK)
+		return
+	}
+	s.writeError(writer, request, err)
+}
+
+// deleteJob cancels a job that is queued or running, returning its status,
+// or discards one that has finished, along with its result
+func (s *Server) deleteJob(writer http.ResponseWriter, request *http.Request) {
+	j, err := s.jobs.get(request)
+	if err == nil {
+		if j.stop() {
+			writeJob(writer, j.snapshot(), http.StatusAccepted)
+			return
+		}
+		s.jobs.remove(j.snapshot().Id)
+		writer.WriteHeader(http.StatusNoContent)
+		return
+	}
+	s.writeError(writer, request, err)
+}
+
+// getJobResult downloads the result of a job that has succeeded
+func (s *Server) getJobResult(writer http.ResponseWriter, request *http.Request) {
+	var file *os.File
+	j, err := s.jobs.get(request)
+	if err == nil {
+		j.mu.Lock()
+		result, status := j.result, j.status
+		j.mu.Unlock()
+		if result == nil {
+			err = fmt.Errorf("%w: the job is %s", errJobNotFinished, status.Status)
+		} else if file, err = os.Open(result.path); err != nil {
+			// the job was discarded since
+			err = fmt.Errorf("%w: %d", errJobNotFound, status.Id)
+		} else {
+			defer func() {
+				_ = file.Close()
+			}()
+			writer.Header().Set("Content-Type", result.mediaType)
+			writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", result.filename))
+			if info, err := file.Stat(); err == nil {
+				writer.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+			}
+			_, _ = io.Copy(writer, file)
+			return
+		}
+	}
+	s.writeError(writer, request, err)
+}
@@ -0,0 +1,356 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/deeprave/go-crm/crm"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func jobPath(id int64) string {
+	return fmt.Sprintf("%s/%d", jobsPath, id)
+}
+
+// serveJob sends a request to a server, returning the response
+func serveJob(server *Server, method, target, contentType, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, target, strings.NewReader(body))
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+	writer := httptest.NewRecorder()
+	server.Router().ServeHTTP(writer, request)
+	return writer
+}
+
+func readJob(t *testing.T, writer *httptest.ResponseRecorder, code int) JobStatus {
+	t.Helper()
+	if writer.Code != code {
+		t.Fatalf("expected status code %d, got %d: %s", code, writer.Code, writer.Body.String())
+	}
+	var status JobStatus
+	if err := json.Unmarshal(writer.Body.Bytes(), &status); err != nil {
+		t.Fatalf("unexpected json error: %v", err)
+	}
+	return status
+}
+
+// submitJob starts a job, checking it is accepted with its location
+func submitJob(t *testing.T, server *Server, target, contentType, body string) JobStatus {
+	t.Helper()
+	writer := serveJob(server, http.MethodPost, target, contentType, body)
+	status := readJob(t, writer, http.StatusAccepted)
+	if location := writer.Header().Get("Location"); location != jobPath(status.Id) {
+		t.Errorf("unexpected location %q for job %d", location, status.Id)
+	}
+	return status
+}
+
+// awaitJob polls a job until it has finished
+func awaitJob(t *testing.T, server *Server, id int64) JobStatus {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status := readJob(t, serveJob(server, http.MethodGet, jobPath(id), "", ""), http.StatusOK)
+		if status.Finished != nil {
+			return status
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %d did not finish: %+v", id, status)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestImportJob(t *testing.T) {
+	server := setupData(t)
+	defer server.Close()
+	count := customerCount(t, server)
+
+	status := submitJob(t, server, jobsPath+"/import", "text/csv", importCSV)
+	if status.Kind != jobImport || status.Status != JobQueued {
+		t.Errorf("unexpected status %+v", status)
+	}
+	status = awaitJob(t, server, status.Id)
+	if status.Status != JobSucceeded || status.Processed != 4 || status.Total != 4 || status.Progress != 100 {
+		t.Errorf("unexpected status %+v", status)
+	}
+	if status.Counts[crm.ImportCreated] != 1 || status.Counts[crm.ImportSkipped] != 2 || status.Counts[crm.ImportFailed] != 1 {
+		t.Errorf("unexpected counts %v", status.Counts)
+	}
+	if len(status.Errors) != 1 || !strings.HasPrefix(status.Errors[0], "row 4: ") {
+		t.Errorf("unexpected errors %q", status.Errors)
+	}
+	if customerCount(t, server) != count+1 {
+		t.Errorf("expected one customer to be created")
+	}
+
+	writer := serveJob(server, http.MethodGet, status.Result, "", "")
+	if writer.Code != http.StatusOK || writer.Header().Get("Content-Type") != mediaTypeJSON {
+		t.Fatalf("unexpected result %d %s", writer.Code, writer.Header().Get("Content-Type"))
+	}
+	var report crm.ImportReport
+	if err := json.Unmarshal(writer.Body.Bytes(), &report); err != nil || report.Created != 1 || len(report.Rows) != 4 {
+		t.Errorf("unexpected report %s (error %v)", writer.Body.String(), err)
+	}
+
+	// a file that cannot be read is rejected before a job is started
+	writer = serveJob(server, http.MethodPost, jobsPath+"/import", "text/csv", "colour,size\n")
+	if writer.Code != http.StatusBadRequest {
+		t.Errorf("expected status code %d, got %d", http.StatusBadRequest, writer.Code)
+	}
+}
+
+func TestExportJob(t *testing.T) {
+	server := setupData(t)
+	defer server.Close()
+
+	status := submitJob(t, server, jobsPath+"/export?role=student&sort=name&fields=id,name&format=csv", "", "")
+	status = awaitJob(t, server, status.Id)
+	if status.Status != JobSucceeded || status.Kind != jobExport || status.Processed == 0 ||
+		status.Processed != status.Total || status.Counts["exported"] != status.Total {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	writer := serveJob(server, http.MethodGet, status.Result, "", "")
+	if writer.Code != http.StatusOK || writer.Header().Get("Content-Type") != mediaTypeCSV {
+		t.Fatalf("unexpected result %d %s", writer.Code, writer.Header().Get("Content-Type"))
+	}
+	if disposition := writer.Header().Get("Content-Disposition"); disposition != `attachment; filename="customers.csv"` {
+		t.Errorf("unexpected disposition %q", disposition)
+	}
+	lines := strings.Split(strings.TrimSpace(writer.Body.String()), "\n")
+	if len(lines) != status.Total+1 || strings.TrimSpace(lines[0]) != "id,name" {
+		t.Errorf("unexpected export %q", writer.Body.String())
+	}
+
+	status = awaitJob(t, server, submitJob(t, server, jobsPath+"/export?format=vcard&limit=1", "", "").Id)
+	writer = serveJob(server, http.MethodGet, status.Result, "", "")
+	if disposition := writer.Header().Get("Content-Disposition"); disposition != `attachment; filename="customers.vcf"` ||
+		!strings.HasPrefix(writer.Body.String(), "BEGIN:VCARD\r\n") {
+		t.Errorf("unexpected vCard export %q: %q", disposition, writer.Body.String())
+	}
+
+	tests := []struct {
+		target string
+		status int
+	}{
+		{jobsPath + "/export?format=ndjson", http.StatusAccepted},
+		{jobsPath + "/export?format=application/yaml&limit=2", http.StatusAccepted},
+		{jobsPath + "/export?format=pdf", http.StatusBadRequest},
+		{jobsPath + "/export?sort=colour", http.StatusBadRequest},
+		{jobsPath + "/export?fields=colour", http.StatusBadRequest},
+		{jobsPath + "/export?formt=csv", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		if writer := serveJob(server, http.MethodPost, test.target, "", ""); writer.Code != test.status {
+			t.Errorf("POST %s: expected status code %d, got %d", test.target, test.status, writer.Code)
+		}
+	}
+}
+
+func TestJobResultFiles(t *testing.T) {
+	directory := t.TempDir()
+	t.Setenv("TMPDIR", directory)
+	spooled := func() int {
+		entries, err := os.ReadDir(directory)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return len(entries)
+	}
+	server := setupData(t)
+	defer server.Close()
+
+	status := awaitJob(t, server, submitJob(t, server, jobsPath+"/export?format=csv", "", "").Id)
+	if count := spooled(); count != 1 {
+		t.Fatalf("expected the result to be spooled to a file, found %d", count)
+	}
+	if writer := serveJob(server, http.MethodDelete, jobPath(status.Id), "", ""); writer.Code != http.StatusNoContent {
+		t.Fatalf("expected status code %d, got %d", http.StatusNoContent, writer.Code)
+	}
+	if count := spooled(); count != 0 {
+		t.Errorf("expected a discarded job's result to be removed, found %d files", count)
+	}
+
+	status = awaitJob(t, server, submitJob(t, server, jobsPath+"/import", "text/csv", importCSV).Id)
+	server.jobs.mu.Lock()
+	server.jobs.prune(time.Now().Add(time.Second))
+	server.jobs.mu.Unlock()
+	if count := spooled(); count != 0 {
+		t.Errorf("expected a pruned job's result to be removed, found %d files", count)
+	}
+	if writer := serveJob(server, http.MethodGet, status.Result, "", ""); writer.Code != http.StatusNotFound {
+		t.Errorf("expected status code %d, got %d", http.StatusNotFound, writer.Code)
+	}
+
+	awaitJob(t, server, submitJob(t, server, jobsPath+"/export", "", "").Id)
+	_ = server.Close()
+	if count := spooled(); count != 0 {
+		t.Errorf("expected results to be removed when the server closes, found %d files", count)
+	}
+}
+
+func TestPruneJobs(t *testing.T) {
+	server := setupData(t)
+	defer server.Close()
+	old := awaitJob(t, server, submitJob(t, server, jobsPath+"/export", "", "").Id)
+	recent := awaitJob(t, server, submitJob(t, server, jobsPath+"/export", "", "").Id)
+	server.jobs.mu.Lock()
+	server.jobs.jobs[old.Id].update(func(status *JobStatus) {
+		finished := time.Now().Add(-jobRetention - time.Minute)
+		status.Finished = &finished
+	})
+	server.jobs.mu.Unlock()
+
+	server.jobs.running.Add(1)
+	go server.jobs.pruneEvery(time.Millisecond)
+	deadline := time.Now().Add(5 * time.Second)
+	for serveJob(server, http.MethodGet, jobPath(old.Id), "", "").Code != http.StatusNotFound {
+		if time.Now().After(deadline) {
+			t.Fatalf("job %d was not discarded", old.Id)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if writer := serveJob(server, http.MethodGet, jobPath(recent.Id), "", ""); writer.Code != http.StatusOK {
+		t.Errorf("expected job %d to be kept, got status code %d", recent.Id, writer.Code)
+	}
+}
+
+func TestExportJobSorted(t *testing.T) {
+	table := &crm.CustomerTable{}
+	for i := 0; i < 1234; i++ {
+		table.NewCustomer(fmt.Sprintf("Customer %d", i), "student", "", "")
+	}
+	server := NewServer(table)
+	defer server.Close()
+
+	// more customers than a batch, sorted once and then fetched in batches
+	status := awaitJob(t, server, submitJob(t, server, jobsPath+"/export?sort=-name&fields=name&format=csv", "", "").Id)
+	if status.Status != JobSucceeded || status.Processed != 1234 || status.Total != 1234 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	lines := strings.Split(strings.TrimSpace(serveJob(server, http.MethodGet, status.Result, "", "").Body.String()), "\n")
+	if len(lines) != 1235 || strings.TrimSpace(lines[1]) != "Customer 999" || strings.TrimSpace(lines[1234]) != "Customer 0" {
+		t.Fatalf("unexpected export of %d lines", len(lines))
+	}
+	for index := 2; index < len(lines); index++ {
+		if strings.ToLower(lines[index-1]) < strings.ToLower(lines[index]) {
+			t.Errorf("%q is before %q", lines[index-1], lines[index])
+			break
+		}
+	}
+}
+
+// blockingStore holds up creating customers until released
+type blockingStore struct {
+	crm.CustomerStore
+	creating chan struct{}
+	release  chan struct{}
+}
+
+func (s *blockingStore) Create(customer *crm.Customer) (*crm.Customer, error) {
+	s.creating <- struct{}{}
+	<-s.release
+	return s.CustomerStore.Create(customer)
+}
+
+func TestCancelJob(t *testing.T) {
+	store := &blockingStore{CustomerStore: &crm.CustomerTable{}, creating: make(chan struct{}, 1), release: make(chan struct{})}
+	server := NewServer(store, WithJobWorkers(1))
+	defer server.Close()
+	data := "name\nHarriet Vane\nPeter Wimsey\nMervyn Bunter\n"
+
+	running := submitJob(t, server, jobsPath+"/import", "text/csv", data)
+	<-store.creating
+	queued := submitJob(t, server, jobsPath+"/import", "text/csv", data)
+
+	// the queued job is cancelled at once
+	status := readJob(t, serveJob(server, http.MethodDelete, jobPath(queued.Id), "", ""), http.StatusAccepted)
+	if status.Status != JobCancelled || status.Finished == nil {
+		t.Errorf("unexpected status %+v", status)
+	}
+	// the running job stops after the row being imported
+	status = readJob(t, serveJob(server, http.MethodDelete, jobPath(running.Id), "", ""), http.StatusAccepted)
+	if status.Status != JobRunning {
+		t.Errorf("unexpected status %+v", status)
+	}
+	close(store.release)
+	status = awaitJob(t, server, running.Id)
+	if status.Status != JobCancelled || status.Processed != 1 || status.Result != "" {
+		t.Errorf("unexpected status %+v", status)
+	}
+	if customerCount(t, server) != 1 {
+		t.Errorf("expected only the first customer to be created")
+	}
+	if writer := serveJob(server, http.MethodGet, jobPath(running.Id)+"/result", "", ""); writer.Code != http.StatusConflict {
+		t.Errorf("expected status code %d, got %d", http.StatusConflict, writer.Code)
+	}
+
+	// deleting a finished job discards it
+	var statuses []JobStatus
+	if err := json.Unmarshal(serveJob(server, http.MethodGet, jobsPath, "", "").Body.Bytes(), &statuses); err != nil || len(statuses) != 2 {
+		t.Errorf("expected two jobs, got %+v (error %v)", statuses, err)
+	}
+	if writer := serveJob(server, http.MethodDelete, jobPath(running.Id), "", ""); writer.Code != http.StatusNoContent {
+		t.Errorf("expected status code %d, got %d", http.StatusNoContent, writer.Code)
+	}
+	for _, target := range []string{jobPath(running.Id), jobsPath + "/abc"} {
+		if writer := serveJob(server, http.MethodGet, target, "", ""); writer.Code != http.StatusNotFound || readProblem(t, writer).Code != "job_not_found" {
+			t.Errorf("GET %s: expected status code %d, got %d", target, http.StatusNotFound, writer.Code)
+		}
+	}
+}
+
+// panickingStore panics when creating a customer
+type panickingStore struct {
+	crm.CustomerStore
+}
+
+func (s panickingStore) Create(*crm.Customer) (*crm.Customer, error) {
+	panic("out of cheese")
+}
+
+func TestPanickingJob(t *testing.T) {
+	server := NewServer(panickingStore{&crm.CustomerTable{}}, WithJobWorkers(1))
+	defer server.Close()
+
+	status := awaitJob(t, server, submitJob(t, server, jobsPath+"/import", "text/csv", "name\nHarriet Vane\n").Id)
+	if status.Status != JobFailed || len(status.Errors) != 1 || !strings.Contains(status.Errors[0], "out of cheese") {
+		t.Errorf("unexpected status %+v", status)
+	}
+	// the worker carries on with the next job
+	status = awaitJob(t, server, submitJob(t, server, jobsPath+"/export", "", "").Id)
+	if status.Status != JobSucceeded {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+func TestCloseJobs(t *testing.T) {
+	store := &blockingStore{CustomerStore: &crm.CustomerTable{}, creating: make(chan struct{}, 1), release: make(chan struct{})}
+	server := NewServer(store, WithJobWorkers(1))
+	running := submitJob(t, server, jobsPath+"/import", "text/csv", "name\nHarriet Vane\nPeter Wimsey\n")
+	<-store.creating
+	queued := submitJob(t, server, jobsPath+"/export", "", "")
+
+	closed := make(chan struct{})
+	go func() {
+		_ = server.Close()
+		close(closed)
+	}()
+	// the queued job is cancelled at once, and the running one once the row being imported is stored
+	awaitJob(t, server, queued.Id)
+	close(store.release)
+	<-closed
+	for _, id := range []int64{running.Id, queued.Id} {
+		if status := awaitJob(t, server, id); status.Status != JobCancelled {
+			t.Errorf("job %d: expected to be cancelled, got %+v", id, status)
+		}
+	}
+	if writer := serveJob(server, http.MethodPost, jobsPath+"/export", "", ""); writer.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status code %d, got %d", http.StatusServiceUnavailable, writer.Code)
+	}
+}
@@ -1,8 +1,10 @@
 package api
 
 // same as provided by the course, but "phone" field type changed to string due to format of phone numbers in au
+// and the lenient validator is used as the course's example customer does not have a valid email address
 
 import (
+	"github.com/deeprave/go-crm/crm"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -18,7 +20,7 @@ func TestGetCustomersHandler(t *testing.T) {
 	}
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(getCustomers)
+	handler := http.HandlerFunc(NewServer(&crm.CustomerTable{}, WithValidator(crm.LenientValidator)).getCustomers)
 	handler.ServeHTTP(rr, req)
 
 	// Checks for 200 status code
@@ -53,7 +55,7 @@ func TestAddCustomerHandler(t *testing.T) {
 	}
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(addCustomer)
+	handler := http.HandlerFunc(NewServer(&crm.CustomerTable{}, WithValidator(crm.LenientValidator)).addCustomer)
 	handler.ServeHTTP(rr, req)
 
 	// Checks for 201 status code
@@ -78,7 +80,7 @@ func TestDeleteCustomerHandler(t *testing.T) {
 	}
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(deleteCustomer)
+	handler := http.HandlerFunc(NewServer(&crm.CustomerTable{}, WithValidator(crm.LenientValidator)).deleteCustomer)
 	handler.ServeHTTP(rr, req)
 
 	// Checks for 404 status code
@@ -97,7 +99,7 @@ func TestGetCustomerHandler(t *testing.T) {
 	}
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(getCustomer)
+	handler := http.HandlerFunc(NewServer(&crm.CustomerTable{}, WithValidator(crm.LenientValidator)).getCustomer)
 	handler.ServeHTTP(rr, req)
 
 	// Checks for 404 status code
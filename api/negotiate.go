@@ -0,0 +1,167 @@
+package api
+
+import (
+	"fmt"
+	"github.com/deeprave/go-crm/crm"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Encoding writes customers in a media type. Encodings are chosen by content
+// negotiation, from those installed by default and by WithEncoding.
+type Encoding struct {
+	MediaType string
+	// List returns an encoder writing a list of customers
+	List func(writer io.Writer, projection crm.Projection) crm.CustomerEncoder
+	// One writes a single customer, with the signature of crm.Projection.WriteJSON
+	One func(projection crm.Projection, writer io.Writer, customer *crm.Customer) error
+}
+
+// WithEncoding adds an encoding, or replaces that installed for its media type
+func WithEncoding(encoding Encoding) Option {
+	return func(s *Server) {
+		for index := range s.encodings {
+			if s.encodings[index].MediaType == encoding.MediaType {
+				s.encodings[index] = encoding
+				return
+			}
+		}
+		s.encodings = append(s.encodings, encoding)
+	}
+}
+
+// jsonEncoding is the encoding used where none is negotiated
+var jsonEncoding = Encoding{
+	MediaType: mediaTypeJSON,
+	List:      crm.NewJSONEncoder,
+	One:       crm.Projection.WriteJSON,
+}
+
+// defaultEncodings are the encodings installed in every server, json first
+// as it is sent when the client expresses no preference
+func defaultEncodings() []Encoding {
+	return []Encoding{
+		jsonEncoding,
+		{
+			MediaType: mediaTypeNDJSON,
+			List:      crm.NewNDJSONEncoder,
+			One:       crm.Projection.WriteJSON,
+		},
+		{
+			MediaType: mediaTypeCSV,
+			List:      crm.NewCSVEncoder,
+			One:       writeOneOf(crm.NewCSVEncoder),
+		},
+		{
+			MediaType: "application/xml",
+			List:      crm.NewXMLEncoder,
+			One:       crm.Projection.WriteXML,
+		},
+		{
+			MediaType: "application/yaml",
+			List:      crm.NewYAMLEncoder,
+			One:       crm.Projection.WriteYAML,
+		},
+		{
+			MediaType: mediaTypeVCard,
+			List:      crm.NewVCardEncoder,
+			One:       crm.Projection.WriteVCard,
+		},
+		{
+			// the media type used for vCard 3.0 by older address books
+			MediaType: "text/x-vcard",
+			List:      crm.NewVCard3Encoder,
+			One:       crm.Projection.WriteVCard3,
+		},
+	}
+}
+
+// writeOneOf writes a single customer as a list of one
+func writeOneOf(list func(io.Writer, crm.Projection) crm.CustomerEncoder) func(crm.Projection, io.Writer, *crm.Customer) error {
+	return func(projection crm.Projection, writer io.Writer, customer *crm.Customer) error {
+		encoder := list(writer, projection)
+		if err := encoder.Encode(customer); err != nil {
+			return err
+		}
+		return encoder.Close()
+	}
+}
+
+// acceptRange is a media range from an Accept header, with its quality
+type acceptRange struct {
+	mediaType string
+	quality   float64
+}
+
+// specificity ranks a media range: exact types above type/*, above */*
+func (r acceptRange) specificity() int {
+	switch {
+	case r.mediaType == "*/*":
+		return 0
+	case strings.HasSuffix(r.mediaType, "/*"):
+		return 1
+	}
+	return 2
+}
+
+func (r acceptRange) matches(mediaType string) bool {
+	return r.mediaType == "*/*" || r.mediaType == mediaType ||
+		(r.specificity() == 1 && strings.HasPrefix(mediaType, strings.TrimSuffix(r.mediaType, "*")))
+}
+
+// parseAccept reads the media ranges of an Accept header, ignoring any that are malformed
+func parseAccept(header string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil || quality < 0 || quality > 1 {
+				continue
+			}
+		}
+		ranges = append(ranges, acceptRange{mediaType, quality})
+	}
+	return ranges
+}
+
+// negotiate chooses the encoding for the response to a request (RFC 9110 section 12.5.1):
+// each encoding takes the quality of the most specific media range matching it, and
+// that of the highest quality is chosen, the earliest installed if several are equal.
+// Without an Accept header, the first encoding (json) is chosen.
+func (s *Server) negotiate(request *http.Request) (Encoding, error) {
+	header := strings.TrimSpace(strings.Join(request.Header.Values("Accept"), ","))
+	if header == "" {
+		return s.encodings[0], nil
+	}
+	ranges := parseAccept(header)
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].specificity() > ranges[j].specificity()
+	})
+	best, bestQuality := -1, 0.0
+	for index, encoding := range s.encodings {
+		for _, r := range ranges {
+			if r.matches(encoding.MediaType) {
+				if r.quality > bestQuality {
+					best, bestQuality = index, r.quality
+				}
+				break
+			}
+		}
+	}
+	if best < 0 {
+		available := make([]string, 0, len(s.encodings))
+		for _, encoding := range s.encodings {
+			available = append(available, encoding.MediaType)
+		}
+		return Encoding{}, fmt.Errorf("%w: %s is not available, only %s", errNotAcceptable, header, strings.Join(available, ", "))
+	}
+	return s.encodings[best], nil
+}
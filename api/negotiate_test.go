@@ -0,0 +1,179 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"github.com/deeprave/go-crm/crm"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	server := NewServer(&crm.CustomerTable{})
+	tests := []struct {
+		accept   string
+		expected string
+	}{
+		{"", mediaTypeJSON},
+		{"*/*", mediaTypeJSON},
+		{"application/*", mediaTypeJSON},
+		{"text/*", "text/csv"},
+		{"text/vcard, text/x-vcard;q=0.5", mediaTypeVCard},
+		{"text/csv", "text/csv"},
+		{"application/xml, application/json;q=0.9", "application/xml"},
+		{"application/json;q=0.5, application/yaml", "application/yaml"},
+		{"text/html, application/xhtml+xml, */*;q=0.8", mediaTypeJSON},
+		{"*/*;q=0.1, application/x-ndjson", mediaTypeNDJSON},
+		{"application/*;q=0.2, application/json;q=0, text/csv;q=0.1", mediaTypeNDJSON},
+		{"TEXT/CSV", "text/csv"},
+		{"garbage;;, text/csv", "text/csv"},
+		{"text/html", ""},
+		{"application/json;q=0", ""},
+		{"*/*;q=0", ""},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest(http.MethodGet, "/customers", nil)
+		if test.accept != "" {
+			request.Header.Set("Accept", test.accept)
+		}
+		encoding, err := server.negotiate(request)
+		if test.expected == "" {
+			if statusOf(err) != http.StatusNotAcceptable {
+				t.Errorf("Accept %q: expected not acceptable, got %s (%v)", test.accept, encoding.MediaType, err)
+			}
+		} else if err != nil || encoding.MediaType != test.expected {
+			t.Errorf("Accept %q: expected %s, got %s (%v)", test.accept, test.expected, encoding.MediaType, err)
+		}
+	}
+}
+
+func TestGetCustomersRepresentations(t *testing.T) {
+	server := setupData(t)
+	router := server.Router()
+	get := func(target, accept string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, target, nil)
+		request.Header.Set("Accept", accept)
+		writer := httptest.NewRecorder()
+		router.ServeHTTP(writer, request)
+		if writer.Code == http.StatusOK && writer.Header().Get("Content-Type") != accept {
+			t.Errorf("GET %s: expected content type %s, got %s", target, accept, writer.Header().Get("Content-Type"))
+		}
+		if writer.Code != http.StatusNotFound && writer.Header().Get("Vary") != "Accept" {
+			t.Errorf("GET %s: expected Vary: Accept, got %v", target, writer.Header())
+		}
+		return writer
+	}
+
+	writer := get("/customers?role=student&sort=name", "text/csv")
+	records, err := csv.NewReader(writer.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("unexpected csv error: %v", err)
+	}
+	if len(records) != 15 || strings.Join(records[0], ",") != "id,name,role,email,phone,phone_e164,contacted,version" ||
+		records[1][1] != "Ashton Pilpel" {
+		t.Errorf("unexpected csv %v", records)
+	}
+
+	writer = get("/customers/5?fields=id,name", "text/csv")
+	if writer.Body.String() != "id,name\n5,Bianca Bruxner\n" {
+		t.Errorf("unexpected csv %q", writer.Body.String())
+	}
+
+	writer = get("/customers?limit=2", "application/xml")
+	var document struct {
+		Customers []crm.Customer `xml:"customer"`
+	}
+	if err = xml.Unmarshal(writer.Body.Bytes(), &document); err != nil || len(document.Customers) != 2 {
+		t.Errorf("unexpected xml %s (%v)", writer.Body.String(), err)
+	}
+
+	writer = get("/customers/5?exclude=phone,phone_e164,version", "application/xml")
+	if expected := xml.Header + "<customer><id>5</id><name>Bianca Bruxner</name><role>student</role><email>bbruxner@dayrep.com</email></customer>\n"; writer.Body.String() != expected {
+		t.Errorf("unexpected xml %q", writer.Body.String())
+	}
+
+	writer = get("/customers/5?fields=id,name,phone_e164", "application/yaml")
+	if expected := "id: 5\nname: Bianca Bruxner\nphone_e164: \"+61749385904\"\n"; writer.Body.String() != expected {
+		t.Errorf("unexpected yaml %q", writer.Body.String())
+	}
+
+	writer = get("/customers?fields=id&limit=2", "application/yaml")
+	if expected := "- id: 1\n- id: 2\n"; writer.Body.String() != expected {
+		t.Errorf("unexpected yaml %q", writer.Body.String())
+	}
+
+	writer = get("/customers/5", "text/vcard")
+	if expected := "BEGIN:VCARD\r\nVERSION:4.0\r\nFN:Bianca Bruxner\r\nX-CRM-ID:5\r\nN:Bruxner;Bianca;;;\r\nTITLE:student\r\n" +
+		"EMAIL:bbruxner@dayrep.com\r\nTEL;VALUE=uri:tel:+61749385904\r\nEND:VCARD\r\n"; writer.Body.String() != expected {
+		t.Errorf("unexpected vCard %q", writer.Body.String())
+	}
+
+	writer = get("/customers?role=student", "text/x-vcard")
+	var cards crm.Customers
+	if err = cards.FromVCard(writer.Body.Bytes()); err != nil || len(cards) != 14 || !strings.Contains(writer.Body.String(), "VERSION:3.0\r\n") {
+		t.Errorf("unexpected vCards %q (%v)", writer.Body.String(), err)
+	}
+
+	for _, target := range []string{"/customers", "/customers/5"} {
+		writer = get(target, "text/html")
+		if writer.Code != http.StatusNotAcceptable {
+			t.Errorf("GET %s: expected status code %d, got %d", target, http.StatusNotAcceptable, writer.Code)
+		}
+		if problem := readProblem(t, writer); problem.Code != "not_acceptable" {
+			t.Errorf("unexpected problem %+v", problem)
+		}
+	}
+
+	// a missing customer is not found, whatever the representation
+	if writer = get("/customers/99", "text/csv"); writer.Code != http.StatusNotFound {
+		t.Errorf("expected status code %d, got %d", http.StatusNotFound, writer.Code)
+	}
+}
+
+// namesEncoder writes just the names of customers, one per line
+type namesEncoder struct {
+	writer io.Writer
+}
+
+func (e namesEncoder) Encode(c *crm.Customer) error {
+	_, err := fmt.Fprintln(e.writer, c.Name)
+	return err
+}
+
+func (e namesEncoder) Close() error {
+	return nil
+}
+
+func TestWithEncoding(t *testing.T) {
+	server := setupData(t)
+	names := func(writer io.Writer, _ crm.Projection) crm.CustomerEncoder {
+		return namesEncoder{writer}
+	}
+	router := NewServer(server.Store(), WithEncoding(Encoding{
+		MediaType: "text/plain",
+		List:      names,
+		One: func(projection crm.Projection, writer io.Writer, customer *crm.Customer) error {
+			return names(writer, projection).Encode(customer)
+		},
+	})).Router()
+
+	request := httptest.NewRequest(http.MethodGet, "/customers?limit=2", nil)
+	request.Header.Set("Accept", "text/plain")
+	writer := httptest.NewRecorder()
+	router.ServeHTTP(writer, request)
+	if writer.Body.String() != "Tyson Danks\nSavannah Stout\n" {
+		t.Errorf("unexpected body %q", writer.Body.String())
+	}
+
+	request = httptest.NewRequest(http.MethodGet, "/customers/5", nil)
+	request.Header.Set("Accept", "text/*")
+	writer = httptest.NewRecorder()
+	router.ServeHTTP(writer, request)
+	if writer.Header().Get("Content-Type") != "text/csv" {
+		t.Errorf("expected csv, installed first, got %s", writer.Header().Get("Content-Type"))
+	}
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/deeprave/go-crm/crm"
+	"net/http"
+)
+
+const (
+	mediaTypeProblem = "application/problem+json"
+	problemTypeBase  = "https://github.com/deeprave/go-crm/problems/"
+)
+
+// Problem is an RFC 7807 problem details object, used for all error responses.
+// Code is a stable, machine-readable identifier for the kind of problem,
+// Errors lists any per-field validation errors, Existing (with ExistingId)
+// identifies the customer already holding a value that must be unique, and
+// Position locates the error in a filter expression.
+type Problem struct {
+	Type       string           `json:"type"`
+	Title      string           `json:"title"`
+	Status     int              `json:"status"`
+	Detail     string           `json:"detail,omitempty"`
+	Instance   string           `json:"instance,omitempty"`
+	Code       string           `json:"code"`
+	Errors     []crm.FieldError `json:"errors,omitempty"`
+	Existing   string           `json:"existing,omitempty"`
+	ExistingId int64            `json:"existing_id,omitempty"`
+	Position   int              `json:"position,omitempty"`
+}
+
+// NewProblem returns the problem describing an error that occurred handling a request
+func NewProblem(request *http.Request, err error) *Problem {
+	kind := kindOf(err)
+	problem := &Problem{
+		Type:     problemTypeBase + kind.code,
+		Title:    kind.title,
+		Status:   kind.status,
+		Detail:   err.Error(),
+		Instance: request.URL.Path,
+		Code:     kind.code,
+	}
+	if kind.status == http.StatusInternalServerError {
+		// don't leak internal details to clients
+		problem.Detail = ""
+	}
+	var validationError *crm.ValidationError
+	if errors.As(err, &validationError) {
+		problem.Errors = validationError.Fields
+	}
+	var syntaxError *crm.FilterSyntaxError
+	if errors.As(err, &syntaxError) {
+		problem.Position = syntaxError.Position
+	}
+	return problem
+}
+
+// Write sends the problem as an application/problem+json response
+func (p *Problem) Write(writer http.ResponseWriter) {
+	writer.Header().Set("Content-Type", mediaTypeProblem)
+	writer.WriteHeader(p.Status)
+	_ = json.NewEncoder(writer).Encode(p)
+}
+
+// routing problems, reported by the router itself
+
+func (s *Server) routeNotFound(writer http.ResponseWriter, request *http.Request) {
+	s.writeError(writer, request, errRouteNotFound)
+}
+
+func (s *Server) methodNotAllowed(writer http.ResponseWriter, request *http.Request) {
+	s.writeError(writer, request, fmt.Errorf("%s %w", request.Method, errMethodNotAllowed))
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"errors"
+	"github.com/deeprave/go-crm/crm"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProblemDetails(t *testing.T) {
+	server := setupData(t)
+	router := server.Router()
+
+	request := httptest.NewRequest(http.MethodPut, "/customers/5", strings.NewReader(`{"role":"staff"}`))
+	writer := httptest.NewRecorder()
+	router.ServeHTTP(writer, request)
+	problem := readProblem(t, writer)
+	expected := Problem{
+		Type:     "https://github.com/deeprave/go-crm/problems/validation_failed",
+		Title:    "Customer failed validation",
+		Status:   http.StatusUnprocessableEntity,
+		Detail:   "customer name is required",
+		Instance: "/customers/5",
+		Code:     "validation_failed",
+	}
+	if problem.Type != expected.Type || problem.Title != expected.Title || problem.Status != expected.Status ||
+		problem.Detail != expected.Detail || problem.Instance != expected.Instance || problem.Code != expected.Code {
+		t.Errorf("unexpected problem\n Expected: %+v\n   Actual: %+v", expected, *problem)
+	}
+	if len(problem.Errors) != 1 || problem.Errors[0] != (crm.FieldError{Field: "name", Message: "is required"}) {
+		t.Errorf("unexpected field errors %v", problem.Errors)
+	}
+}
+
+func TestProblemCodes(t *testing.T) {
+	server := setupData(t)
+	router := server.Router()
+
+	tests := []struct {
+		method, target, contentType, body string
+		code                              string
+	}{
+		{http.MethodGet, "/customers/abc", "", "", "invalid_id"},
+		{http.MethodGet, "/customers/4", "", "", "not_found"},
+		{http.MethodGet, "/suppliers", "", "", "route_not_found"},
+		{http.MethodPost, "/customers/5", "", "", "method_not_allowed"},
+		{http.MethodPost, "/customers", "", `{"name":`, "bad_request"},
+		{http.MethodPatch, "/customers/5", "text/plain", "name", "unsupported_media_type"},
+		{http.MethodPatch, "/customers/5", "application/json-patch+json", `[{"op":"test","path":"/role","value":"staff"}]`, "patch_test_failed"},
+		{http.MethodPut, "/customers/5", "", `{"name":"Bianca Bruxner","version":3}`, "version_conflict"},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest(test.method, test.target, strings.NewReader(test.body))
+		if test.contentType != "" {
+			request.Header.Set("Content-Type", test.contentType)
+		}
+		writer := httptest.NewRecorder()
+		router.ServeHTTP(writer, request)
+		if problem := readProblem(t, writer); problem.Code != test.code {
+			t.Errorf("%s %s: expected code %q, got %q", test.method, test.target, test.code, problem.Code)
+		} else if problem.Status != writer.Code {
+			t.Errorf("%s %s: problem status %d does not match response %d", test.method, test.target, problem.Status, writer.Code)
+		}
+	}
+}
+
+func TestInternalErrorsNotDisclosed(t *testing.T) {
+	request := httptest.NewRequest(http.MethodGet, "/customers", nil)
+	problem := NewProblem(request, errors.New("disk full"))
+	if problem.Status != http.StatusInternalServerError || problem.Code != "internal_error" {
+		t.Errorf("unexpected problem %+v", *problem)
+	}
+	if problem.Detail != "" {
+		t.Errorf("internal error detail disclosed: %q", problem.Detail)
+	}
+}
+
+func TestAddCustomerValidation(t *testing.T) {
+	server := setupData(t)
+
+	request := httptest.NewRequest(http.MethodPost, "/customers", strings.NewReader(`{"name":"Example Name","email":"Example Email","phone":"555"}`))
+	writer := httptest.NewRecorder()
+	server.Router().ServeHTTP(writer, request)
+	if writer.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status code %d, got %d", http.StatusUnprocessableEntity, writer.Code)
+	}
+	problem := readProblem(t, writer)
+	if len(problem.Errors) != 2 || problem.Errors[0].Field != "email" || problem.Errors[1].Field != "phone" {
+		t.Errorf("unexpected field errors %v", problem.Errors)
+	}
+	if count := server.Store().Count(); count != 14 {
+		t.Errorf("invalid customer was stored, count is %d", count)
+	}
+
+	// a lenient server accepts the same customer, normalized
+	server = NewServer(&crm.CustomerTable{}, WithValidator(crm.LenientValidator))
+	request = httptest.NewRequest(http.MethodPost, "/customers", strings.NewReader(`{"name":" Example  Name ","email":"Example Email","phone":"555"}`))
+	result, customer := serve(t, server.addCustomer, request, nil)
+	if result.StatusCode != http.StatusCreated {
+		t.Errorf("expected status code %d, got %d", http.StatusCreated, result.StatusCode)
+	} else if customer.Name != "Example Name" || customer.Email != "example email" {
+		t.Errorf("customer was not normalized: %v", customer)
+	}
+}
+
+func TestAddCustomerDuplicateEmail(t *testing.T) {
+	server := setupData(t)
+	if err := server.Store().(crm.UniqueEmailEnforcer).SetUniqueEmail(true); err != nil {
+		t.Fatalf("SetUniqueEmail: %v", err)
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/customers", strings.NewReader(`{"name":"Bianca Bruxner","email":" BBruxner@DayRep.com"}`))
+	writer := httptest.NewRecorder()
+	server.Router().ServeHTTP(writer, request)
+	if writer.Code != http.StatusConflict {
+		t.Errorf("expected status code %d, got %d", http.StatusConflict, writer.Code)
+	}
+	if link := writer.Header().Get("Link"); link != `</customers/5>; rel="duplicate"` {
+		t.Errorf("unexpected Link header %q", link)
+	}
+	problem := readProblem(t, writer)
+	if problem.Code != "duplicate_email" || problem.Existing != "/customers/5" || problem.ExistingId != 5 {
+		t.Errorf("unexpected problem %+v", problem)
+	}
+	if count := server.Store().Count(); count != 14 {
+		t.Errorf("duplicate customer was stored, count is %d", count)
+	}
+
+	// changing another customer's email to one in use is also a conflict
+	request = httptest.NewRequest(http.MethodPatch, "/customers/7", strings.NewReader(`{"email":"bbruxner@dayrep.com"}`))
+	writer = httptest.NewRecorder()
+	server.Router().ServeHTTP(writer, request)
+	if writer.Code != http.StatusConflict {
+		t.Errorf("expected status code %d, got %d", http.StatusConflict, writer.Code)
+	}
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/deeprave/go-crm/crm"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// searchCustomers finds customers matching the words of the "q" query parameter,
+// most relevant first. The "limit" parameter caps the number of results.
+func (s *Server) searchCustomers(writer http.ResponseWriter, request *http.Request) {
+	var (
+		customers crm.Customers
+		results   []crm.SearchResult
+	)
+	values := request.URL.Query()
+	limit, err := queryLimit(values, crm.DefaultSearchLimit)
+	if err == nil {
+		if searcher, ok := s.store.(crm.Searcher); ok {
+			results, err = searcher.Search(values.Get("q"), limit)
+		} else if customers, err = s.store.List(); err == nil {
+			results, err = crm.SearchCustomers(customers, values.Get("q"), limit)
+		}
+		if err == nil {
+			setJson(writer)
+			_ = json.NewEncoder(writer).Encode(results)
+			return
+		}
+	}
+	s.writeError(writer, request, err)
+}
+
+// suggestCustomers suggests customers whose name or email starts with the "prefix"
+// query parameter, as typed into a customer picker. The "limit" parameter caps
+// the number of suggestions.
+func (s *Server) suggestCustomers(writer http.ResponseWriter, request *http.Request) {
+	var (
+		customers   crm.Customers
+		suggestions []crm.Suggestion
+	)
+	values := request.URL.Query()
+	limit, err := queryLimit(values, crm.DefaultSuggestLimit)
+	if err == nil {
+		if suggester, ok := s.store.(crm.Suggester); ok {
+			suggestions, err = suggester.Suggest(values.Get("prefix"), limit)
+		} else if customers, err = s.store.List(); err == nil {
+			suggestions, err = crm.SuggestCustomers(customers, values.Get("prefix"), limit)
+		}
+		if err == nil {
+			setJson(writer)
+			_ = json.NewEncoder(writer).Encode(suggestions)
+			return
+		}
+	}
+	s.writeError(writer, request, err)
+}
+
+// queryLimit returns the "limit" query parameter, or fallback if it is not given
+func queryLimit(values url.Values, fallback int) (int, error) {
+	value := values.Get("limit")
+	if value == "" {
+		return fallback, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > crm.MaxQueryLimit {
+		return 0, fmt.Errorf("%w: limit must be between 1 and %d", crm.ErrInvalidQuery, crm.MaxQueryLimit)
+	}
+	return limit, nil
+}
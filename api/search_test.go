@@ -0,0 +1,105 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/deeprave/go-crm/crm"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSearchCustomers(t *testing.T) {
+	server := setupData(t)
+
+	for _, store := range []crm.CustomerStore{server.Store(), plainStore{server.Store()}} {
+		router := NewServer(store).Router()
+		request := httptest.NewRequest(http.MethodGet, "/customers/search?q=charl", nil)
+		writer := httptest.NewRecorder()
+		router.ServeHTTP(writer, request)
+		if writer.Code != http.StatusOK {
+			t.Fatalf("%T: expected status code %d, got %d", store, http.StatusOK, writer.Code)
+		}
+		var results []crm.SearchResult
+		if err := json.Unmarshal(writer.Body.Bytes(), &results); err != nil {
+			t.Errorf("unexpected json error: %v", err)
+		} else if len(results) != 2 || results[0].Customer.Id != 7 || results[1].Customer.Id != 15 {
+			t.Errorf("%T: unexpected results %v", store, results)
+		} else if results[0].Highlights["name"] != "<em>Charl</em>i Angles" {
+			t.Errorf("%T: unexpected highlights %v", store, results[0].Highlights)
+		}
+	}
+
+	tests := []struct {
+		target string
+		status int
+		count  int
+	}{
+		{"/customers/search?q=dayrep&limit=2", http.StatusOK, 2},
+		{"/customers/search?q=nobody", http.StatusOK, 0},
+		{"/customers/search?q=dayrep&limit=0", http.StatusBadRequest, 0},
+		{"/customers/search?q=", http.StatusBadRequest, 0},
+		{"/customers/search", http.StatusBadRequest, 0},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest(http.MethodGet, test.target, nil)
+		writer := httptest.NewRecorder()
+		server.Router().ServeHTTP(writer, request)
+		if writer.Code != test.status {
+			t.Errorf("GET %s: expected status code %d, got %d", test.target, test.status, writer.Code)
+			continue
+		}
+		if test.status == http.StatusOK {
+			var results []crm.SearchResult
+			if err := json.Unmarshal(writer.Body.Bytes(), &results); err != nil || len(results) != test.count {
+				t.Errorf("GET %s: expected %d results, got %s", test.target, test.count, writer.Body.String())
+			}
+		}
+	}
+}
+
+func TestSuggestCustomers(t *testing.T) {
+	server := setupData(t)
+
+	for _, store := range []crm.CustomerStore{server.Store(), plainStore{server.Store()}} {
+		router := NewServer(store).Router()
+		request := httptest.NewRequest(http.MethodGet, "/customers/suggest?prefix=CHAR", nil)
+		writer := httptest.NewRecorder()
+		router.ServeHTTP(writer, request)
+		if writer.Code != http.StatusOK {
+			t.Fatalf("%T: expected status code %d, got %d", store, http.StatusOK, writer.Code)
+		}
+		var suggestions []crm.Suggestion
+		if err := json.Unmarshal(writer.Body.Bytes(), &suggestions); err != nil {
+			t.Errorf("unexpected json error: %v", err)
+		} else if len(suggestions) != 2 || suggestions[0] != (crm.Suggestion{Id: 15, Name: "Charles Lamond"}) || suggestions[1].Id != 7 {
+			t.Errorf("%T: unexpected suggestions %v", store, suggestions)
+		}
+	}
+
+	tests := []struct {
+		target string
+		status int
+		count  int
+	}{
+		{"/customers/suggest?prefix=s&limit=2", http.StatusOK, 2},
+		{"/customers/suggest?prefix=s", http.StatusOK, 4},
+		{"/customers/suggest?prefix=xyz", http.StatusOK, 0},
+		{"/customers/suggest?prefix=s&limit=x", http.StatusBadRequest, 0},
+		{"/customers/suggest", http.StatusBadRequest, 0},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest(http.MethodGet, test.target, nil)
+		writer := httptest.NewRecorder()
+		server.Router().ServeHTTP(writer, request)
+		if writer.Code != test.status {
+			t.Errorf("GET %s: expected status code %d, got %d", test.target, test.status, writer.Code)
+			continue
+		}
+		if test.status == http.StatusOK {
+			var suggestions []crm.Suggestion
+			if err := json.Unmarshal(writer.Body.Bytes(), &suggestions); err != nil || len(suggestions) != test.count {
+				t.Errorf("GET %s: expected %d suggestions, got %s", test.target, test.count, writer.Body.String())
+			}
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"fmt"
+	"github.com/deeprave/go-crm/crm"
+	"github.com/gorilla/mux"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Server holds everything needed to serve the customer api: the store,
+// a logger and the configured options. Each Server is independent, so
+// several may be run in one process (or in parallel tests).
+type Server struct {
+	store     crm.CustomerStore
+	logger    *log.Logger
+	basePath  string
+	validator crm.Validator
+	encodings []Encoding
+	jobs      *jobQueue
+}
+
+// Option configures a Server
+type Option func(*Server)
+
+// WithLogger sets the logger used for request logging (discarded by default)
+func WithLogger(logger *log.Logger) Option {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+// WithBasePath sets the path under which the customer resources are served
+func WithBasePath(basePath string) Option {
+	return func(s *Server) {
+		s.basePath = basePath
+	}
+}
+
+// WithValidator sets the validator applied to customers when created or updated
+// (crm.DefaultValidator by default; crm.LenientValidator relaxes the format checks)
+func WithValidator(validator crm.Validator) Option {
+	return func(s *Server) {
+		s.validator = validator
+	}
+}
+
+func NewServer(store crm.CustomerStore, options ...Option) *Server {
+	s := &Server{
+		store:     store,
+		logger:    log.New(io.Discard, "", 0),
+		basePath:  "/customers",
+		validator: crm.DefaultValidator,
+		encodings: defaultEncodings(),
+		jobs:      newJobQueue(),
+	}
+	for _, option := range options {
+		option(s)
+	}
+	return s
+}
+
+func (s *Server) Store() crm.CustomerStore {
+	return s.store
+}
+
+func (s *Server) Logger() *log.Logger {
+	return s.logger
+}
+
+func (s *Server) ReadCustomerData(filename string) error {
+	if loader, ok := s.store.(crm.CustomerLoader); ok {
+		return loader.ReadCustomerData(filename)
+	}
+	return fmt.Errorf("customer store does not support loading data")
+}
+
+// Router returns a new router with the api routes and middleware installed
+func (s *Server) Router() *mux.Router {
+	return s.Middleware(s.Routes())
+}
+
+func (s *Server) Routes() *mux.Router {
+	router := mux.NewRouter()
+	router.NotFoundHandler = http.HandlerFunc(s.routeNotFound)
+	router.MethodNotAllowedHandler = http.HandlerFunc(s.methodNotAllowed)
+	basePath := s.basePath
+
+	router.HandleFunc(basePath, s.getCustomers).Methods(http.MethodGet)
+	// fixed paths are matched before those of individual customers
+	router.HandleFunc(basePath+"/duplicates", s.getDuplicates).Methods(http.MethodGet)
+	router.HandleFunc(basePath+"/search", s.searchCustomers).Methods(http.MethodGet)
+	router.HandleFunc(basePath+"/suggest", s.suggestCustomers).Methods(http.MethodGet)
+	router.HandleFunc(basePath+"/{id}", s.getCustomer).Methods(http.MethodGet)
+	router.HandleFunc(basePath, s.addCustomer).Methods(http.MethodPost)
+	router.HandleFunc(basePath+"/import", s.importCustomers).Methods(http.MethodPost)
+	router.HandleFunc(basePath+"/{id}", s.updateCustomer).Methods(http.MethodPatch, http.MethodPut)
+	router.HandleFunc(basePath+"/{id}", s.deleteCustomer).Methods(http.MethodDelete)
+	router.HandleFunc(basePath+"/{id}/merge", s.mergeCustomers).Methods(http.MethodPost)
+	router.HandleFunc(basePath+"/{id}/merges", s.getMerges).Methods(http.MethodGet)
+
+	router.HandleFunc(jobsPath, s.getJobs).Methods(http.MethodGet)
+	router.HandleFunc(jobsPath+"/import", s.importJob).Methods(http.MethodPost)
+	router.HandleFunc(jobsPath+"/export", s.exportJob).Methods(http.MethodPost)
+	router.HandleFunc(jobsPath+"/{id}", s.getJob).Methods(http.MethodGet)
+	router.HandleFunc(jobsPath+"/{id}", s.deleteJob).Methods(http.MethodDelete)
+	router.HandleFunc(jobsPath+"/{id}/result", s.getJobResult).Methods(http.MethodGet)
+	return router
+}
+
+func (s *Server) Middleware(router *mux.Router) *mux.Router {
+	router.Use(s.logRequests)
+	return router
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Flush sends any buffered data to the client, for handlers streaming their responses
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (s *Server) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		started := time.Now()
+		recorder := &statusRecorder{ResponseWriter: writer, status: http.StatusOK}
+		next.ServeHTTP(recorder, request)
+		s.logger.Printf("%s %s %d %v", request.Method, request.URL.Path, recorder.status, time.Since(started))
+	})
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/deeprave/go-crm/crm"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestServerIsolation(t *testing.T) {
+	t.Parallel()
+	first, second := setupData(t), setupData(t)
+
+	reader := strings.NewReader("{\"name\":\"Bill Gates\",\"role\":\"teacher\",\"email\":\"bill.gates@microsoft.com\",\"phone\":\"+1 (555) 555 5555\"}\n")
+	request := httptest.NewRequest(http.MethodPost, "/customers", reader)
+	writer := httptest.NewRecorder()
+	first.Router().ServeHTTP(writer, request)
+	if writer.Code != http.StatusCreated {
+		t.Fatalf("expected status code %d, got %d", http.StatusCreated, writer.Code)
+	}
+
+	if count := first.Store().Count(); count != 15 {
+		t.Errorf("first server has %d customers, expected 15", count)
+	}
+	if count := second.Store().Count(); count != 14 {
+		t.Errorf("second server has %d customers, expected 14", count)
+	}
+}
+
+func TestServerOptions(t *testing.T) {
+	t.Parallel()
+	var logged bytes.Buffer
+	server := NewServer(&crm.CustomerTable{},
+		WithBasePath("/api/customers"),
+		WithLogger(log.New(&logged, "", 0)))
+
+	request := httptest.NewRequest(http.MethodGet, "/api/customers", nil)
+	writer := httptest.NewRecorder()
+	server.Router().ServeHTTP(writer, request)
+	if writer.Code != http.StatusOK {
+		t.Errorf("expected status code %d, got %d", http.StatusOK, writer.Code)
+	}
+	if expected := "GET /api/customers 200"; !strings.HasPrefix(logged.String(), expected) {
+		t.Errorf("expected log to start with %q, got %q", expected, logged.String())
+	}
+}
+
+func TestConcurrentRequests(t *testing.T) {
+	t.Parallel()
+	server := setupData(t)
+	router := server.Router()
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < 8; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				request := httptest.NewRequest(http.MethodPost, "/customers", strings.NewReader("{\"name\":\"Bill Gates\"}"))
+				writer := httptest.NewRecorder()
+				router.ServeHTTP(writer, request)
+				var created crm.Customer
+				if err := created.FromJSON(writer.Body.Bytes()); err != nil {
+					t.Errorf("unexpected json error: %v", err)
+					return
+				}
+				request = httptest.NewRequest(http.MethodGet, "/customers", nil)
+				router.ServeHTTP(httptest.NewRecorder(), request)
+				request = httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/customers/%d", created.Id), nil)
+				writer = httptest.NewRecorder()
+				router.ServeHTTP(writer, request)
+				if writer.Code != http.StatusOK {
+					t.Errorf("delete %d: expected status code %d, got %d", created.Id, http.StatusOK, writer.Code)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if count := server.Store().Count(); count != 14 {
+		t.Errorf("server has %d customers, expected 14", count)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strconv"
+	"sync"
 )
 
 type Customer struct {
@@ -13,24 +15,109 @@ type Customer struct {
 	Role      string `json:"role,omitempty"`
 	Email     string `json:"email,omitempty"`
 	Phone     string `json:"phone,omitempty"`
+	PhoneE164 string `json:"phone_e164,omitempty"` // canonical form of Phone, set by the validator
 	Contacted bool   `json:"contacted,omitempty"`
+	// Version is incremented by the table each time the customer is changed
+	Version int64 `json:"version,omitempty"`
 }
 
 type Customers []Customer
 
+// CustomerTable is an in-memory customer "database".
+// It is safe for concurrent use; records are always returned as copies
+// so callers never hold pointers into the table itself.
+// Customers are held in a map keyed by id, with secondary indexes on
+// email, role, phone and contacted, a full text search index and a trie of
+// names and emails for suggestions, all maintained on every change.
+// Ids are allocated from a high-water mark sequence, so an id is never
+// reissued even after the customer holding it has been deleted.
+// Emails may optionally be required to be unique; see SetUniqueEmail.
+// Phone numbers in national format are read in the table's region; see SetRegion.
 type CustomerTable struct {
-	customers Customers
+	mu          sync.RWMutex
+	customers   map[int64]Customer
+	ids         []int64 // sorted, determines listing order
+	sequence    int64   // highest id ever allocated or loaded
+	byEmail     index
+	byRole      index
+	byPhone     index
+	byContacted index
+	search      *searchIndex
+	suggest     *suggestTrie
+	uniqueEmail bool
+	region      string
+	merges      []MergeRecord
 }
 
+// customerData is the data file format written by WriteCustomerData.
+// A plain json array of customers is also accepted when reading.
+// Journal is the last journal entry included in a FileStore's snapshot.
+type customerData struct {
+	Sequence  int64         `json:"sequence"`
+	Customers Customers     `json:"customers"`
+	Merges    []MergeRecord `json:"merges,omitempty"`
+	Journal   int64         `json:"journal,omitempty"`
+}
+
+// ReadCustomerData replaces the content of the table with that of a data file.
+// The id sequence is never lowered, so ids issued before the load are not reused.
 func (t *CustomerTable) ReadCustomerData(filename string) error {
+	_, err := t.readData(filename)
+	return err
+}
+
+// readData reads a data file as ReadCustomerData does, returning the last
+// journal entry it includes
+func (t *CustomerTable) readData(filename string) (int64, error) {
 	var (
-		err  error
-		data []byte
+		err     error
+		content []byte
+		data    customerData
 	)
-	if data, err = os.ReadFile(filename); err == nil {
-		err = json.Unmarshal(data, &t.customers)
+	if content, err = os.ReadFile(filename); err == nil {
+		if trimmed := bytes.TrimSpace(content); len(trimmed) > 0 && trimmed[0] == '[' {
+			err = json.Unmarshal(content, &data.Customers)
+		} else {
+			err = json.Unmarshal(content, &data)
+		}
+		if err == nil {
+			t.mu.Lock()
+			t.reset(len(data.Customers))
+			if data.Sequence > t.sequence {
+				t.sequence = data.Sequence
+			}
+			t.merges = data.Merges
+			for _, customer := range data.Customers {
+				if customer.Version == 0 {
+					customer.Version = 1
+				}
+				if customer.PhoneE164 == "" {
+					customer.PhoneE164 = CanonicalPhone(customer.Phone, t.region)
+				}
+				t.store(customer)
+			}
+			t.mu.Unlock()
+		}
 	}
-	return err
+	return data.Journal, err
+}
+
+// WriteCustomerData saves all customers and the id sequence to filename, replacing it atomically
+func (t *CustomerTable) WriteCustomerData(filename string) error {
+	return t.writeData(filename, 0)
+}
+
+// writeData writes a data file as WriteCustomerData does, recording the last
+// journal entry it includes
+func (t *CustomerTable) writeData(filename string, journal int64) error {
+	t.mu.RLock()
+	data := customerData{Sequence: t.sequence, Customers: t.collect(t.ids), Merges: t.merges, Journal: journal}
+	t.mu.RUnlock()
+	content, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filename, content)
 }
 
 func (c *Customer) ToJSON() (string, error) {
@@ -58,86 +145,324 @@ func (C *Customers) FromJSON(buffer []byte) error {
 }
 
 func (t *CustomerTable) InitCustomerTable() {
-	t.customers = make(Customers, 0, 16)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.reset(16)
+}
+
+// reset empties the table and its merge records, leaving the id sequence intact.
+// The caller must hold the lock.
+func (t *CustomerTable) reset(capacity int) {
+	t.customers = make(map[int64]Customer, capacity)
+	t.ids = make([]int64, 0, capacity)
+	t.byEmail = index{}
+	t.byRole = index{}
+	t.byPhone = index{}
+	t.byContacted = index{}
+	t.search = newSearchIndex()
+	t.suggest = newSuggestTrie()
+	t.merges = nil
 }
 
+// NewCustomer adds a customer to the table, returning nil if unique emails are
+// required and the email is already in use
 func (t *CustomerTable) NewCustomer(name, role, email, phone string) (c *Customer) {
-	customer := Customer{
-		Id:        t.NextId(),
+	customer, err := t.insert(Customer{
 		Name:      name,
 		Role:      role,
 		Email:     email,
 		Phone:     phone,
 		Contacted: false,
+	})
+	if err != nil {
+		return nil
+	}
+	return &customer
+}
+
+// insert adds a customer to the table, assigning the next id
+func (t *CustomerTable) insert(customer Customer) (Customer, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	customer.Id = t.nextId()
+	customer.Version = 1
+	if err := t.checkUnique(customer); err != nil {
+		return Customer{}, err
+	}
+	t.store(customer)
+	return customer, nil
+}
+
+// SetUniqueEmail sets whether customers are required to have distinct emails
+// (compared case-insensitively; customers without an email are exempt).
+// It fails with a DuplicateError if the table already holds duplicates.
+// Customers loaded by ReadCustomerData are not checked.
+func (t *CustomerTable) SetUniqueEmail(unique bool) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if unique {
+		for key, set := range t.byEmail {
+			if key != "" && len(set) > 1 {
+				ids := t.byEmail.ids(key)
+				return &DuplicateError{Field: "email", Value: t.customers[ids[1]].Email, ExistingId: ids[0]}
+			}
+		}
+	}
+	t.uniqueEmail = unique
+	return nil
+}
+
+// SetRegion sets the region in which phone numbers written in national format
+// are read (DefaultRegion if empty): those looked up, and those of customers
+// without a canonical form, which are indexed afresh. It should agree with the
+// region of the Validator normalizing the customers stored.
+func (t *CustomerTable) SetRegion(region string) error {
+	if _, ok := Regions[region]; !ok && region != "" {
+		return fmt.Errorf("unknown phone region %q", region)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.region = region
+	t.byPhone = index{}
+	for _, id := range t.ids {
+		t.byPhone.add(phoneKey(t.customers[id], region), id)
+	}
+	return nil
+}
+
+// checkUnique verifies that storing the customer would not duplicate another's email.
+// The caller must hold the lock.
+func (t *CustomerTable) checkUnique(customer Customer) error {
+	if key := emailKey(customer.Email); t.uniqueEmail && key != "" {
+		for _, id := range t.byEmail.ids(key) {
+			if id != customer.Id {
+				return &DuplicateError{Field: "email", Value: customer.Email, ExistingId: id}
+			}
+		}
+	}
+	return nil
+}
+
+// put stores a customer as-is, replacing any existing record with the same id
+func (t *CustomerTable) put(customer Customer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.store(customer)
+}
+
+// store adds or replaces a customer and maintains the indexes.
+// The caller must hold the lock.
+func (t *CustomerTable) store(customer Customer) {
+	if t.customers == nil {
+		t.reset(16)
+	}
+	if previous, ok := t.customers[customer.Id]; ok {
+		t.unindex(previous)
+	} else {
+		t.ids = insertId(t.ids, customer.Id)
+	}
+	t.customers[customer.Id] = customer
+	t.index(customer)
+	if customer.Id > t.sequence {
+		t.sequence = customer.Id
 	}
-	index := len(t.customers)
-	t.customers = append(t.customers, customer)
-	return &t.customers[index]
+}
+
+// remove deletes a customer and its index entries.
+// The caller must hold the lock.
+func (t *CustomerTable) remove(id int64) (Customer, bool) {
+	customer, ok := t.customers[id]
+	if ok {
+		t.unindex(customer)
+		delete(t.customers, id)
+		t.ids = removeId(t.ids, id)
+	}
+	return customer, ok
+}
+
+func (t *CustomerTable) index(customer Customer) {
+	t.byEmail.add(emailKey(customer.Email), customer.Id)
+	t.byRole.add(roleKey(customer.Role), customer.Id)
+	t.byPhone.add(phoneKey(customer, t.region), customer.Id)
+	t.byContacted.add(strconv.FormatBool(customer.Contacted), customer.Id)
+	t.search.add(customer)
+	t.suggest.add(customer)
+}
+
+func (t *CustomerTable) unindex(customer Customer) {
+	t.byEmail.remove(emailKey(customer.Email), customer.Id)
+	t.byRole.remove(roleKey(customer.Role), customer.Id)
+	t.byPhone.remove(phoneKey(customer, t.region), customer.Id)
+	t.byContacted.remove(strconv.FormatBool(customer.Contacted), customer.Id)
+	t.search.remove(customer)
+	t.suggest.remove(customer)
 }
 
 func (t *CustomerTable) Count() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
 	return len(t.customers)
 }
 
 func (t *CustomerTable) NextId() int64 {
-	var highestId int64 = 0
-	for index := 0; index < t.Count(); index++ {
-		if t.customers[index].Id > highestId {
-			highestId = t.customers[index].Id
-		}
-	}
-	return highestId + 1
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.nextId()
+}
+
+// nextId requires the caller to hold the lock
+func (t *CustomerTable) nextId() int64 {
+	return t.sequence + 1
+}
+
+// Sequence returns the highest id allocated (or loaded) so far
+func (t *CustomerTable) Sequence() int64 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.sequence
 }
 
+// GetAllCustomers returns a copy of all customers in the table, ordered by id
 func (t *CustomerTable) GetAllCustomers() *Customers {
-	return &t.customers
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	customers := t.collect(t.ids)
+	return &customers
+}
+
+// collect returns copies of the customers with the given ids.
+// The caller must hold the lock.
+func (t *CustomerTable) collect(ids []int64) Customers {
+	customers := make(Customers, 0, len(ids))
+	for _, id := range ids {
+		customers = append(customers, t.customers[id])
+	}
+	return customers
 }
 
+// GetCustomerById returns a copy of the customer with the given id, or nil if not found
 func (t *CustomerTable) GetCustomerById(id int64) *Customer {
-	for index := 0; index < len(t.customers); index++ {
-		if t.customers[index].Id == id {
-			return &t.customers[index]
-		}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if customer, ok := t.customers[id]; ok {
+		return &customer
 	}
 	return nil
 }
 
+// FindByEmail returns the customers with the given email address (case-insensitive)
+func (t *CustomerTable) FindByEmail(email string) Customers {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.collect(t.byEmail.ids(emailKey(email)))
+}
+
+// FindByRole returns the customers with the given role (case-insensitive)
+func (t *CustomerTable) FindByRole(role string) Customers {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.collect(t.byRole.ids(roleKey(role)))
+}
+
+// FindByPhone returns the customers with the given phone number, which may be
+// written in any format ParsePhone understands, e.g. "0749385904" or "+61 7 4938 5904"
+func (t *CustomerTable) FindByPhone(phone string) Customers {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	key := PhoneKey(phone, t.region)
+	if key == "" {
+		return Customers{}
+	}
+	return t.collect(t.byPhone.ids(key))
+}
+
+// FindByContacted returns the customers that have (or have not) been contacted
+func (t *CustomerTable) FindByContacted(contacted bool) Customers {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.collect(t.byContacted.ids(strconv.FormatBool(contacted)))
+}
+
 func (t *CustomerTable) DeleteCustomerById(id int64) (*Customer, error) {
-	var (
-		customer Customer
-	)
-	for index := 0; index < len(t.customers); index++ {
-		if t.customers[index].Id == id {
-			customer = t.customers[index]
-			length := len(t.customers)
-			copy(t.customers[index:], t.customers[index+1:])
-			t.customers = t.customers[:length-1]
-			return &customer, nil
-		}
+	return t.DeleteCustomerVersion(id, 0)
+}
+
+// DeleteCustomerVersion deletes a customer provided it is still at the given
+// version; a version of 0 deletes the customer regardless
+func (t *CustomerTable) DeleteCustomerVersion(id int64, version int64) (*Customer, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	customer, ok := t.customers[id]
+	if !ok {
+		return nil, notFound(id)
+	}
+	if err := checkVersion(customer, version); err != nil {
+		return nil, err
+	}
+	t.remove(id)
+	return &customer, nil
+}
+
+// checkVersion verifies that the customer is at the expected version (if any)
+func checkVersion(customer Customer, version int64) error {
+	if version != 0 && version != customer.Version {
+		return fmt.Errorf("customer id %d %w: expected version %d, found %d", customer.Id, ErrVersionMismatch, version, customer.Version)
+	}
+	return nil
+}
+
+// ReplaceCustomerById replaces every field of an existing customer other than its id.
+// If c.Version is set the customer must still be at that version.
+func (t *CustomerTable) ReplaceCustomerById(id int64, c *Customer) (*Customer, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	current, ok := t.customers[id]
+	if !ok {
+		return nil, notFound(id)
 	}
-	return nil, fmt.Errorf("customer id %d not found", id)
+	if err := checkVersion(current, c.Version); err != nil {
+		return nil, err
+	}
+	customer := *c
+	customer.Id = id
+	customer.Version = current.Version + 1
+	if err := t.checkUnique(customer); err != nil {
+		return nil, err
+	}
+	t.store(customer)
+	return &customer, nil
 }
 
+// UpdateCustomerById merges the non-empty fields of v into an existing customer.
+// Empty strings and false are ignored, so fields can be set but never cleared;
+// use ReplaceCustomerById (or a merge patch) to do that.
 func (t *CustomerTable) UpdateCustomerById(id int64, v *Customer) (*Customer, error) {
-	customer := t.GetCustomerById(id)
-	if customer == nil {
-		return nil, fmt.Errorf("customer id %d not found", id)
-	} else {
-		if v.Name != "" {
-			customer.Name = v.Name
-		}
-		if v.Role != "" {
-			customer.Role = v.Role
-		}
-		if v.Email != "" {
-			customer.Email = v.Email
-		}
-		if v.Phone != "" {
-			customer.Phone = v.Phone
-		}
-		if v.Contacted {
-			customer.Contacted = v.Contacted
-		}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	customer, ok := t.customers[id]
+	if !ok {
+		return nil, notFound(id)
 	}
-	return customer, nil
+	if v.Name != "" {
+		customer.Name = v.Name
+	}
+	if v.Role != "" {
+		customer.Role = v.Role
+	}
+	if v.Email != "" {
+		customer.Email = v.Email
+	}
+	if v.Phone != "" {
+		customer.Phone = v.Phone
+		customer.PhoneE164 = CanonicalPhone(v.Phone, t.region)
+	}
+	if v.Contacted {
+		customer.Contacted = v.Contacted
+	}
+	customer.Version++
+	if err := t.checkUnique(customer); err != nil {
+		return nil, err
+	}
+	t.store(customer)
+	return &customer, nil
 }
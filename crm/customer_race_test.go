@@ -0,0 +1,113 @@
+package crm
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// Stress tests exercising the table from many goroutines at once.
+// These are most useful when run with the race detector:
+//   go test -race ./crm
+
+const (
+	stressWorkers    = 16
+	stressIterations = 200
+)
+
+func TestConcurrentNewCustomer(t *testing.T) {
+	customerTable := ReadCustomers(t)
+	initial := customerTable.Count()
+
+	var (
+		wg  sync.WaitGroup
+		mu  sync.Mutex
+		ids = map[int64]bool{}
+	)
+	for worker := 0; worker < stressWorkers; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := 0; i < stressIterations; i++ {
+				name := fmt.Sprintf("worker %d customer %d", worker, i)
+				customer := customerTable.NewCustomer(name, "student", "", "")
+				mu.Lock()
+				if ids[customer.Id] {
+					t.Errorf("duplicate customer id %d issued", customer.Id)
+				}
+				ids[customer.Id] = true
+				mu.Unlock()
+			}
+		}(worker)
+	}
+	wg.Wait()
+
+	expected := initial + stressWorkers*stressIterations
+	if count := customerTable.Count(); count != expected {
+		t.Errorf("customer count is %d, expected %d", count, expected)
+	}
+}
+
+func TestConcurrentMixedOperations(t *testing.T) {
+	customerTable := ReadCustomers(t)
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < stressWorkers; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := 0; i < stressIterations; i++ {
+				created, err := customerTable.Create(&Customer{Name: fmt.Sprintf("w%d-%d", worker, i), Role: "student"})
+				if err != nil {
+					t.Errorf("Create: %v", err)
+					return
+				}
+				if _, err = customerTable.Update(created.Id, &Customer{Name: created.Name, Role: "teacher", Contacted: true}); err != nil {
+					t.Errorf("Update: %v", err)
+				}
+				if customer := customerTable.GetCustomerById(created.Id); customer == nil {
+					t.Errorf("customer %d not found after create", created.Id)
+				} else if customer.Name != created.Name {
+					t.Errorf("customer %d name is %s, expected %s", created.Id, customer.Name, created.Name)
+				}
+				_ = customerTable.GetAllCustomers()
+				_ = customerTable.NextId()
+				if deleted, err := customerTable.Delete(created.Id, 0); err != nil {
+					t.Errorf("Delete: %v", err)
+				} else if deleted.Id != created.Id {
+					t.Errorf("deleted customer id is %d, expected %d", deleted.Id, created.Id)
+				}
+			}
+		}(worker)
+	}
+	wg.Wait()
+
+	if count := customerTable.Count(); count != 14 {
+		t.Errorf("customer count is %d, expected 14", count)
+	}
+}
+
+func TestReturnedCustomersAreCopies(t *testing.T) {
+	customerTable := ReadCustomers(t)
+
+	customer := customerTable.GetCustomerById(5)
+	customer.Name = "Changed"
+	if stored := customerTable.GetCustomerById(5); stored.Name == "Changed" {
+		t.Errorf("GetCustomerById returned a pointer into the table")
+	}
+
+	all := customerTable.GetAllCustomers()
+	(*all)[0].Name = "Changed"
+	if stored := customerTable.GetCustomerById((*all)[0].Id); stored.Name == "Changed" {
+		t.Errorf("GetAllCustomers returned the table's own slice")
+	}
+
+	created := customerTable.NewCustomer("Peter Rabbit", "teacher", "", "")
+	// deleting an earlier record shifts the table; the returned record must be unaffected
+	if _, err := customerTable.DeleteCustomerById(1); err != nil {
+		t.Fatalf("DeleteCustomerById: %v", err)
+	}
+	if created.Name != "Peter Rabbit" {
+		t.Errorf("NewCustomer result changed after delete: %v", created)
+	}
+}
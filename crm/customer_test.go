@@ -5,6 +5,7 @@
 package crm
 
 import (
+	"errors"
 	"strings"
 	"testing"
 )
@@ -250,3 +251,93 @@ func TestCustomersFromJSON(t *testing.T) {
 		t.Errorf("Customer.ToJSON failed\n Expected: %v\n   Actual: %v", expected, customers)
 	}
 }
+
+func TestDeletedIdsNotReused(t *testing.T) {
+	customerTable := ReadCustomers(t)
+
+	if _, err := customerTable.DeleteCustomerById(19); err != nil {
+		t.Fatalf("DeleteCustomerById: %v", err)
+	}
+	customer := customerTable.NewCustomer("Peter Rabbit", "teacher", "", "")
+	if customer.Id != 20 {
+		t.Errorf("new customer Id is %d, expected 20", customer.Id)
+	}
+	if _, err := customerTable.DeleteCustomerById(20); err != nil {
+		t.Fatalf("DeleteCustomerById: %v", err)
+	}
+	if nextId := customerTable.NextId(); nextId != 21 {
+		t.Errorf("next customer Id is %d, expected 21", nextId)
+	}
+}
+
+func TestSequenceSurvivesReload(t *testing.T) {
+	customerTable := ReadCustomers(t)
+	for i := 0; i < 5; i++ {
+		customerTable.NewCustomer("Peter Rabbit", "teacher", "", "")
+	}
+	if sequence := customerTable.Sequence(); sequence != 24 {
+		t.Errorf("sequence is %d, expected 24", sequence)
+	}
+
+	// reloading the original data must not lower the sequence
+	if err := customerTable.ReadCustomerData(DATAFILE); err != nil {
+		t.Fatalf("ReadCustomerData: %v", err)
+	}
+	if nextId := customerTable.NextId(); nextId != 25 {
+		t.Errorf("next customer Id is %d, expected 25", nextId)
+	}
+
+	// the sequence is saved along with the data
+	filename := t.TempDir() + "/customers.json"
+	if _, err := customerTable.DeleteCustomerById(19); err != nil {
+		t.Fatalf("DeleteCustomerById: %v", err)
+	}
+	if err := customerTable.WriteCustomerData(filename); err != nil {
+		t.Fatalf("WriteCustomerData: %v", err)
+	}
+	reloaded := &CustomerTable{}
+	if err := reloaded.ReadCustomerData(filename); err != nil {
+		t.Fatalf("ReadCustomerData: %v", err)
+	}
+	if count := reloaded.Count(); count != 13 {
+		t.Errorf("customer count is %d, expected 13", count)
+	}
+	if nextId := reloaded.NextId(); nextId != 25 {
+		t.Errorf("next customer Id is %d, expected 25", nextId)
+	}
+}
+
+func TestCustomerVersions(t *testing.T) {
+	customerTable := ReadCustomers(t)
+
+	if customer := customerTable.GetCustomerById(5); customer.Version != 1 {
+		t.Errorf("loaded customer version is %d, expected 1", customer.Version)
+	}
+	customer := customerTable.NewCustomer("Peter Rabbit", "teacher", "", "")
+	if customer.Version != 1 {
+		t.Errorf("new customer version is %d, expected 1", customer.Version)
+	}
+
+	replaced, err := customerTable.ReplaceCustomerById(customer.Id, &Customer{Name: "Peter Rabbit", Role: "student", Version: 1})
+	if err != nil {
+		t.Fatalf("ReplaceCustomerById: %v", err)
+	} else if replaced.Version != 2 {
+		t.Errorf("replaced customer version is %d, expected 2", replaced.Version)
+	}
+	if updated, err := customerTable.UpdateCustomerById(customer.Id, &Customer{Contacted: true}); err != nil {
+		t.Fatalf("UpdateCustomerById: %v", err)
+	} else if updated.Version != 3 {
+		t.Errorf("updated customer version is %d, expected 3", updated.Version)
+	}
+
+	// changes against a stale version are rejected
+	if _, err = customerTable.ReplaceCustomerById(customer.Id, &Customer{Name: "Peter Rabbit", Version: 2}); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("ReplaceCustomerById with stale version: expected ErrVersionMismatch, got %v", err)
+	}
+	if _, err = customerTable.DeleteCustomerVersion(customer.Id, 2); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("DeleteCustomerVersion with stale version: expected ErrVersionMismatch, got %v", err)
+	}
+	if _, err = customerTable.DeleteCustomerVersion(customer.Id, 3); err != nil {
+		t.Errorf("DeleteCustomerVersion: %v", err)
+	}
+}
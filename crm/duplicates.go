@@ -0,0 +1,112 @@
+package crm
+
+import (
+	"math"
+	"sort"
+)
+
+// DefaultDuplicateThreshold is the name similarity above which two customers
+// are reported as likely duplicates
+const DefaultDuplicateThreshold = 0.9
+
+// Reasons a pair of customers is considered a likely duplicate
+const (
+	DuplicateEmail = "email"
+	DuplicatePhone = "phone"
+	DuplicateName  = "name"
+)
+
+// DuplicateMatch is a pair of customers that are likely to be the same person.
+// Customer has the lower id. Score ranges from 0 to 1, and Reasons lists
+// what the two have in common.
+type DuplicateMatch struct {
+	Customer  Customer `json:"customer"`
+	Duplicate Customer `json:"duplicate"`
+	Score     float64  `json:"score"`
+	Reasons   []string `json:"reasons"`
+}
+
+// FindDuplicates finds the pairs of customers that are likely to be the same
+// person: those sharing an email or phone number (compared in normalized and
+// canonical form), or whose names are at least threshold similar. Rather than
+// comparing every pair, customers are only compared with those sharing an
+// email, phone number or the sound of a surname. Matches are ordered by
+// descending score. Phone numbers without a canonical form are read in region
+// (DefaultRegion if empty).
+func FindDuplicates(customers Customers, threshold float64, region string) []DuplicateMatch {
+	blocks := map[string][]int{}
+	for position, customer := range customers {
+		for _, key := range blockingKeys(customer, region) {
+			blocks[key] = append(blocks[key], position)
+		}
+	}
+
+	type pair struct{ a, b int }
+	compared := map[pair]bool{}
+	matches := []DuplicateMatch{}
+	for _, positions := range blocks {
+		for i := 0; i < len(positions); i++ {
+			for j := i + 1; j < len(positions); j++ {
+				candidates := pair{positions[i], positions[j]}
+				if compared[candidates] {
+					continue
+				}
+				compared[candidates] = true
+				if match, ok := compareCustomers(customers[candidates.a], customers[candidates.b], threshold, region); ok {
+					matches = append(matches, match)
+				}
+			}
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		if matches[i].Customer.Id != matches[j].Customer.Id {
+			return matches[i].Customer.Id < matches[j].Customer.Id
+		}
+		return matches[i].Duplicate.Id < matches[j].Duplicate.Id
+	})
+	return matches
+}
+
+// blockingKeys returns the keys under which a customer is grouped for comparison
+func blockingKeys(customer Customer, region string) []string {
+	var keys []string
+	if email := emailKey(customer.Email); email != "" {
+		keys = append(keys, "email:"+email)
+	}
+	if phone := phoneKey(customer, region); phone != "" {
+		keys = append(keys, "phone:"+phone)
+	}
+	if tokens := nameTokens(customer.Name); len(tokens) > 0 {
+		keys = append(keys, "name:"+soundex(tokens[len(tokens)-1]))
+	}
+	return keys
+}
+
+// compareCustomers scores a pair of customers, reporting whether they are likely duplicates
+func compareCustomers(a, b Customer, threshold float64, region string) (DuplicateMatch, bool) {
+	if a.Id > b.Id {
+		a, b = b, a
+	}
+	match := DuplicateMatch{Customer: a, Duplicate: b, Reasons: []string{}}
+	if email := emailKey(a.Email); email != "" && email == emailKey(b.Email) {
+		match.Reasons = append(match.Reasons, DuplicateEmail)
+		match.Score = 1
+	}
+	if phone := phoneKey(a, region); phone != "" && phone == phoneKey(b, region) {
+		match.Reasons = append(match.Reasons, DuplicatePhone)
+		if match.Score < 0.95 {
+			match.Score = 0.95
+		}
+	}
+	if score := nameSimilarity(a.Name, b.Name); score >= threshold {
+		match.Reasons = append(match.Reasons, DuplicateName)
+		if score > match.Score {
+			match.Score = score
+		}
+	}
+	match.Score = math.Round(match.Score*1000) / 1000
+	return match, len(match.Reasons) > 0
+}
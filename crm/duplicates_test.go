@@ -0,0 +1,44 @@
+package crm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFindDuplicates(t *testing.T) {
+	customerTable := ReadCustomers(t)
+
+	// the sample data holds no duplicates
+	if matches := customerTable.FindDuplicates(DefaultDuplicateThreshold); len(matches) != 0 {
+		t.Errorf("FindDuplicates found %v in the sample data", matches)
+	}
+
+	customerTable.NewCustomer("B. Bruxner", "student", "", "")
+	customerTable.NewCustomer("Spencer Keniff", "student", "", "+61 3 5382 0404")
+	customerTable.NewCustomer("Jeremy Smith", "student", "JSodeman@example.com", "")
+	customerTable.NewCustomer("Jess Smith", "student", "jsodeman@example.com", "")
+
+	matches := customerTable.FindDuplicates(DefaultDuplicateThreshold)
+	found := map[[2]int64]string{}
+	for _, match := range matches {
+		found[[2]int64{match.Customer.Id, match.Duplicate.Id}] = strings.Join(match.Reasons, ",")
+	}
+	expected := map[[2]int64]string{
+		{5, 20}:  "name",
+		{11, 21}: "phone,name",
+		{22, 23}: "email",
+	}
+	for pair, reasons := range expected {
+		if found[pair] != reasons {
+			t.Errorf("customers %v: expected reasons %q, got %q", pair, reasons, found[pair])
+		}
+	}
+	if len(matches) != len(expected) {
+		t.Errorf("FindDuplicates returned %v, expected %d matches", matches, len(expected))
+	}
+	for index := 1; index < len(matches); index++ {
+		if matches[index-1].Score < matches[index].Score {
+			t.Errorf("matches not ordered by score: %v", matches)
+		}
+	}
+}
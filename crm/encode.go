@@ -0,0 +1,220 @@
+package crm
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+// CustomerEncoder writes customers to a stream one at a time, so that lists of
+// any size can be written without first being held in memory
+type CustomerEncoder interface {
+	// Encode writes the next customer
+	Encode(c *Customer) error
+	// Close completes the stream, and must be called even if no customer was written
+	Close() error
+}
+
+// jsonEncoder writes customers as a json array
+type jsonEncoder struct {
+	writer     io.Writer
+	projection Projection
+	count      int
+}
+
+// NewJSONEncoder returns an encoder writing the selected fields of customers as a
+// json array, as written by Projection.CustomersToJSON
+func NewJSONEncoder(writer io.Writer, projection Projection) CustomerEncoder {
+	return &jsonEncoder{writer: writer, projection: projection}
+}
+
+func (e *jsonEncoder) Encode(c *Customer) error {
+	separator := ","
+	if e.count == 0 {
+		separator = "["
+	}
+	e.count++
+	if _, err := io.WriteString(e.writer, separator); err != nil {
+		return err
+	}
+	return e.projection.writeJSON(e.writer, c)
+}
+
+func (e *jsonEncoder) Close() error {
+	end := "]\n"
+	if e.count == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(e.writer, end)
+	return err
+}
+
+// ndjsonEncoder writes customers as newline delimited json
+type ndjsonEncoder struct {
+	writer     io.Writer
+	projection Projection
+}
+
+// NewNDJSONEncoder returns an encoder writing the selected fields of each customer
+// as a json object on a line of its own (see https://github.com/ndjson/ndjson-spec),
+// so that they may be read one at a time
+func NewNDJSONEncoder(writer io.Writer, projection Projection) CustomerEncoder {
+	return &ndjsonEncoder{writer: writer, projection: projection}
+}
+
+func (e *ndjsonEncoder) Encode(c *Customer) error {
+	if err := e.projection.writeJSON(e.writer, c); err != nil {
+		return err
+	}
+	_, err := io.WriteString(e.writer, "\n")
+	return err
+}
+
+func (e *ndjsonEncoder) Close() error {
+	return nil
+}
+
+// csvEncoder writes customers as comma separated values
+type csvEncoder struct {
+	writer *csv.Writer
+	fields []CustomerField
+	header bool
+}
+
+// NewCSVEncoder returns an encoder writing the selected fields of customers as
+// comma separated values (RFC 4180), headed by a row of the field names.
+// Unlike the json encoders, empty fields are written, so every row has every column.
+// Values a spreadsheet would take for a formula are prefixed with an apostrophe
+// (see neutralizeFormula), which the csv decoder removes again.
+func NewCSVEncoder(writer io.Writer, projection Projection) CustomerEncoder {
+	return &csvEncoder{writer: csv.NewWriter(writer), fields: projection.fields()}
+}
+
+func (e *csvEncoder) writeHeader() error {
+	if e.header {
+		return nil
+	}
+	e.header = true
+	names := make([]string, 0, len(e.fields))
+	for _, field := range e.fields {
+		names = append(names, field.Name)
+	}
+	return e.writer.Write(names)
+}
+
+func (e *csvEncoder) Encode(c *Customer) error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	record := make([]string, 0, len(e.fields))
+	for _, field := range e.fields {
+		record = append(record, neutralizeFormula(field.String(c)))
+	}
+	return e.writer.Write(record)
+}
+
+// formulaPrefixes are the characters starting a value that spreadsheets evaluate as a formula
+const formulaPrefixes = "=+-@\t\r"
+
+// neutralizeFormula guards against csv injection, where a value opened in a
+// spreadsheet runs as a formula, by prefixing a value starting as a formula
+// does with an apostrophe. International phone numbers, a + followed only by
+// digits and spaces, are left as they are, while a value already starting with
+// an apostrophe has another added, so that restoreFormula gives it back exactly.
+func neutralizeFormula(value string) string {
+	if value == "" || !strings.ContainsRune(formulaPrefixes+"'", rune(value[0])) {
+		return value
+	}
+	if value[0] == '+' && strings.Trim(value[1:], "0123456789 ") == "" {
+		if _, err := ParsePhone(value, ""); err == nil {
+			return value
+		}
+	}
+	return "'" + value
+}
+
+// restoreFormula removes the apostrophe added by neutralizeFormula
+func restoreFormula(value string) string {
+	if len(value) > 1 && value[0] == '\'' && strings.ContainsRune(formulaPrefixes+"'", rune(value[1])) {
+		return value[1:]
+	}
+	return value
+}
+
+func (e *csvEncoder) Close() error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+// xmlEncoder writes customers as an xml document
+type xmlEncoder struct {
+	writer     io.Writer
+	projection Projection
+	started    bool
+}
+
+// NewXMLEncoder returns an encoder writing the selected fields of customers as
+// an xml document, each customer being a <customer> element of <customers>
+// holding an element for each field that is not empty
+func NewXMLEncoder(writer io.Writer, projection Projection) CustomerEncoder {
+	return &xmlEncoder{writer: writer, projection: projection}
+}
+
+func (e *xmlEncoder) start() error {
+	if e.started {
+		return nil
+	}
+	e.started = true
+	_, err := io.WriteString(e.writer, xml.Header+"<customers>")
+	return err
+}
+
+func (e *xmlEncoder) Encode(c *Customer) error {
+	if err := e.start(); err != nil {
+		return err
+	}
+	return e.projection.writeXML(e.writer, c)
+}
+
+func (e *xmlEncoder) Close() error {
+	if err := e.start(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(e.writer, "</customers>\n")
+	return err
+}
+
+// WriteXML writes the selected fields of a customer as an xml document
+// holding a single <customer> element
+func (p Projection) WriteXML(writer io.Writer, c *Customer) error {
+	if _, err := io.WriteString(writer, xml.Header); err != nil {
+		return err
+	}
+	if err := p.writeXML(writer, c); err != nil {
+		return err
+	}
+	_, err := io.WriteString(writer, "\n")
+	return err
+}
+
+func (p Projection) writeXML(writer io.Writer, c *Customer) error {
+	buffer := bytes.NewBufferString("<customer>")
+	for _, field := range p.fields() {
+		if field.isEmpty(c) {
+			continue
+		}
+		buffer.WriteString("<" + field.Name + ">")
+		if err := xml.EscapeText(buffer, []byte(field.String(c))); err != nil {
+			return err
+		}
+		buffer.WriteString("</" + field.Name + ">")
+	}
+	buffer.WriteString("</customer>")
+	_, err := writer.Write(buffer.Bytes())
+	return err
+}
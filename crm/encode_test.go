@@ -0,0 +1,187 @@
+package crm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"net/url"
+	"testing"
+)
+
+func TestJSONEncoder(t *testing.T) {
+	customerTable := ReadCustomers(t)
+	customers, _ := customerTable.List()
+
+	buffer := bytes.NewBuffer(nil)
+	encoder := NewJSONEncoder(buffer, nil)
+	for index := range customers {
+		if err := encoder.Encode(&customers[index]); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if expected, _ := customers.ToJSON(); buffer.String() != expected {
+		t.Errorf("streamed json differs:\n%s\nexpected:\n%s", buffer.String(), expected)
+	}
+
+	buffer.Reset()
+	encoder = NewJSONEncoder(buffer, nil)
+	if err := encoder.Close(); err != nil || buffer.String() != "[]\n" {
+		t.Errorf("expected an empty array, got %q, %v", buffer.String(), err)
+	}
+}
+
+func TestNDJSONEncoder(t *testing.T) {
+	customerTable := ReadCustomers(t)
+	customers, _ := customerTable.List()
+	values, _ := url.ParseQuery("fields=id,name")
+	projection, _ := ParseProjection(values)
+
+	buffer := bytes.NewBuffer(nil)
+	encoder := NewNDJSONEncoder(buffer, projection)
+	for index := range customers {
+		if err := encoder.Encode(&customers[index]); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	scanner := bufio.NewScanner(buffer)
+	lines := 0
+	for ; scanner.Scan(); lines++ {
+		var customer Customer
+		if err := json.Unmarshal(scanner.Bytes(), &customer); err != nil {
+			t.Fatalf("line %d: %v", lines+1, err)
+		}
+		if expected := (Customer{Id: customers[lines].Id, Name: customers[lines].Name}); customer != expected {
+			t.Errorf("line %d is %v, expected %v", lines+1, customer, expected)
+		}
+	}
+	if lines != len(customers) {
+		t.Errorf("expected %d lines, got %d", len(customers), lines)
+	}
+}
+
+func encodeAll(t *testing.T, encoder CustomerEncoder, customers Customers) {
+	for index := range customers {
+		if err := encoder.Encode(&customers[index]); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+var encodeCustomers = Customers{
+	{Id: 5, Name: "Bianca Bruxner", Role: "student", Email: "bbruxner@dayrep.com", Phone: "(07) 4938 5904", PhoneE164: "+61749385904", Version: 1},
+	{Id: 7, Name: "O'Brien, \"Charli\" <&>", Contacted: true},
+}
+
+func TestCSVEncoder(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	encodeAll(t, NewCSVEncoder(buffer, nil), encodeCustomers)
+	expected := "id,name,role,email,phone,phone_e164,contacted,version\n" +
+		"5,Bianca Bruxner,student,bbruxner@dayrep.com,(07) 4938 5904,+61749385904,false,1\n" +
+		"7,\"O'Brien, \"\"Charli\"\" <&>\",,,,,true,0\n"
+	if buffer.String() != expected {
+		t.Errorf("unexpected csv:\n%s\nexpected:\n%s", buffer.String(), expected)
+	}
+
+	values, _ := url.ParseQuery("fields=name,id")
+	projection, _ := ParseProjection(values)
+	buffer.Reset()
+	encodeAll(t, NewCSVEncoder(buffer, projection), nil)
+	if buffer.String() != "id,name\n" {
+		t.Errorf("expected just a header, got %q", buffer.String())
+	}
+}
+
+func TestCSVFormulas(t *testing.T) {
+	tests := []struct {
+		value, expected string
+	}{
+		{"=HYPERLINK(\"http://evil.example\")", "'=HYPERLINK(\"http://evil.example\")"},
+		{"+cmd|' /C calc'!A0", "'+cmd|' /C calc'!A0"},
+		{"-2+3", "'-2+3"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\t=1", "'\t=1"},
+		{"-1-1", "'-1-1"},
+		{"+1+1", "'+1+1"},
+		{"+2-(3)", "'+2-(3)"},
+		{"+61749385904", "+61749385904"}, // phone numbers are left alone
+		{"+61 7 4938 5904", "+61 7 4938 5904"},
+		{"+1-555-0100", "'+1-555-0100"}, // but not when they might be subtraction
+		{"'=x", "''=x"},                 // an apostrophe is doubled, so the value is restored exactly
+		{"''", "'''"},
+		{"'quoted'", "''quoted'"},
+		{"Charli Angles", "Charli Angles"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if value := neutralizeFormula(test.value); value != test.expected {
+			t.Errorf("neutralizeFormula(%q) returned %q, expected %q", test.value, value, test.expected)
+		}
+		if value := restoreFormula(neutralizeFormula(test.value)); value != test.value {
+			t.Errorf("restoreFormula(%q) returned %q", test.expected, value)
+		}
+	}
+
+	// exported customers are imported as they were
+	buffer := bytes.NewBuffer(nil)
+	values, _ := url.ParseQuery("fields=name,role,phone")
+	projection, _ := ParseProjection(values)
+	encodeAll(t, NewCSVEncoder(buffer, projection), Customers{{Name: "=1+2", Role: "'=admin", Phone: "+61 7 4938 5904"}})
+	if expected := "name,role,phone\n'=1+2,''=admin,+61 7 4938 5904\n"; buffer.String() != expected {
+		t.Errorf("unexpected csv %q, expected %q", buffer.String(), expected)
+	}
+	decoder, err := NewCSVDecoder(buffer, ImportMappings["default"])
+	if err != nil {
+		t.Fatalf("NewCSVDecoder: %v", err)
+	}
+	if customer, err := decoder.Decode(); err != nil || customer.Name != "=1+2" || customer.Role != "'=admin" {
+		t.Errorf("unexpected customer %v (error %v)", customer, err)
+	}
+}
+
+func TestXMLEncoder(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	encodeAll(t, NewXMLEncoder(buffer, nil), encodeCustomers)
+	var document struct {
+		Customers []struct {
+			Id        int64  `xml:"id"`
+			Name      string `xml:"name"`
+			Phone     string `xml:"phone_e164"`
+			Contacted bool   `xml:"contacted"`
+		} `xml:"customer"`
+	}
+	if err := xml.Unmarshal(buffer.Bytes(), &document); err != nil {
+		t.Fatalf("unexpected xml error: %v\n%s", err, buffer.String())
+	}
+	if len(document.Customers) != 2 || document.Customers[0].Phone != "+61749385904" ||
+		document.Customers[1].Name != encodeCustomers[1].Name || !document.Customers[1].Contacted {
+		t.Errorf("unexpected customers %+v", document.Customers)
+	}
+
+	buffer.Reset()
+	encodeAll(t, NewXMLEncoder(buffer, nil), nil)
+	if buffer.String() != xml.Header+"<customers></customers>\n" {
+		t.Errorf("unexpected empty document %q", buffer.String())
+	}
+
+	values, _ := url.ParseQuery("fields=id,name,role")
+	projection, _ := ParseProjection(values)
+	buffer.Reset()
+	if err := projection.WriteXML(buffer, &encodeCustomers[1]); err != nil {
+		t.Fatalf("WriteXML: %v", err)
+	}
+	expected := xml.Header + "<customer><id>7</id><name>O&#39;Brien, &#34;Charli&#34; &lt;&amp;&gt;</name></customer>\n"
+	if buffer.String() != expected {
+		t.Errorf("WriteXML wrote %q, expected %q", buffer.String(), expected)
+	}
+}
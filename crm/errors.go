@@ -0,0 +1,123 @@
+package crm
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Errors returned by the customer stores, which may be tested using errors.Is
+var (
+	// ErrNotFound indicates that no customer exists with the requested id
+	ErrNotFound = errors.New("not found")
+	// ErrInvalidID indicates that a customer id is malformed
+	ErrInvalidID = errors.New("invalid id")
+	// ErrValidation indicates that a customer record is not acceptable; see ValidationError
+	ErrValidation = errors.New("validation failed")
+	// ErrInvalidQuery indicates that a query is malformed or names unknown fields
+	ErrInvalidQuery = errors.New("invalid query")
+	// ErrConflict indicates that a change conflicts with the current state of a customer
+	ErrConflict = errors.New("conflict")
+	// ErrInvalidImport indicates that an import file cannot be read, or its columns mapped
+	ErrInvalidImport = errors.New("invalid import")
+
+	// ErrVersionMismatch is a conflict where a change is made against a version
+	// of a customer that is no longer current
+	ErrVersionMismatch error = &kindError{"version mismatch", ErrConflict}
+	// ErrPatchTestFailed is a conflict where a JSON Patch "test" operation does not match
+	ErrPatchTestFailed error = &kindError{"patch test failed", ErrConflict}
+	// ErrInvalidFilter is an invalid query where a filter expression cannot be
+	// parsed; see FilterSyntaxError
+	ErrInvalidFilter error = &kindError{"invalid filter", ErrInvalidQuery}
+	// ErrDuplicateEmail is a conflict where a customer's email is already used by
+	// another customer while unique emails are required; see DuplicateError
+	ErrDuplicateEmail error = &kindError{"duplicate email", ErrConflict}
+)
+
+// kindError is a specific error that also matches a more general one
+type kindError struct {
+	message string
+	kind    error
+}
+
+func (e *kindError) Error() string {
+	return e.message
+}
+
+func (e *kindError) Is(target error) bool {
+	return target == e.kind
+}
+
+// DuplicateError identifies the existing customer holding a value that must be unique.
+// It matches ErrDuplicateEmail (and so ErrConflict).
+type DuplicateError struct {
+	Field      string
+	Value      string
+	ExistingId int64
+}
+
+func (e *DuplicateError) Error() string {
+	return fmt.Sprintf("customer %s %q is already used by customer id %d", e.Field, e.Value, e.ExistingId)
+}
+
+func (e *DuplicateError) Unwrap() error {
+	return ErrDuplicateEmail
+}
+
+// FieldError describes a problem with a single customer field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists the problems found with a customer record, field by field.
+// It matches ErrValidation.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		messages = append(messages, field.Field+" "+field.Message)
+	}
+	return "customer " + strings.Join(messages, ", ")
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// Add records a problem with a field
+func (e *ValidationError) Add(field, format string, args ...any) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Err returns the ValidationError if any problems were recorded, otherwise nil
+func (e *ValidationError) Err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// fieldError returns a ValidationError for a single field
+func fieldError(field, format string, args ...any) error {
+	e := &ValidationError{}
+	e.Add(field, format, args...)
+	return e
+}
+
+func notFound(id int64) error {
+	return fmt.Errorf("customer id %d %w", id, ErrNotFound)
+}
+
+// ParseId parses a customer id, returning ErrInvalidID if it is malformed
+func ParseId(s string) (int64, error) {
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidID, s)
+	}
+	return id, nil
+}
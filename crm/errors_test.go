@@ -0,0 +1,110 @@
+package crm
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestErrorKinds(t *testing.T) {
+	customerTable := ReadCustomers(t)
+
+	if _, err := customerTable.Get(4); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get: expected ErrNotFound, got %v", err)
+	} else if err.Error() != "customer id 4 not found" {
+		t.Errorf("Get: unexpected message %q", err.Error())
+	}
+	if _, err := customerTable.Update(4, &Customer{Name: "Peter Rabbit"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Update: expected ErrNotFound, got %v", err)
+	}
+	if _, err := customerTable.Delete(4, 0); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Delete: expected ErrNotFound, got %v", err)
+	}
+
+	_, err := customerTable.Update(5, &Customer{Name: "Bianca Bruxner", Version: 7})
+	if !errors.Is(err, ErrVersionMismatch) || !errors.Is(err, ErrConflict) {
+		t.Errorf("Update: expected ErrVersionMismatch and ErrConflict, got %v", err)
+	} else if errors.Is(err, ErrPatchTestFailed) {
+		t.Errorf("Update: ErrVersionMismatch matched ErrPatchTestFailed")
+	}
+
+	customer := Customer{Id: 5, Name: "Bianca Bruxner"}
+	_, err = customer.JSONPatch([]byte(`[{"op":"test","path":"/name","value":"B. Bruxner"}]`))
+	if !errors.Is(err, ErrPatchTestFailed) || !errors.Is(err, ErrConflict) {
+		t.Errorf("JSONPatch: expected ErrPatchTestFailed and ErrConflict, got %v", err)
+	}
+}
+
+func TestValidationError(t *testing.T) {
+	customer := Customer{}
+	err := customer.Validate()
+	var validationError *ValidationError
+	if !errors.Is(err, ErrValidation) || !errors.As(err, &validationError) {
+		t.Fatalf("Validate: expected a ValidationError, got %v", err)
+	}
+	if len(validationError.Fields) != 1 || validationError.Fields[0].Field != "name" {
+		t.Errorf("unexpected field errors %v", validationError.Fields)
+	}
+	if expected := "customer name is required"; err.Error() != expected {
+		t.Errorf("expected message %q, got %q", expected, err.Error())
+	}
+	if err = (&ValidationError{}).Err(); err != nil {
+		t.Errorf("empty ValidationError.Err returned %v", err)
+	}
+}
+
+func TestParseId(t *testing.T) {
+	if id, err := ParseId("42"); err != nil || id != 42 {
+		t.Errorf("ParseId(42) returned %d, %v", id, err)
+	}
+	for _, s := range []string{"", "abc", "0", "-3", "e7847fee-3a0e-455e-b151-519bdb9851c7"} {
+		if _, err := ParseId(s); !errors.Is(err, ErrInvalidID) {
+			t.Errorf("ParseId(%q): expected ErrInvalidID, got %v", s, err)
+		}
+	}
+}
+
+func TestUniqueEmail(t *testing.T) {
+	customerTable := ReadCustomers(t)
+
+	// not enforced by default
+	duplicate, err := customerTable.Create(&Customer{Name: "B. Bruxner", Email: "BBruxner@dayrep.com"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err = customerTable.SetUniqueEmail(true); !errors.Is(err, ErrDuplicateEmail) {
+		t.Errorf("SetUniqueEmail: expected ErrDuplicateEmail, got %v", err)
+	}
+	if _, err = customerTable.Delete(duplicate.Id, 0); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err = customerTable.SetUniqueEmail(true); err != nil {
+		t.Fatalf("SetUniqueEmail: %v", err)
+	}
+
+	_, err = customerTable.Create(&Customer{Name: "B. Bruxner", Email: "BBruxner@dayrep.com"})
+	var duplicateError *DuplicateError
+	if !errors.As(err, &duplicateError) || !errors.Is(err, ErrConflict) {
+		t.Fatalf("Create: expected a DuplicateError, got %v", err)
+	} else if duplicateError.ExistingId != 5 {
+		t.Errorf("DuplicateError identifies customer %d, expected 5", duplicateError.ExistingId)
+	}
+	if customer := customerTable.NewCustomer("B. Bruxner", "", "bbruxner@dayrep.com", ""); customer != nil {
+		t.Errorf("NewCustomer created duplicate %v", customer)
+	}
+	if _, err = customerTable.Update(7, &Customer{Name: "Charli Angles", Email: "bbruxner@dayrep.com"}); !errors.Is(err, ErrDuplicateEmail) {
+		t.Errorf("Update: expected ErrDuplicateEmail, got %v", err)
+	}
+	if _, err = customerTable.UpdateCustomerById(7, &Customer{Email: "bbruxner@dayrep.com"}); !errors.Is(err, ErrDuplicateEmail) {
+		t.Errorf("UpdateCustomerById: expected ErrDuplicateEmail, got %v", err)
+	}
+
+	// a customer may keep its own email, and customers without one are exempt
+	if _, err = customerTable.Update(5, &Customer{Name: "Bianca Bruxner", Email: "bbruxner@dayrep.com"}); err != nil {
+		t.Errorf("Update: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err = customerTable.Create(&Customer{Name: "Peter Rabbit"}); err != nil {
+			t.Errorf("Create: %v", err)
+		}
+	}
+}
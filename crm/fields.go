@@ -0,0 +1,101 @@
+package crm
+
+import "fmt"
+
+// FieldKind is the type of value held by a customer field
+type FieldKind int
+
+const (
+	StringField FieldKind = iota
+	IntField
+	BoolField
+)
+
+// CustomerField describes a customer field by its json name, giving generic
+// access to its value so that features such as merging and querying can work
+// field by field
+type CustomerField struct {
+	Name string
+	Kind FieldKind
+	// Value returns the field's value from a customer
+	Value func(c *Customer) any
+	// Copy sets the field of one customer from another
+	Copy func(to, from *Customer)
+	// normalize, if set, converts values to the form in which they are compared,
+	// phone numbers in national format being read in region
+	normalize func(value, region string) string
+}
+
+// CustomerFields lists the fields of a customer in json order
+var CustomerFields = []CustomerField{
+	{
+		Name:  "id",
+		Kind:  IntField,
+		Value: func(c *Customer) any { return c.Id },
+		Copy:  func(to, from *Customer) { to.Id = from.Id },
+	},
+	{
+		Name:  "name",
+		Kind:  StringField,
+		Value: func(c *Customer) any { return c.Name },
+		Copy:  func(to, from *Customer) { to.Name = from.Name },
+	},
+	{
+		Name:  "role",
+		Kind:  StringField,
+		Value: func(c *Customer) any { return c.Role },
+		Copy:  func(to, from *Customer) { to.Role = from.Role },
+	},
+	{
+		Name:  "email",
+		Kind:  StringField,
+		Value: func(c *Customer) any { return c.Email },
+		Copy:  func(to, from *Customer) { to.Email = from.Email },
+	},
+	{
+		Name:  "phone",
+		Kind:  StringField,
+		Value: func(c *Customer) any { return c.Phone },
+		// the canonical form always accompanies the phone number
+		Copy:      func(to, from *Customer) { to.Phone, to.PhoneE164 = from.Phone, from.PhoneE164 },
+		normalize: PhoneKey,
+	},
+	{
+		Name:  "phone_e164",
+		Kind:  StringField,
+		Value: func(c *Customer) any { return c.PhoneE164 },
+		Copy:  func(to, from *Customer) { to.PhoneE164 = from.PhoneE164 },
+	},
+	{
+		Name:  "contacted",
+		Kind:  BoolField,
+		Value: func(c *Customer) any { return c.Contacted },
+		Copy:  func(to, from *Customer) { to.Contacted = from.Contacted },
+	},
+	{
+		Name:  "version",
+		Kind:  IntField,
+		Value: func(c *Customer) any { return c.Version },
+		Copy:  func(to, from *Customer) { to.Version = from.Version },
+	},
+}
+
+// LookupField returns the customer field with the given json name
+func LookupField(name string) (CustomerField, bool) {
+	for _, field := range CustomerFields {
+		if field.Name == name {
+			return field, true
+		}
+	}
+	return CustomerField{}, false
+}
+
+// String returns the value of a customer's field as a string
+func (f CustomerField) String(c *Customer) string {
+	return fmt.Sprint(f.Value(c))
+}
+
+// isEmpty reports whether a customer's field holds its zero value
+func (f CustomerField) isEmpty(c *Customer) bool {
+	return f.Value(c) == f.Value(&Customer{})
+}
@@ -0,0 +1,374 @@
+package crm
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	snapshotName        = "customers.json"
+	journalName         = "customers.journal"
+	defaultCompactEvery = 1000
+)
+
+// FileStore is a durable CustomerStore backed by a directory on disk.
+//
+// Every mutation is appended to a journal and synced before it is
+// acknowledged. The journal is compacted into a JSON snapshot every
+// so many entries (and on Close), the snapshot being written to a
+// temporary file and renamed into place. On open the snapshot is loaded
+// and the journal replayed, so a crash never loses an acknowledged write.
+// Journal entries are numbered, and the snapshot records the last it
+// includes, so that a crash after writing the snapshot but before
+// truncating the journal does not apply those entries twice.
+type FileStore struct {
+	mu           sync.Mutex // serialises mutations with their journal entries
+	table        CustomerTable
+	dir          string
+	journal      *os.File
+	entries      int
+	sequence     int64 // the number of the last journal entry
+	compactEvery int
+}
+
+// FileStoreOption configures a FileStore
+type FileStoreOption func(*FileStore)
+
+// WithCompactEvery sets the number of journal entries written between snapshots
+func WithCompactEvery(entries int) FileStoreOption {
+	return func(f *FileStore) {
+		f.compactEvery = entries
+	}
+}
+
+// journalEntry records a single mutation
+type journalEntry struct {
+	Seq      int64        `json:"seq,omitempty"`
+	Op       string       `json:"op"`
+	Id       int64        `json:"id,omitempty"`
+	Customer *Customer    `json:"customer,omitempty"`
+	Merge    *MergeRecord `json:"merge,omitempty"`
+}
+
+const (
+	opPut    = "put"
+	opDelete = "delete"
+	opMerge  = "merge"
+)
+
+var _ CustomerStore = (*FileStore)(nil)
+var _ CustomerLoader = (*FileStore)(nil)
+var _ PhoneFinder = (*FileStore)(nil)
+var _ UniqueEmailEnforcer = (*FileStore)(nil)
+var _ RegionSetter = (*FileStore)(nil)
+var _ DuplicateFinder = (*FileStore)(nil)
+var _ Merger = (*FileStore)(nil)
+var _ Querier = (*FileStore)(nil)
+var _ IdQuerier = (*FileStore)(nil)
+var _ Searcher = (*FileStore)(nil)
+var _ Suggester = (*FileStore)(nil)
+
+// OpenFileStore opens (creating if necessary) a store in the given directory,
+// restoring its content from the last snapshot and the journal
+func OpenFileStore(dir string, options ...FileStoreOption) (*FileStore, error) {
+	f := &FileStore{
+		dir:          dir,
+		compactEvery: defaultCompactEvery,
+	}
+	for _, option := range options {
+		option(f)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	snapshot := filepath.Join(dir, snapshotName)
+	included, err := f.table.readData(snapshot)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", snapshot, err)
+	}
+	f.sequence = included
+	journal, err := os.OpenFile(filepath.Join(dir, journalName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	f.journal = journal
+	if err = f.replay(included); err != nil {
+		_ = journal.Close()
+		return nil, fmt.Errorf("%s: %w", journal.Name(), err)
+	}
+	return f, nil
+}
+
+// replay applies the journal to the table loaded from the snapshot, skipping
+// the entries the snapshot already includes. A torn final entry (from a crash
+// part way through a write) was never acknowledged, so it is discarded.
+func (f *FileStore) replay(included int64) error {
+	var (
+		offset int64
+		err    error
+		line   []byte
+	)
+	reader := bufio.NewReader(f.journal)
+	for {
+		line, err = reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		var entry journalEntry
+		if err = json.Unmarshal(line, &entry); err != nil {
+			return fmt.Errorf("corrupt journal entry at offset %d: %w", offset, err)
+		}
+		if entry.Seq == 0 || entry.Seq > included {
+			f.apply(entry)
+		}
+		if entry.Seq > f.sequence {
+			f.sequence = entry.Seq
+		}
+		offset += int64(len(line))
+		f.entries++
+	}
+	if len(line) > 0 {
+		if err = f.journal.Truncate(offset); err != nil {
+			return err
+		}
+	}
+	_, err = f.journal.Seek(offset, io.SeekStart)
+	return err
+}
+
+func (f *FileStore) apply(entry journalEntry) {
+	switch entry.Op {
+	case opPut:
+		if entry.Customer != nil {
+			f.table.put(*entry.Customer)
+		}
+	case opDelete:
+		_, _ = f.table.DeleteCustomerById(entry.Id)
+	case opMerge:
+		if entry.Customer != nil && entry.Merge != nil {
+			f.table.applyMerge(*entry.Customer, *entry.Merge)
+		}
+	}
+}
+
+// record appends an entry to the journal, syncing it to disk.
+// The caller must hold the lock.
+func (f *FileStore) record(entry journalEntry) error {
+	entry.Seq = f.sequence + 1
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if _, err = f.journal.Write(data); err == nil {
+		err = f.journal.Sync()
+	}
+	if err != nil {
+		return fmt.Errorf("journal write failed: %w", err)
+	}
+	f.sequence = entry.Seq
+	f.entries++
+	if f.compactEvery > 0 && f.entries >= f.compactEvery {
+		// the mutation is already durable, so a failed compaction is retried next time
+		_ = f.compact()
+	}
+	return nil
+}
+
+// Compact writes a snapshot of the store and truncates the journal
+func (f *FileStore) Compact() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.compact()
+}
+
+func (f *FileStore) compact() error {
+	if err := f.table.writeData(filepath.Join(f.dir, snapshotName), f.sequence); err != nil {
+		return err
+	}
+	if err := f.journal.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := f.journal.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	f.entries = 0
+	return f.journal.Sync()
+}
+
+// Close compacts the store and closes the journal
+func (f *FileStore) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	err := f.compact()
+	if closeErr := f.journal.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// ReadCustomerData replaces the content of the store with that of a data file
+func (f *FileStore) ReadCustomerData(filename string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.table.ReadCustomerData(filename); err != nil {
+		return err
+	}
+	return f.compact()
+}
+
+func (f *FileStore) Get(id int64) (*Customer, error) {
+	return f.table.Get(id)
+}
+
+func (f *FileStore) List() (Customers, error) {
+	return f.table.List()
+}
+
+func (f *FileStore) Query(q Query) (Page, error) {
+	return f.table.Query(q)
+}
+
+func (f *FileStore) QueryIds(q Query) ([]int64, int, error) {
+	return f.table.QueryIds(q)
+}
+
+func (f *FileStore) GetMany(ids []int64) Customers {
+	return f.table.GetMany(ids)
+}
+
+func (f *FileStore) Search(query string, limit int) ([]SearchResult, error) {
+	return f.table.Search(query, limit)
+}
+
+func (f *FileStore) Suggest(prefix string, limit int) ([]Suggestion, error) {
+	return f.table.Suggest(prefix, limit)
+}
+
+func (f *FileStore) FindByPhone(phone string) Customers {
+	return f.table.FindByPhone(phone)
+}
+
+func (f *FileStore) FindDuplicates(threshold float64) []DuplicateMatch {
+	return f.table.FindDuplicates(threshold)
+}
+
+func (f *FileStore) Merge(targetId, sourceId int64, policy MergePolicy, version int64) (*Customer, *MergeRecord, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	previous, err := f.table.Get(targetId)
+	if err != nil {
+		return nil, nil, err
+	}
+	customer, record, err := f.table.Merge(targetId, sourceId, policy, version)
+	if err == nil {
+		if err = f.record(journalEntry{Op: opMerge, Customer: customer, Merge: record}); err != nil {
+			f.table.undoMerge(*previous, *record)
+			return nil, nil, err
+		}
+	}
+	return customer, record, err
+}
+
+func (f *FileStore) Merges(id int64) []MergeRecord {
+	return f.table.Merges(id)
+}
+
+// SetUniqueEmail sets whether customers are required to have distinct emails
+func (f *FileStore) SetUniqueEmail(unique bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.table.SetUniqueEmail(unique)
+}
+
+func (f *FileStore) SetRegion(region string) error {
+	return f.table.SetRegion(region)
+}
+
+func (f *FileStore) Count() int {
+	return f.table.Count()
+}
+
+func (f *FileStore) Create(c *Customer) (*Customer, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	customer, err := f.table.Create(c)
+	if err == nil {
+		if err = f.record(journalEntry{Op: opPut, Customer: customer}); err != nil {
+			_, _ = f.table.DeleteCustomerById(customer.Id)
+			return nil, err
+		}
+	}
+	return customer, err
+}
+
+func (f *FileStore) Update(id int64, c *Customer) (*Customer, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	previous, err := f.table.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	customer, err := f.table.Update(id, c)
+	if err == nil {
+		if err = f.record(journalEntry{Op: opPut, Customer: customer}); err != nil {
+			f.table.put(*previous)
+			return nil, err
+		}
+	}
+	return customer, err
+}
+
+func (f *FileStore) Delete(id int64, version int64) (*Customer, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	customer, err := f.table.Delete(id, version)
+	if err == nil {
+		if err = f.record(journalEntry{Op: opDelete, Id: id}); err != nil {
+			f.table.put(*customer)
+			return nil, err
+		}
+	}
+	return customer, err
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory,
+// syncs it and renames it over filename so readers never see a partial file
+func writeFileAtomic(filename string, data []byte) error {
+	dir := filepath.Dir(filename)
+	temp, err := os.CreateTemp(dir, "."+filepath.Base(filename)+".*")
+	if err != nil {
+		return err
+	}
+	tempName := temp.Name()
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tempName)
+		}
+	}()
+	if _, err = temp.Write(data); err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(tempName, filename); err != nil {
+		return err
+	}
+	if d, dirErr := os.Open(dir); dirErr == nil {
+		_ = d.Sync()
+		_ = d.Close()
+	}
+	return nil
+}
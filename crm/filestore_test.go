@@ -0,0 +1,198 @@
+package crm
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openFileStore(t *testing.T, dir string, options ...FileStoreOption) *FileStore {
+	store, err := OpenFileStore(dir, options...)
+	if err != nil {
+		t.Fatalf("OpenFileStore: %v", err)
+	}
+	return store
+}
+
+// crash abandons a store without compacting, as if the process had died
+func crash(store *FileStore) {
+	_ = store.journal.Close()
+}
+
+func TestFileStoreReplaysJournal(t *testing.T) {
+	dir := t.TempDir()
+	store := openFileStore(t, dir)
+	if err := store.ReadCustomerData(DATAFILE); err != nil {
+		t.Fatalf("ReadCustomerData: %v", err)
+	}
+
+	created, err := store.Create(&Customer{Name: "Peter Rabbit", Role: "teacher"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err = store.Update(5, &Customer{Role: "staff"}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if _, err = store.Delete(1, 0); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	crash(store)
+
+	store = openFileStore(t, dir)
+	defer store.Close()
+	if count := store.Count(); count != 14 {
+		t.Errorf("customer count is %d, expected 14", count)
+	}
+	if customer, err := store.Get(created.Id); err != nil {
+		t.Errorf("created customer lost: %v", err)
+	} else if customer.Name != "Peter Rabbit" {
+		t.Errorf("customer name is %s, expected Peter Rabbit", customer.Name)
+	}
+	if customer, err := store.Get(5); err != nil {
+		t.Errorf("updated customer lost: %v", err)
+	} else if customer.Role != "staff" {
+		t.Errorf("customer role is %s, expected staff", customer.Role)
+	}
+	if _, err := store.Get(1); err == nil {
+		t.Errorf("deleted customer restored")
+	}
+}
+
+func TestFileStoreCompaction(t *testing.T) {
+	dir := t.TempDir()
+	store := openFileStore(t, dir, WithCompactEvery(3))
+	for i := 0; i < 4; i++ {
+		if _, err := store.Create(&Customer{Name: "Peter Rabbit"}); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+	}
+	if store.entries != 1 {
+		t.Errorf("journal has %d entries, expected 1 after compaction", store.entries)
+	}
+	snapshot := &CustomerTable{}
+	if err := snapshot.ReadCustomerData(filepath.Join(dir, snapshotName)); err != nil {
+		t.Fatalf("ReadCustomerData: %v", err)
+	} else if count := snapshot.Count(); count != 3 {
+		t.Errorf("snapshot has %d customers, expected 3", count)
+	}
+	crash(store)
+
+	store = openFileStore(t, dir)
+	if count := store.Count(); count != 4 {
+		t.Errorf("customer count is %d, expected 4", count)
+	}
+	if err := store.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, journalName)); err != nil {
+		t.Errorf("journal: %v", err)
+	} else if info.Size() != 0 {
+		t.Errorf("journal is %d bytes after close, expected 0", info.Size())
+	}
+}
+
+func TestFileStoreDiscardsTornEntry(t *testing.T) {
+	dir := t.TempDir()
+	store := openFileStore(t, dir)
+	if _, err := store.Create(&Customer{Name: "Peter Rabbit"}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	// simulate a crash part way through writing the next entry
+	if _, err := store.journal.WriteString(`{"op":"put","customer":{"id":2,"na`); err != nil {
+		t.Fatal(err)
+	}
+	crash(store)
+
+	store = openFileStore(t, dir)
+	if count := store.Count(); count != 1 {
+		t.Errorf("customer count is %d, expected 1", count)
+	}
+	if _, err := store.Create(&Customer{Name: "Benjamin Bunny"}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	crash(store)
+
+	store = openFileStore(t, dir)
+	defer store.Close()
+	if count := store.Count(); count != 2 {
+		t.Errorf("customer count is %d, expected 2", count)
+	}
+}
+
+func TestFileStoreSequenceSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	store := openFileStore(t, dir)
+	created, err := store.Create(&Customer{Name: "Peter Rabbit"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err = store.Delete(created.Id, 0); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	crash(store)
+
+	// restart from the journal alone
+	store = openFileStore(t, dir)
+	if next, err := store.Create(&Customer{Name: "Benjamin Bunny"}); err != nil {
+		t.Fatalf("Create: %v", err)
+	} else if next.Id != created.Id+1 {
+		t.Errorf("new customer Id is %d, expected %d", next.Id, created.Id+1)
+	} else if _, err = store.Delete(next.Id, 0); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// restart from the snapshot alone
+	store = openFileStore(t, dir)
+	defer store.Close()
+	if next, err := store.Create(&Customer{Name: "Jemima Puddle-Duck"}); err != nil {
+		t.Fatalf("Create: %v", err)
+	} else if next.Id != created.Id+2 {
+		t.Errorf("new customer Id is %d, expected %d", next.Id, created.Id+2)
+	}
+}
+
+func TestFileStoreCrashBeforeTruncate(t *testing.T) {
+	dir := t.TempDir()
+	store := openFileStore(t, dir)
+	target, err := store.Create(&Customer{Name: "Peter Rabbit"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	source, err := store.Create(&Customer{Name: "Peter Rabit", Email: "peter@bunbun.com.au"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, _, err = store.Merge(target.Id, source.Id, nil, 0); err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	// the snapshot is written, but the process dies before the journal is truncated
+	if err = store.table.writeData(filepath.Join(dir, snapshotName), store.sequence); err != nil {
+		t.Fatalf("writeData: %v", err)
+	}
+	crash(store)
+
+	store = openFileStore(t, dir)
+	if merges := store.Merges(target.Id); len(merges) != 1 {
+		t.Errorf("found %d merges, expected 1", len(merges))
+	}
+	if count := store.Count(); count != 1 {
+		t.Errorf("customer count is %d, expected 1", count)
+	}
+	// later entries continue the numbering, so are replayed after another crash
+	if _, err = store.Create(&Customer{Name: "Benjamin Bunny"}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	crash(store)
+
+	store = openFileStore(t, dir)
+	defer store.Close()
+	if merges := store.Merges(target.Id); len(merges) != 1 {
+		t.Errorf("found %d merges after replaying, expected 1", len(merges))
+	}
+	if count := store.Count(); count != 2 {
+		t.Errorf("customer count is %d, expected 2", count)
+	}
+}
@@ -0,0 +1,479 @@
+package crm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Filter is a parsed filter expression, evaluated against customers.
+//
+// The expression language combines comparisons of customer fields with
+// and, or, not and parentheses, e.g.
+//
+//	role eq 'student' and not contacted and not (email endswith '@dayrep.com')
+//
+// Comparisons are written field operator value, where the operator is one of
+// eq, ne, lt, le, gt, ge, contains, startswith, endswith, or in followed by a
+// parenthesised list of values. Strings are quoted with single quotes (doubled
+// to include one) and compared ignoring case; numbers and true or false are
+// written bare. A boolean field on its own, such as contacted, is true if set.
+type Filter interface {
+	// Matches reports whether a customer satisfies the filter, phone numbers
+	// written in national format being read in region (DefaultRegion if empty)
+	Matches(c *Customer, region string) bool
+	// String returns the filter in canonical form, fully parenthesised
+	String() string
+}
+
+// FilterSyntaxError describes an error in a filter expression, at the given
+// (1-based) character position. It matches ErrInvalidFilter (and so ErrInvalidQuery).
+type FilterSyntaxError struct {
+	Position int
+	Token    string
+	Message  string
+}
+
+func (e *FilterSyntaxError) Error() string {
+	if e.Token == "" {
+		return fmt.Sprintf("filter error at position %d: %s", e.Position, e.Message)
+	}
+	return fmt.Sprintf("filter error at position %d near %q: %s", e.Position, e.Token, e.Message)
+}
+
+func (e *FilterSyntaxError) Is(target error) bool {
+	return target == ErrInvalidFilter || target == ErrInvalidQuery
+}
+
+// maxFilterLength limits the size of filter expressions, and so the work done parsing them
+const maxFilterLength = 2000
+
+// ParseFilter parses a filter expression
+func ParseFilter(expression string) (Filter, error) {
+	if len(expression) > maxFilterLength {
+		return nil, &FilterSyntaxError{Position: maxFilterLength + 1, Message: fmt.Sprintf("filter is longer than %d characters", maxFilterLength)}
+	}
+	tokens, err := lexFilter(expression)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	filter, err := p.parseOr()
+	if err == nil && p.peek().kind != tokenEnd {
+		err = p.errorf(p.peek(), "expected and, or or the end of the filter")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// lexical analysis
+
+type tokenKind int
+
+const (
+	tokenEnd tokenKind = iota
+	tokenWord
+	tokenString
+	tokenNumber
+	tokenOpen
+	tokenClose
+	tokenComma
+)
+
+type token struct {
+	kind     tokenKind
+	text     string // the token as written
+	value    string // words lower-cased, strings unquoted
+	position int
+}
+
+func lexFilter(expression string) ([]token, error) {
+	var tokens []token
+	runes := []rune(expression)
+	for pos := 0; pos < len(runes); {
+		r := runes[pos]
+		start := pos
+		switch {
+		case unicode.IsSpace(r):
+			pos++
+			continue
+		case r == '(' || r == ')' || r == ',':
+			kind := map[rune]tokenKind{'(': tokenOpen, ')': tokenClose, ',': tokenComma}[r]
+			tokens = append(tokens, token{kind: kind, text: string(r), position: pos + 1})
+			pos++
+			continue
+		case r == '\'':
+			var value strings.Builder
+			for pos++; ; pos++ {
+				if pos >= len(runes) {
+					return nil, &FilterSyntaxError{Position: start + 1, Token: string(runes[start:]), Message: "unterminated string"}
+				}
+				if runes[pos] == '\'' {
+					if pos+1 < len(runes) && runes[pos+1] == '\'' {
+						pos++
+					} else {
+						break
+					}
+				}
+				value.WriteRune(runes[pos])
+			}
+			pos++
+			tokens = append(tokens, token{kind: tokenString, text: string(runes[start:pos]), value: value.String(), position: start + 1})
+			continue
+		case r == '-' || unicode.IsDigit(r):
+			for pos++; pos < len(runes) && unicode.IsDigit(runes[pos]); pos++ {
+			}
+			text := string(runes[start:pos])
+			if _, err := strconv.ParseInt(text, 10, 64); err != nil {
+				return nil, &FilterSyntaxError{Position: start + 1, Token: text, Message: "invalid number"}
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: text, value: text, position: start + 1})
+			continue
+		case unicode.IsLetter(r) || r == '_':
+			for pos++; pos < len(runes) && (unicode.IsLetter(runes[pos]) || unicode.IsDigit(runes[pos]) || runes[pos] == '_'); pos++ {
+			}
+			text := string(runes[start:pos])
+			tokens = append(tokens, token{kind: tokenWord, text: text, value: strings.ToLower(text), position: start + 1})
+			continue
+		}
+		return nil, &FilterSyntaxError{Position: pos + 1, Token: string(r), Message: "unexpected character"}
+	}
+	return append(tokens, token{kind: tokenEnd, position: len(runes) + 1}), nil
+}
+
+// parsing, by recursive descent:
+//
+//	or         = and { "or" and }
+//	and        = unary { "and" unary }
+//	unary      = "not" unary | "(" or ")" | comparison
+//	comparison = field [ operator value | "in" "(" value { "," value } ")" ]
+
+type filterParser struct {
+	tokens []token
+	next   int
+}
+
+func (p *filterParser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *filterParser) advance() token {
+	t := p.tokens[p.next]
+	if t.kind != tokenEnd {
+		p.next++
+	}
+	return t
+}
+
+// keyword consumes the next token if it is the given word
+func (p *filterParser) keyword(word string) bool {
+	if t := p.peek(); t.kind == tokenWord && t.value == word {
+		p.next++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) errorf(t token, format string, args ...any) error {
+	return &FilterSyntaxError{Position: t.position, Token: t.text, Message: fmt.Sprintf(format, args...)}
+}
+
+func (p *filterParser) parseOr() (Filter, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.keyword("or") {
+		var right Filter
+		if right, err = p.parseAnd(); err == nil {
+			left = orFilter{left, right}
+		}
+	}
+	return left, err
+}
+
+func (p *filterParser) parseAnd() (Filter, error) {
+	left, err := p.parseUnary()
+	for err == nil && p.keyword("and") {
+		var right Filter
+		if right, err = p.parseUnary(); err == nil {
+			left = andFilter{left, right}
+		}
+	}
+	return left, err
+}
+
+func (p *filterParser) parseUnary() (Filter, error) {
+	if p.keyword("not") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notFilter{operand}, nil
+	}
+	if t := p.peek(); t.kind == tokenOpen {
+		p.advance()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t = p.advance(); t.kind != tokenClose {
+			return nil, p.errorf(t, "expected )")
+		}
+		return inner, nil
+	}
+	return p.parseComparison()
+}
+
+// comparison operators, and the kinds of field each applies to
+var filterOperators = map[string][]FieldKind{
+	"eq":         {StringField, IntField, BoolField},
+	"ne":         {StringField, IntField, BoolField},
+	"in":         {StringField, IntField},
+	"lt":         {StringField, IntField},
+	"le":         {StringField, IntField},
+	"gt":         {StringField, IntField},
+	"ge":         {StringField, IntField},
+	"contains":   {StringField},
+	"startswith": {StringField},
+	"endswith":   {StringField},
+}
+
+func (p *filterParser) parseComparison() (Filter, error) {
+	t := p.advance()
+	if t.kind != tokenWord || isFilterKeyword(t.value) {
+		return nil, p.errorf(t, "expected a field name")
+	}
+	field, ok := LookupField(t.value)
+	if !ok {
+		return nil, p.errorf(t, "unknown field")
+	}
+
+	opToken := p.peek()
+	kinds, isOperator := filterOperators[opToken.value]
+	if opToken.kind != tokenWord || !isOperator {
+		if field.Kind == BoolField {
+			// a boolean field on its own is true if set
+			return &compareFilter{field: field, op: OpEq, values: []string{"true"}}, nil
+		}
+		return nil, p.errorf(opToken, "expected an operator after %s", field.Name)
+	}
+	p.advance()
+	if !containsKind(kinds, field.Kind) {
+		return nil, p.errorf(opToken, "operator %s does not apply to %s", opToken.value, field.Name)
+	}
+
+	comparison := &compareFilter{field: field, op: opToken.value}
+	if comparison.op == "in" {
+		if open := p.advance(); open.kind != tokenOpen {
+			return nil, p.errorf(open, "expected ( after in")
+		}
+		for {
+			value, err := p.parseValue(field)
+			if err != nil {
+				return nil, err
+			}
+			comparison.values = append(comparison.values, value)
+			if next := p.advance(); next.kind == tokenClose {
+				break
+			} else if next.kind != tokenComma {
+				return nil, p.errorf(next, "expected , or )")
+			}
+		}
+	} else {
+		value, err := p.parseValue(field)
+		if err != nil {
+			return nil, err
+		}
+		comparison.values = []string{value}
+	}
+	return comparison, nil
+}
+
+// parseValue parses a value of the type held by the field, normalized for comparison
+func (p *filterParser) parseValue(field CustomerField) (string, error) {
+	t := p.advance()
+	switch {
+	case field.Kind == StringField && t.kind == tokenString:
+		return t.value, nil
+	case field.Kind == IntField && t.kind == tokenNumber:
+		return t.value, nil
+	case field.Kind == BoolField && t.kind == tokenWord && (t.value == "true" || t.value == "false"):
+		return t.value, nil
+	}
+	expected := map[FieldKind]string{StringField: "a quoted string", IntField: "a number", BoolField: "true or false"}
+	return "", p.errorf(t, "expected %s to compare with %s", expected[field.Kind], field.Name)
+}
+
+func isFilterKeyword(word string) bool {
+	_, isOperator := filterOperators[word]
+	return isOperator || word == "and" || word == "or" || word == "not" || word == "true" || word == "false"
+}
+
+func containsKind(kinds []FieldKind, kind FieldKind) bool {
+	for _, candidate := range kinds {
+		if candidate == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// the abstract syntax tree
+
+type andFilter struct{ left, right Filter }
+
+func (f andFilter) Matches(c *Customer, region string) bool {
+	return f.left.Matches(c, region) && f.right.Matches(c, region)
+}
+func (f andFilter) String() string { return "(" + f.left.String() + " and " + f.right.String() + ")" }
+
+type orFilter struct{ left, right Filter }
+
+func (f orFilter) Matches(c *Customer, region string) bool {
+	return f.left.Matches(c, region) || f.right.Matches(c, region)
+}
+func (f orFilter) String() string { return "(" + f.left.String() + " or " + f.right.String() + ")" }
+
+type notFilter struct{ operand Filter }
+
+func (f notFilter) Matches(c *Customer, region string) bool { return !f.operand.Matches(c, region) }
+func (f notFilter) String() string                          { return "not " + f.operand.String() }
+
+// compareFilter compares a field with one value, or any of several for in
+type compareFilter struct {
+	field  CustomerField
+	op     string
+	values []string
+}
+
+func (f *compareFilter) String() string {
+	values := make([]string, 0, len(f.values))
+	for _, value := range f.values {
+		if f.field.Kind == StringField {
+			value = "'" + strings.ReplaceAll(value, "'", "''") + "'"
+		}
+		values = append(values, value)
+	}
+	if f.op == "in" {
+		return f.field.Name + " in (" + strings.Join(values, ", ") + ")"
+	}
+	return f.field.Name + " " + f.op + " " + values[0]
+}
+
+func (f *compareFilter) Matches(c *Customer, region string) bool {
+	for _, value := range f.values {
+		if f.compare(c, value, region) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *compareFilter) compare(c *Customer, value, region string) bool {
+	var order int
+	switch f.field.Kind {
+	case IntField:
+		x, _ := f.field.Value(c).(int64)
+		y, _ := strconv.ParseInt(value, 10, 64)
+		order = compareInts(x, y)
+	case BoolField:
+		if f.field.String(c) == value {
+			order = 0
+		} else {
+			order = 1
+		}
+	default:
+		actual := f.field.String(c)
+		if f.field.normalize != nil && (f.op == "eq" || f.op == "ne" || f.op == "in") {
+			actual, value = f.field.normalize(actual, region), f.field.normalize(value, region)
+		}
+		actual, value = strings.ToLower(actual), strings.ToLower(value)
+		switch f.op {
+		case "contains":
+			return strings.Contains(actual, value)
+		case "startswith":
+			return strings.HasPrefix(actual, value)
+		case "endswith":
+			return strings.HasSuffix(actual, value)
+		}
+		order = strings.Compare(actual, value)
+	}
+	switch f.op {
+	case "ne":
+		return order != 0
+	case "lt":
+		return order < 0
+	case "le":
+		return order <= 0
+	case "gt":
+		return order > 0
+	case "ge":
+		return order >= 0
+	}
+	return order == 0
+}
+
+func compareInts(x, y int64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// candidates returns the ids of the customers that may match a filter, using
+// the table's indexes, or false if every customer must be considered: equality
+// with an indexed field is looked up, and the candidates of and and or combined.
+// The caller must hold the lock.
+func (t *CustomerTable) candidates(filter Filter) ([]int64, bool) {
+	switch f := filter.(type) {
+	case *compareFilter:
+		if f.op == "eq" || f.op == "in" {
+			return t.lookup(Condition{Field: f.field.Name, Op: OpEq, Values: f.values})
+		}
+	case andFilter:
+		left, leftOk := t.candidates(f.left)
+		right, rightOk := t.candidates(f.right)
+		switch {
+		case leftOk && rightOk:
+			return intersectIds(left, right), true
+		case leftOk:
+			return left, true
+		case rightOk:
+			return right, true
+		}
+	case orFilter:
+		left, leftOk := t.candidates(f.left)
+		right, rightOk := t.candidates(f.right)
+		if leftOk && rightOk {
+			for _, id := range right {
+				if !containsId(left, id) {
+					left = insertId(left, id)
+				}
+			}
+			return left, true
+		}
+	}
+	return nil, false
+}
+
+// intersectIds returns the ids present in both of two sorted slices
+func intersectIds(a, b []int64) []int64 {
+	ids := []int64{}
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			ids = append(ids, a[i])
+			i++
+			j++
+		}
+	}
+	return ids
+}
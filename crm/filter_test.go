@@ -0,0 +1,133 @@
+package crm
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct{ expression, canonical string }{
+		{"role eq 'student'", "role eq 'student'"},
+		{"ROLE EQ 'Student'", "role eq 'Student'"},
+		{"contacted", "contacted eq true"},
+		{"not contacted and id gt 5", "(not contacted eq true and id gt 5)"},
+		{"a_role eq 'x' or true", ""},
+		{"role eq 'student' or role eq 'staff' and contacted", "(role eq 'student' or (role eq 'staff' and contacted eq true))"},
+		{"(role eq 'student' or role eq 'staff') and contacted", "((role eq 'student' or role eq 'staff') and contacted eq true)"},
+		{"name eq 'O''Brien'", "name eq 'O''Brien'"},
+		{"id in (1, 5,7)", "id in (1, 5, 7)"},
+		{"not not contacted eq false", "not not contacted eq false"},
+		{"email endswith '@dayrep.com' and id ge -1", "(email endswith '@dayrep.com' and id ge -1)"},
+	}
+	for _, test := range tests {
+		filter, err := ParseFilter(test.expression)
+		if test.canonical == "" {
+			if err == nil {
+				t.Errorf("ParseFilter(%q) = %s, expected an error", test.expression, filter)
+			}
+		} else if err != nil {
+			t.Errorf("ParseFilter(%q): %v", test.expression, err)
+		} else if filter.String() != test.canonical {
+			t.Errorf("ParseFilter(%q) = %s, expected %s", test.expression, filter, test.canonical)
+		}
+	}
+}
+
+func TestFilterSyntaxErrors(t *testing.T) {
+	tests := []struct {
+		expression string
+		position   int
+		token      string
+	}{
+		{"", 1, ""},
+		{"role", 5, ""},
+		{"role eq", 8, ""},
+		{"role eq student", 9, "student"},
+		{"role eq 'student", 9, "'student"},
+		{"age eq 5", 1, "age"},
+		{"role eq 'student' and", 22, ""},
+		{"role eq 'student' contacted", 19, "contacted"},
+		{"(role eq 'student'", 19, ""},
+		{"contacted lt true", 11, "lt"},
+		{"id eq '5'", 7, "'5'"},
+		{"email contains 5", 16, "5"},
+		{"id in (1 2)", 10, "2"},
+		{"role eq 'student' & contacted", 19, "&"},
+		{"and eq 'x'", 1, "and"},
+	}
+	for _, test := range tests {
+		_, err := ParseFilter(test.expression)
+		var syntaxError *FilterSyntaxError
+		if !errors.As(err, &syntaxError) || !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("ParseFilter(%q): expected a FilterSyntaxError, got %v", test.expression, err)
+		} else if syntaxError.Position != test.position || syntaxError.Token != test.token {
+			t.Errorf("ParseFilter(%q): error at %d near %q, expected %d near %q (%v)",
+				test.expression, syntaxError.Position, syntaxError.Token, test.position, test.token, err)
+		}
+	}
+}
+
+func TestFilterMatches(t *testing.T) {
+	customerTable := ReadCustomers(t)
+	if _, err := customerTable.UpdateCustomerById(5, &Customer{Contacted: true}); err != nil {
+		t.Fatalf("UpdateCustomerById: %v", err)
+	}
+
+	tests := []struct {
+		expression string
+		expected   []int64
+	}{
+		{"role eq 'student' and not contacted and not (email endswith '@dayrep.com') and id le 5", []int64{1, 3}},
+		{"email contains 'DAYREP'", []int64{2, 5, 17}},
+		{"contacted", []int64{5}},
+		{"contacted eq false and id lt 3", []int64{1, 2}},
+		{"id in (5, 7, 4) or name startswith 'jett'", []int64{5, 7, 19}},
+		{"phone eq '+61 7 4938 5904'", []int64{5}},
+		{"phone ne '0749385904' and id lt 4", []int64{1, 2, 3}},
+		{"name ge 'S' and name lt 'T'", []int64{2, 11, 18}},
+		{"role ne 'student'", []int64{}},
+	}
+	for _, test := range tests {
+		filter, err := ParseFilter(test.expression)
+		if err != nil {
+			t.Fatalf("ParseFilter(%q): %v", test.expression, err)
+		}
+		ids, _ := queryIds(t, customerTable, Query{Filter: filter})
+		if !equalIds(ids, test.expected) {
+			t.Errorf("filter %q matched %v, expected %v", test.expression, ids, test.expected)
+		}
+	}
+}
+
+func TestFilterPlanner(t *testing.T) {
+	customerTable := ReadCustomers(t)
+
+	tests := []struct {
+		expression string
+		expected   []int64 // nil if every customer must be scanned
+	}{
+		{"email eq 'BBruxner@dayrep.com'", []int64{5}},
+		{"role eq 'student' and email eq 'bbruxner@dayrep.com'", []int64{5}},
+		{"email eq 'bbruxner@dayrep.com' or phone eq '(03) 5382 0404'", []int64{5, 11}},
+		{"email eq 'bbruxner@dayrep.com' or name eq 'Jett Roth'", nil},
+		{"name eq 'Jett Roth' and id gt 3", nil},
+		// candidates need only satisfy the indexed part of the filter
+		{"name eq 'Jett Roth' and phone eq '(07) 4049 3393'", []int64{15}},
+		{"not email eq 'bbruxner@dayrep.com'", nil},
+		{"role in ('staff', 'teacher')", []int64{}},
+	}
+	for _, test := range tests {
+		filter, err := ParseFilter(test.expression)
+		if err != nil {
+			t.Fatalf("ParseFilter(%q): %v", test.expression, err)
+		}
+		ids, ok := customerTable.candidates(filter)
+		if test.expected == nil {
+			if ok {
+				t.Errorf("planner used indexes for %q: %v", test.expression, ids)
+			}
+		} else if !ok || !equalIds(ids, test.expected) {
+			t.Errorf("planner chose %v (%v) for %q, expected %v", ids, ok, test.expression, test.expected)
+		}
+	}
+}
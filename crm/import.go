@@ -0,0 +1,334 @@
+package crm
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Import row statuses
+const (
+	ImportCreated = "created" // the customer was created (or would be, in a dry run)
+	ImportSkipped = "skipped" // the row was empty, or its email is already in use
+	ImportFailed  = "failed"  // the row could not be read or failed validation
+)
+
+// Name parts that may be mapped from separate columns, joined to form the name
+// when no column gives the whole name
+const (
+	FirstNameColumn  = "first_name"
+	MiddleNameColumn = "middle_name"
+	LastNameColumn   = "last_name"
+)
+
+// ColumnMapping maps the headers of an import file's columns (compared ignoring
+// case) to the customer fields they hold: name, role, email, phone or contacted,
+// or the name parts first_name, middle_name and last_name. Columns not mapped are
+// ignored, and where several columns map to a field the first not empty is taken.
+type ColumnMapping map[string]string
+
+// ImportMappings are the predefined column mappings: "default" for files using
+// the customer field names, and those of contacts exported by Outlook and Google
+var ImportMappings = map[string]ColumnMapping{
+	"default": {
+		"name":        "name",
+		"role":        "role",
+		"email":       "email",
+		"phone":       "phone",
+		"contacted":   "contacted",
+		"first name":  FirstNameColumn,
+		"middle name": MiddleNameColumn,
+		"last name":   LastNameColumn,
+	},
+	"outlook": {
+		"first name":     FirstNameColumn,
+		"middle name":    MiddleNameColumn,
+		"last name":      LastNameColumn,
+		"job title":      "role",
+		"e-mail address": "email",
+		"mobile phone":   "phone",
+		"business phone": "phone",
+		"home phone":     "phone",
+	},
+	"google": {
+		"name":                   "name",
+		"given name":             FirstNameColumn,
+		"additional name":        MiddleNameColumn,
+		"family name":            LastNameColumn,
+		"first name":             FirstNameColumn,
+		"middle name":            MiddleNameColumn,
+		"last name":              LastNameColumn,
+		"organization 1 - title": "role",
+		"organization title":     "role",
+		"e-mail 1 - value":       "email",
+		"phone 1 - value":        "phone",
+	},
+}
+
+// importColumns are the targets to which columns may be mapped
+var importColumns = map[string]bool{
+	"name": true, "role": true, "email": true, "phone": true, "contacted": true,
+	FirstNameColumn: true, MiddleNameColumn: true, LastNameColumn: true,
+}
+
+// ParseMapping returns a column mapping given either the name of one of the
+// ImportMappings or a json object mapping headers to fields, e.g.
+// {"Full Name": "name", "Mobile": "phone"}. An empty spec gives the default mapping.
+func ParseMapping(spec string) (ColumnMapping, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		spec = "default"
+	}
+	if mapping, ok := ImportMappings[strings.ToLower(spec)]; ok {
+		return mapping, nil
+	}
+	if !strings.HasPrefix(spec, "{") {
+		names := make([]string, 0, len(ImportMappings))
+		for name := range ImportMappings {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("%w: unknown mapping %q, expected one of %s or a json object",
+			ErrInvalidImport, spec, strings.Join(names, ", "))
+	}
+	custom := map[string]string{}
+	if err := json.Unmarshal([]byte(spec), &custom); err != nil {
+		return nil, fmt.Errorf("%w: malformed mapping: %v", ErrInvalidImport, err)
+	}
+	mapping := ColumnMapping{}
+	for header, field := range custom {
+		if !importColumns[field] {
+			return nil, fmt.Errorf("%w: column %q cannot be mapped to %q", ErrInvalidImport, header, field)
+		}
+		mapping[strings.ToLower(strings.TrimSpace(header))] = field
+	}
+	return mapping, nil
+}
+
+// CustomerDecoder reads the customers of an import file one at a time.
+// Decode returns io.EOF when there are no more. A customer that is read but
+// holds an unacceptable value is returned with a ValidationError, and the next
+// may still be read; any other error ends the import.
+type CustomerDecoder interface {
+	Decode() (*Customer, error)
+}
+
+// csvDecoder reads customers from comma separated values
+type csvDecoder struct {
+	reader  *csv.Reader
+	columns []string // the target of each column, or "" if it is not mapped
+}
+
+// NewCSVDecoder returns a decoder reading customers from comma separated values
+// (RFC 4180) whose first row gives the column headers, mapped to customer fields.
+// An error is returned if the header cannot be read or no column is mapped.
+func NewCSVDecoder(reader io.Reader, mapping ColumnMapping) (CustomerDecoder, error) {
+	d := &csvDecoder{reader: csv.NewReader(reader)}
+	d.reader.FieldsPerRecord = -1
+	d.reader.LazyQuotes = true
+	d.reader.TrimLeadingSpace = true
+	header, err := d.reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("%w: the file is empty", ErrInvalidImport)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+	}
+	mapped := false
+	for index, name := range header {
+		if index == 0 {
+			// spreadsheets often start files with a byte order mark
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+		column := mapping[strings.ToLower(strings.TrimSpace(name))]
+		d.columns = append(d.columns, column)
+		mapped = mapped || column != ""
+	}
+	if !mapped {
+		return nil, fmt.Errorf("%w: none of the columns %q is mapped to a customer field", ErrInvalidImport, header)
+	}
+	return d, nil
+}
+
+func (d *csvDecoder) Decode() (*Customer, error) {
+	record, err := d.reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	for index, value := range record {
+		if index < len(d.columns) && d.columns[index] != "" && values[d.columns[index]] == "" {
+			values[d.columns[index]] = firstValue(restoreFormula(value))
+		}
+	}
+	return customerFromColumns(values)
+}
+
+// firstValue returns the first of several values held in one column, as Google
+// contacts separate them with ":::"
+func firstValue(value string) string {
+	if index := strings.Index(value, ":::"); index >= 0 {
+		value = value[:index]
+	}
+	return strings.TrimSpace(value)
+}
+
+// customerFromColumns builds a customer from the values of mapped columns
+func customerFromColumns(values map[string]string) (*Customer, error) {
+	customer := &Customer{
+		Name:  values["name"],
+		Role:  values["role"],
+		Email: values["email"],
+		Phone: values["phone"],
+	}
+	if customer.Name == "" {
+		customer.Name = strings.Join(strings.Fields(values[FirstNameColumn]+" "+values[MiddleNameColumn]+" "+values[LastNameColumn]), " ")
+	}
+	if contacted := values["contacted"]; contacted != "" {
+		switch strings.ToLower(contacted) {
+		case "yes", "y", "x":
+			customer.Contacted = true
+		case "no", "n":
+		default:
+			var err error
+			if customer.Contacted, err = strconv.ParseBool(contacted); err != nil {
+				return customer, fieldError("contacted", "must be true or false, not %q", contacted)
+			}
+		}
+	}
+	return customer, nil
+}
+
+// ImportRow reports what became of one customer of an import file.
+// Rows are numbered from 1, not counting the header.
+type ImportRow struct {
+	Row    int          `json:"row"`
+	Status string       `json:"status"`
+	Id     int64        `json:"id,omitempty"`     // the customer created, or the one already using the email
+	Name   string       `json:"name,omitempty"`   // the name read, to help identify the row
+	Reason string       `json:"reason,omitempty"` // why the row was skipped or failed
+	Errors []FieldError `json:"errors,omitempty"` // the fields that failed validation
+}
+
+// ImportReport summarizes an import, row by row
+type ImportReport struct {
+	DryRun  bool        `json:"dry_run"`
+	Created int         `json:"created"`
+	Skipped int         `json:"skipped"`
+	Failed  int         `json:"failed"`
+	Rows    []ImportRow `json:"rows"`
+}
+
+// Importer adds the customers read by a decoder to a store, validating each.
+// Rows whose email is already used, by an existing customer or an earlier row,
+// are skipped so that an import may safely be repeated. In a dry run nothing
+// is stored, but the report is as it would otherwise be.
+type Importer struct {
+	Validator Validator
+	DryRun    bool
+	// Progress, if set, is called with the outcome of each row as it is imported
+	Progress func(row ImportRow)
+}
+
+// Import reads every customer from a decoder into a store. An error is returned
+// only if the store cannot be read; problems with rows are given in the report.
+func (i Importer) Import(store CustomerStore, decoder CustomerDecoder) (*ImportReport, error) {
+	return i.ImportContext(context.Background(), store, decoder)
+}
+
+// ImportContext is Import, stopping before the next row once the context is
+// done, in which case the report of the rows imported so far is returned along
+// with the context's error.
+func (i Importer) ImportContext(ctx context.Context, store CustomerStore, decoder CustomerDecoder) (*ImportReport, error) {
+	existing, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	emails := make(map[string]int64, len(existing))
+	for _, customer := range existing {
+		if key := emailKey(customer.Email); key != "" {
+			emails[key] = customer.Id
+		}
+	}
+	report := &ImportReport{DryRun: i.DryRun, Rows: []ImportRow{}}
+	for number := 1; ; number++ {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		customer, err := decoder.Decode()
+		if err == io.EOF {
+			break
+		}
+		row := ImportRow{Row: number}
+		if customer != nil {
+			row.Name = customer.Name
+		}
+		if err == nil {
+			err = i.importRow(store, customer, emails, &row)
+		}
+		if err != nil {
+			row.Status, row.Reason = ImportFailed, err.Error()
+			var invalid *ValidationError
+			if errors.As(err, &invalid) {
+				row.Reason, row.Errors = "customer failed validation", invalid.Fields
+			}
+		}
+		report.add(row)
+		if i.Progress != nil {
+			i.Progress(row)
+		}
+		if err != nil && customer == nil {
+			// the decoder cannot continue
+			break
+		}
+	}
+	return report, nil
+}
+
+// importRow validates and stores a customer, recording the outcome in row
+// unless it fails
+func (i Importer) importRow(store CustomerStore, customer *Customer, emails map[string]int64, row *ImportRow) error {
+	if *customer == (Customer{}) {
+		row.Status, row.Reason = ImportSkipped, "the row is empty"
+		return nil
+	}
+	if err := i.Validator.Validate(customer); err != nil {
+		return err
+	}
+	key := emailKey(customer.Email)
+	if id, ok := emails[key]; ok && key != "" {
+		row.Status, row.Id = ImportSkipped, id
+		row.Reason = fmt.Sprintf("email %q is already used", customer.Email)
+		return nil
+	}
+	row.Status = ImportCreated
+	if !i.DryRun {
+		created, err := store.Create(customer)
+		if err != nil {
+			return err
+		}
+		row.Id = created.Id
+	}
+	if key != "" {
+		emails[key] = row.Id
+	}
+	return nil
+}
+
+func (r *ImportReport) add(row ImportRow) {
+	switch row.Status {
+	case ImportCreated:
+		r.Created++
+	case ImportSkipped:
+		r.Skipped++
+	default:
+		r.Failed++
+	}
+	r.Rows = append(r.Rows, row)
+}
@@ -0,0 +1,186 @@
+package crm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func importCSV(t *testing.T, store CustomerStore, importer Importer, mapping ColumnMapping, data string) *ImportReport {
+	decoder, err := NewCSVDecoder(strings.NewReader(data), mapping)
+	if err != nil {
+		t.Fatalf("NewCSVDecoder: %v", err)
+	}
+	report, err := importer.Import(store, decoder)
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	return report
+}
+
+func checkRows(t *testing.T, report *ImportReport, statuses ...string) {
+	if len(report.Rows) != len(statuses) {
+		t.Fatalf("expected %d rows, got %+v", len(statuses), report.Rows)
+	}
+	for index, status := range statuses {
+		if row := report.Rows[index]; row.Row != index+1 || row.Status != status {
+			t.Errorf("row %d: expected %s, got %+v", index+1, status, row)
+		}
+	}
+}
+
+func TestImportCSV(t *testing.T) {
+	customerTable := ReadCustomers(t)
+	data := "\ufeffName,Email,Phone,Role,Contacted,Notes\n" +
+		"Harriet Vane,HVane@example.com,(02) 9876 5432,author,yes,first\n" +
+		"Bianca Again,bbruxner@dayrep.com,,student,,already a customer\n" +
+		",,,,,\n" +
+		"No Email,,,,no,\n" +
+		"Bad Email,not-an-email,,,,\n" +
+		"Maybe,maybe@example.com,,,perhaps,\n" +
+		"Harriet Twice,hvane@example.com,,,,repeated in the file\n"
+	expected := []string{ImportCreated, ImportSkipped, ImportSkipped, ImportCreated, ImportFailed, ImportFailed, ImportSkipped}
+
+	// a dry run changes nothing, but reports what would happen
+	count := customerTable.Count()
+	report := importCSV(t, customerTable, Importer{Validator: DefaultValidator, DryRun: true}, ImportMappings["default"], data)
+	checkRows(t, report, expected...)
+	if customerTable.Count() != count || !report.DryRun || report.Rows[0].Id != 0 {
+		t.Errorf("dry run changed the table: %d customers, report %+v", customerTable.Count(), report)
+	}
+
+	report = importCSV(t, customerTable, Importer{Validator: DefaultValidator}, ImportMappings["default"], data)
+	checkRows(t, report, expected...)
+	if report.Created != 2 || report.Skipped != 3 || report.Failed != 2 || customerTable.Count() != count+2 {
+		t.Errorf("unexpected counts %+v", report)
+	}
+	created := customerTable.GetCustomerById(report.Rows[0].Id)
+	if created == nil || created.Email != "hvane@example.com" || created.PhoneE164 != "+61298765432" || !created.Contacted || created.Role != "author" {
+		t.Errorf("unexpected customer created %v", created)
+	}
+	if row := report.Rows[1]; row.Id != 5 || row.Name != "Bianca Again" {
+		t.Errorf("expected the skipped row to give the existing customer, got %+v", row)
+	}
+	if row := report.Rows[4]; len(row.Errors) != 1 || row.Errors[0].Field != "email" {
+		t.Errorf("expected an email error, got %+v", row)
+	}
+	if row := report.Rows[5]; len(row.Errors) != 1 || row.Errors[0].Field != "contacted" {
+		t.Errorf("expected a contacted error, got %+v", row)
+	}
+	if row := report.Rows[6]; row.Id != report.Rows[0].Id {
+		t.Errorf("expected the repeated email to give the customer just created, got %+v", row)
+	}
+
+	// importing again creates nothing more
+	report = importCSV(t, customerTable, Importer{Validator: DefaultValidator}, ImportMappings["default"], data)
+	if report.Created != 1 || customerTable.Count() != count+3 {
+		// only the customer without an email cannot be recognized
+		t.Errorf("unexpected counts importing again %+v", report)
+	}
+}
+
+func TestImportMappings(t *testing.T) {
+	tests := []struct {
+		mapping, data string
+		expected      Customer
+	}{
+		{
+			"outlook",
+			"First Name,Middle Name,Last Name,Job Title,Business Phone,Mobile Phone,E-mail Address\n" +
+				"Harriet,D.,Vane,Author,,0412 345 678,hvane@example.com\n",
+			Customer{Name: "Harriet D. Vane", Role: "Author", Email: "hvane@example.com", Phone: "0412 345 678", PhoneE164: "+61412345678"},
+		},
+		{
+			"Google",
+			"Name,Given Name,Family Name,E-mail 1 - Type,E-mail 1 - Value,Phone 1 - Type,Phone 1 - Value,Organization 1 - Title\n" +
+				"Harriet Vane,Harriet,Vane,* Home,hvane@example.com ::: harriet@example.org,Mobile,+61 412 345 678,Author\n",
+			Customer{Name: "Harriet Vane", Role: "Author", Email: "hvane@example.com", Phone: "+61 412 345 678", PhoneE164: "+61412345678"},
+		},
+		{
+			`{"Full Name": "name", "Mobile": "phone"}`,
+			"full name,mobile,email\n" +
+				"Harriet Vane,0412 345 678,ignored@example.com\n",
+			Customer{Name: "Harriet Vane", Phone: "0412 345 678", PhoneE164: "+61412345678"},
+		},
+	}
+	for _, test := range tests {
+		mapping, err := ParseMapping(test.mapping)
+		if err != nil {
+			t.Fatalf("ParseMapping(%q): %v", test.mapping, err)
+		}
+		customerTable := &CustomerTable{}
+		report := importCSV(t, customerTable, Importer{Validator: DefaultValidator}, mapping, test.data)
+		checkRows(t, report, ImportCreated)
+		customer := customerTable.GetCustomerById(report.Rows[0].Id)
+		test.expected.Id, test.expected.Version = customer.Id, customer.Version
+		if *customer != test.expected {
+			t.Errorf("%s: imported %v, expected %v", test.mapping, *customer, test.expected)
+		}
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	for _, spec := range []string{"excel", `{"Name": "nickname"}`, `{"Name":`} {
+		if _, err := ParseMapping(spec); !errors.Is(err, ErrInvalidImport) {
+			t.Errorf("ParseMapping(%q) returned %v, expected ErrInvalidImport", spec, err)
+		}
+	}
+	if mapping, err := ParseMapping(""); err != nil || mapping["email"] != "email" {
+		t.Errorf("expected the default mapping, got %v, %v", mapping, err)
+	}
+	for _, data := range []string{"", "Nickname,Age\nHarriet,40\n"} {
+		if _, err := NewCSVDecoder(strings.NewReader(data), ImportMappings["default"]); !errors.Is(err, ErrInvalidImport) {
+			t.Errorf("NewCSVDecoder(%q) returned %v, expected ErrInvalidImport", data, err)
+		}
+	}
+
+	// a file that cannot be read further ends the import at that row
+	decoder := &failingDecoder{customers: []Customer{{Name: "Harriet Vane"}}}
+	report, err := Importer{Validator: DefaultValidator}.Import(&CustomerTable{}, decoder)
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	checkRows(t, report, ImportCreated, ImportFailed)
+	if report.Rows[1].Reason != "unreadable" || decoder.calls != 2 {
+		t.Errorf("expected the import to stop at the failure, got %+v after %d calls", report.Rows[1], decoder.calls)
+	}
+}
+
+// failingDecoder returns its customers, then fails
+type failingDecoder struct {
+	customers Customers
+	calls     int
+}
+
+func (d *failingDecoder) Decode() (*Customer, error) {
+	d.calls++
+	if d.calls > len(d.customers) {
+		return nil, errors.New("unreadable")
+	}
+	return &d.customers[d.calls-1], nil
+}
+
+func TestImportContext(t *testing.T) {
+	data := "name,email\nHarriet Vane,hvane@example.com\nPeter Wimsey,pwimsey@example.com\nMervyn Bunter,mbunter@example.com\n"
+	decoder, err := NewCSVDecoder(strings.NewReader(data), ImportMappings["default"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var rows []ImportRow
+	importer := Importer{Validator: DefaultValidator, Progress: func(row ImportRow) {
+		rows = append(rows, row)
+		if len(rows) == 2 {
+			cancel()
+		}
+	}}
+	report, err := importer.ImportContext(ctx, &CustomerTable{}, decoder)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the import to be cancelled, got %v", err)
+	}
+	if report == nil || len(report.Rows) != 2 || len(rows) != 2 || rows[1].Name != report.Rows[1].Name {
+		t.Errorf("expected a report of the rows before cancelling, got %+v and %+v", report, rows)
+	}
+}
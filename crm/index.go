@@ -0,0 +1,77 @@
+package crm
+
+import (
+	"sort"
+	"strings"
+)
+
+// idSet is a set of customer ids
+type idSet map[int64]struct{}
+
+// index maps a key to the set of ids of customers having that key
+type index map[string]idSet
+
+func (i index) add(key string, id int64) {
+	set, ok := i[key]
+	if !ok {
+		set = idSet{}
+		i[key] = set
+	}
+	set[id] = struct{}{}
+}
+
+func (i index) remove(key string, id int64) {
+	if set, ok := i[key]; ok {
+		delete(set, id)
+		if len(set) == 0 {
+			delete(i, key)
+		}
+	}
+}
+
+// ids returns the ids held for key in ascending order
+func (i index) ids(key string) []int64 {
+	set := i[key]
+	ids := make([]int64, 0, len(set))
+	for id := range set {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(a, b int) bool { return ids[a] < ids[b] })
+	return ids
+}
+
+func emailKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func roleKey(role string) string {
+	return strings.ToLower(strings.TrimSpace(role))
+}
+
+// insertId adds id to a sorted slice of ids
+func insertId(ids []int64, id int64) []int64 {
+	// ids are normally allocated in ascending order, so check the end first
+	if len(ids) == 0 || ids[len(ids)-1] < id {
+		return append(ids, id)
+	}
+	pos := sort.Search(len(ids), func(i int) bool { return ids[i] >= id })
+	ids = append(ids, 0)
+	copy(ids[pos+1:], ids[pos:])
+	ids[pos] = id
+	return ids
+}
+
+// containsId reports whether a sorted slice of ids holds id
+func containsId(ids []int64, id int64) bool {
+	pos := sort.Search(len(ids), func(i int) bool { return ids[i] >= id })
+	return pos < len(ids) && ids[pos] == id
+}
+
+// removeId removes id from a sorted slice of ids
+func removeId(ids []int64, id int64) []int64 {
+	pos := sort.Search(len(ids), func(i int) bool { return ids[i] >= id })
+	if pos < len(ids) && ids[pos] == id {
+		ids = append(ids[:pos], ids[pos+1:]...)
+	}
+	return ids
+}
@@ -0,0 +1,149 @@
+package crm
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestFindByEmail(t *testing.T) {
+	customerTable := ReadCustomers(t)
+
+	found := customerTable.FindByEmail("  BBruxner@DayRep.com ")
+	if len(found) != 1 || found[0].Id != 5 {
+		t.Errorf("FindByEmail returned %v, expected customer 5", found)
+	}
+
+	if _, err := customerTable.UpdateCustomerById(5, &Customer{Email: "bianca@people.au"}); err != nil {
+		t.Fatalf("UpdateCustomerById: %v", err)
+	}
+	if found = customerTable.FindByEmail("bbruxner@dayrep.com"); len(found) != 0 {
+		t.Errorf("FindByEmail found stale entry %v", found)
+	}
+	if found = customerTable.FindByEmail("bianca@people.au"); len(found) != 1 || found[0].Id != 5 {
+		t.Errorf("FindByEmail returned %v, expected customer 5", found)
+	}
+}
+
+func TestFindByRole(t *testing.T) {
+	customerTable := ReadCustomers(t)
+
+	if found := customerTable.FindByRole("Student"); len(found) != 14 {
+		t.Errorf("FindByRole returned %d customers, expected 14", len(found))
+	}
+	customerTable.NewCustomer("Peter Rabbit", "teacher", "", "")
+	if found := customerTable.FindByRole("teacher"); len(found) != 1 || found[0].Name != "Peter Rabbit" {
+		t.Errorf("FindByRole returned %v, expected Peter Rabbit", found)
+	}
+	if _, err := customerTable.DeleteCustomerById(1); err != nil {
+		t.Fatalf("DeleteCustomerById: %v", err)
+	}
+	found := customerTable.FindByRole("student")
+	if len(found) != 13 {
+		t.Errorf("FindByRole returned %d customers, expected 13", len(found))
+	}
+	for index := 1; index < len(found); index++ {
+		if found[index-1].Id >= found[index].Id {
+			t.Errorf("FindByRole results not ordered by id: %d, %d", found[index-1].Id, found[index].Id)
+		}
+	}
+}
+
+func TestFindByPhone(t *testing.T) {
+	customerTable := ReadCustomers(t)
+
+	for _, phone := range []string{"(07) 4938 5904", "0749385904", "+61 7 4938 5904", "0011 61 7 4938 5904", "+61 (0)7 4938-5904"} {
+		if found := customerTable.FindByPhone(phone); len(found) != 1 || found[0].Id != 5 {
+			t.Errorf("FindByPhone(%q) returned %v, expected customer 5", phone, found)
+		}
+	}
+	if found := customerTable.FindByPhone(""); len(found) != 0 {
+		t.Errorf("FindByPhone(\"\") returned %v, expected nothing", found)
+	}
+
+	if _, err := customerTable.UpdateCustomerById(5, &Customer{Phone: "0412 345 678"}); err != nil {
+		t.Fatalf("UpdateCustomerById: %v", err)
+	}
+	if found := customerTable.FindByPhone("07 4938 5904"); len(found) != 0 {
+		t.Errorf("FindByPhone found stale entry %v", found)
+	}
+	if found := customerTable.FindByPhone("+61412345678"); len(found) != 1 || found[0].PhoneE164 != "+61412345678" {
+		t.Errorf("FindByPhone returned %v, expected customer 5", found)
+	}
+}
+
+func TestFindByContacted(t *testing.T) {
+	customerTable := ReadCustomers(t)
+
+	if found := customerTable.FindByContacted(true); len(found) != 0 {
+		t.Errorf("FindByContacted(true) returned %d customers, expected 0", len(found))
+	}
+	if _, err := customerTable.UpdateCustomerById(12, &Customer{Contacted: true}); err != nil {
+		t.Fatalf("UpdateCustomerById: %v", err)
+	}
+	if found := customerTable.FindByContacted(true); len(found) != 1 || found[0].Id != 12 {
+		t.Errorf("FindByContacted(true) returned %v, expected customer 12", found)
+	}
+	if found := customerTable.FindByContacted(false); len(found) != 13 {
+		t.Errorf("FindByContacted(false) returned %d customers, expected 13", len(found))
+	}
+}
+
+func TestInsertRemoveId(t *testing.T) {
+	var ids []int64
+	for _, id := range []int64{5, 1, 9, 3, 7} {
+		ids = insertId(ids, id)
+	}
+	ids = removeId(ids, 9)
+	ids = removeId(ids, 4)
+	if expected := "[1 3 5 7]"; fmt.Sprint(ids) != expected {
+		t.Errorf("ids are %v, expected %s", ids, expected)
+	}
+}
+
+// Benchmarks demonstrating that lookup and insert costs stay flat as the table grows
+
+var benchmarkSizes = []int{1000, 10000, 100000}
+
+func benchmarkTable(size int) *CustomerTable {
+	customerTable := &CustomerTable{}
+	for i := 0; i < size; i++ {
+		customerTable.NewCustomer(fmt.Sprintf("Customer %d", i), "student",
+			fmt.Sprintf("customer%d@example.com", i), "(07) 5398 6183")
+	}
+	return customerTable
+}
+
+func BenchmarkGetCustomerById(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		customerTable := benchmarkTable(size)
+		b.Run(fmt.Sprint(size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				customerTable.GetCustomerById(int64(i%size) + 1)
+			}
+		})
+	}
+}
+
+func BenchmarkNewCustomer(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		customerTable := benchmarkTable(size)
+		b.Run(fmt.Sprint(size), func(b *testing.B) {
+			// distinct names and emails, so that the search index and suggestion trie grow
+			for i := 0; i < b.N; i++ {
+				customerTable.NewCustomer(fmt.Sprintf("Peter Rabbit%d", i), "teacher",
+					fmt.Sprintf("rabbit%d@bunbun.com.au", i), fmt.Sprintf("(06) 9%03d %04d", i/10000%1000, i%10000))
+			}
+		})
+	}
+}
+
+func BenchmarkFindByEmail(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		customerTable := benchmarkTable(size)
+		b.Run(fmt.Sprint(size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				customerTable.FindByEmail(fmt.Sprintf("customer%d@example.com", i%size))
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package crm
+
+import (
+	"time"
+)
+
+// MergeRule decides which value of a field is kept when merging two customers
+type MergeRule string
+
+const (
+	// MergeFill keeps the target's value unless it is empty (the default)
+	MergeFill MergeRule = "fill"
+	// MergeKeepTarget always keeps the target's value
+	MergeKeepTarget MergeRule = "target"
+	// MergeTakeSource always takes the source's value
+	MergeTakeSource MergeRule = "source"
+)
+
+// MergePolicy gives the rule applied to each field when merging, keyed by
+// json field name; fields without a rule are merged with MergeFill
+type MergePolicy map[string]MergeRule
+
+// mergeableFields are the fields whose values may be taken from either customer
+var mergeableFields = []string{"name", "role", "email", "phone", "contacted"}
+
+// MergeRecord records that a source customer was merged into a target.
+// Source holds the source customer as it was before the merge, and Fields
+// which customer ("target" or "source") each field's value was taken from.
+type MergeRecord struct {
+	TargetId int64             `json:"target_id"`
+	SourceId int64             `json:"source_id"`
+	Source   Customer          `json:"source"`
+	Fields   map[string]string `json:"fields"`
+	MergedAt time.Time         `json:"merged_at"`
+}
+
+// Validate checks that the policy only names mergeable fields and known rules
+func (p MergePolicy) Validate() error {
+	problems := &ValidationError{}
+	for field, rule := range p {
+		if !isMergeable(field) {
+			problems.Add("policy", "cannot merge field %q", field)
+		} else if rule != MergeFill && rule != MergeKeepTarget && rule != MergeTakeSource {
+			problems.Add("policy", "unknown rule %q for field %q", rule, field)
+		}
+	}
+	return problems.Err()
+}
+
+func isMergeable(name string) bool {
+	for _, field := range mergeableFields {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+// MergeCustomers folds source into target field by field according to the policy,
+// returning the merged target and which customer each field was taken from.
+// The id and version of the target are kept.
+func MergeCustomers(target, source Customer, policy MergePolicy) (Customer, map[string]string, error) {
+	if err := policy.Validate(); err != nil {
+		return Customer{}, nil, err
+	}
+	merged, taken := target, map[string]string{}
+	for _, name := range mergeableFields {
+		field, _ := LookupField(name)
+		rule := policy[name]
+		if rule == "" {
+			rule = MergeFill
+		}
+		if rule == MergeTakeSource || (rule == MergeFill && field.isEmpty(&target) && !field.isEmpty(&source)) {
+			field.Copy(&merged, &source)
+			taken[name] = string(MergeTakeSource)
+		} else {
+			taken[name] = string(MergeKeepTarget)
+		}
+	}
+	return merged, taken, nil
+}
+
+// Merge folds the source customer into the target according to the policy,
+// deleting the source and recording the merge. If version is non-zero the
+// target must still be at that version. The merged target is returned.
+func (t *CustomerTable) Merge(targetId, sourceId int64, policy MergePolicy, version int64) (*Customer, *MergeRecord, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if targetId == sourceId {
+		return nil, nil, fieldError("source", "must be a different customer to the target")
+	}
+	target, ok := t.customers[targetId]
+	if !ok {
+		return nil, nil, notFound(targetId)
+	}
+	source, ok := t.customers[sourceId]
+	if !ok {
+		return nil, nil, notFound(sourceId)
+	}
+	if err := checkVersion(target, version); err != nil {
+		return nil, nil, err
+	}
+	merged, taken, err := MergeCustomers(target, source, policy)
+	if err != nil {
+		return nil, nil, err
+	}
+	merged.Version++
+
+	// the source is removed first, so the target may take its email
+	t.remove(sourceId)
+	if err = t.checkUnique(merged); err != nil {
+		t.store(source)
+		return nil, nil, err
+	}
+	t.store(merged)
+	record := MergeRecord{TargetId: targetId, SourceId: sourceId, Source: source, Fields: taken, MergedAt: time.Now().UTC()}
+	t.merges = append(t.merges, record)
+	return &merged, &record, nil
+}
+
+// Merges returns the record of each customer merged into the given customer, oldest first
+func (t *CustomerTable) Merges(id int64) []MergeRecord {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	records := []MergeRecord{}
+	for _, record := range t.merges {
+		if record.TargetId == id {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// FindDuplicates finds the likely duplicates among the customers in the table
+func (t *CustomerTable) FindDuplicates(threshold float64) []DuplicateMatch {
+	t.mu.RLock()
+	customers, region := t.collect(t.ids), t.region
+	t.mu.RUnlock()
+	return FindDuplicates(customers, threshold, region)
+}
+
+// undoMerge reverses the most recent merge, restoring both customers
+func (t *CustomerTable) undoMerge(target Customer, record MergeRecord) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.store(target)
+	t.store(record.Source)
+	t.merges = t.merges[:len(t.merges)-1]
+}
+
+// applyMerge repeats a merge recorded in a journal
+func (t *CustomerTable) applyMerge(merged Customer, record MergeRecord) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.remove(record.SourceId)
+	t.store(merged)
+	t.merges = append(t.merges, record)
+}
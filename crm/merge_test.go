@@ -0,0 +1,113 @@
+package crm
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMergeCustomers(t *testing.T) {
+	target := Customer{Id: 5, Name: "Bianca Bruxner", Role: "student", Phone: "(07) 4938 5904", PhoneE164: "+61749385904", Version: 3}
+	source := Customer{Id: 20, Name: "B. Bruxner", Role: "staff", Email: "bianca@people.au", Phone: "0412 345 678", PhoneE164: "+61412345678", Contacted: true}
+
+	merged, taken, err := MergeCustomers(target, source, nil)
+	if err != nil {
+		t.Fatalf("MergeCustomers: %v", err)
+	}
+	expected := Customer{Id: 5, Name: "Bianca Bruxner", Role: "student", Email: "bianca@people.au", Phone: "(07) 4938 5904", PhoneE164: "+61749385904", Contacted: true, Version: 3}
+	if merged != expected {
+		t.Errorf("MergeCustomers filled\n Expected: %v\n   Actual: %v", expected, merged)
+	}
+	if taken["email"] != "source" || taken["name"] != "target" {
+		t.Errorf("unexpected fields taken %v", taken)
+	}
+
+	policy := MergePolicy{"role": MergeTakeSource, "phone": MergeTakeSource, "email": MergeKeepTarget}
+	if merged, _, err = MergeCustomers(target, source, policy); err != nil {
+		t.Fatalf("MergeCustomers: %v", err)
+	}
+	expected = Customer{Id: 5, Name: "Bianca Bruxner", Role: "staff", Phone: "0412 345 678", PhoneE164: "+61412345678", Contacted: true, Version: 3}
+	if merged != expected {
+		t.Errorf("MergeCustomers with policy\n Expected: %v\n   Actual: %v", expected, merged)
+	}
+
+	for _, policy := range []MergePolicy{{"id": MergeTakeSource}, {"role": "newest"}} {
+		if _, _, err = MergeCustomers(target, source, policy); !errors.Is(err, ErrValidation) {
+			t.Errorf("MergeCustomers(%v): expected ErrValidation, got %v", policy, err)
+		}
+	}
+}
+
+func TestTableMerge(t *testing.T) {
+	customerTable := ReadCustomers(t)
+	source := customerTable.NewCustomer("B. Bruxner", "staff", "bianca@people.au", "")
+
+	if _, _, err := customerTable.Merge(5, source.Id, nil, 7); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("Merge: expected ErrVersionMismatch, got %v", err)
+	}
+	if _, _, err := customerTable.Merge(5, 5, nil, 0); !errors.Is(err, ErrValidation) {
+		t.Errorf("Merge: expected ErrValidation merging a customer into itself, got %v", err)
+	}
+	if _, _, err := customerTable.Merge(5, 4, nil, 0); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Merge: expected ErrNotFound, got %v", err)
+	}
+
+	// the target may take the source's email even when emails must be unique
+	if err := customerTable.SetUniqueEmail(true); err != nil {
+		t.Fatalf("SetUniqueEmail: %v", err)
+	}
+	merged, record, err := customerTable.Merge(5, source.Id, MergePolicy{"email": MergeTakeSource}, 1)
+	if err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	if merged.Email != "bianca@people.au" || merged.Role != "student" || merged.Version != 2 {
+		t.Errorf("unexpected merged customer %v", merged)
+	}
+	if _, err = customerTable.Get(source.Id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("source customer was not deleted: %v", err)
+	}
+	if found := customerTable.FindByEmail("bianca@people.au"); len(found) != 1 || found[0].Id != 5 {
+		t.Errorf("FindByEmail returned %v, expected customer 5", found)
+	}
+	if record.SourceId != source.Id || record.Source != *source || record.Fields["email"] != "source" {
+		t.Errorf("unexpected merge record %v", record)
+	}
+	if records := customerTable.Merges(5); len(records) != 1 || records[0].SourceId != source.Id {
+		t.Errorf("Merges returned %v", records)
+	}
+	if records := customerTable.Merges(6); len(records) != 0 {
+		t.Errorf("Merges returned %v for a customer with no merges", records)
+	}
+}
+
+func TestFileStoreMerge(t *testing.T) {
+	dir := t.TempDir()
+	store := openFileStore(t, dir)
+	if err := store.ReadCustomerData(DATAFILE); err != nil {
+		t.Fatalf("ReadCustomerData: %v", err)
+	}
+	source, err := store.Create(&Customer{Name: "B. Bruxner", Email: "bianca@people.au"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, _, err = store.Merge(5, source.Id, MergePolicy{"email": MergeTakeSource}, 0); err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	crash(store)
+
+	// the merge is replayed from the journal, then kept in the snapshot
+	for i := 0; i < 2; i++ {
+		store = openFileStore(t, dir)
+		if customer, err := store.Get(5); err != nil || customer.Email != "bianca@people.au" {
+			t.Errorf("merged customer is %v, %v", customer, err)
+		}
+		if _, err = store.Get(source.Id); err == nil {
+			t.Errorf("merged source customer restored")
+		}
+		if records := store.Merges(5); len(records) != 1 || records[0].Source.Name != "B. Bruxner" {
+			t.Errorf("Merges returned %v", records)
+		}
+		if err = store.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+	}
+}
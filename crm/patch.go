@@ -0,0 +1,178 @@
+package crm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// MergePatch applies an RFC 7396 JSON Merge Patch document to the customer,
+// returning the patched copy. Members set to null are cleared, members
+// present are replaced and members absent are left untouched.
+// The customer id cannot be changed by a patch.
+func (c *Customer) MergePatch(patch []byte) (*Customer, error) {
+	var patchDoc any
+	if err := json.Unmarshal(patch, &patchDoc); err != nil {
+		return nil, err
+	}
+	patchObject, ok := patchDoc.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("merge patch must be a json object")
+	}
+	if id, ok := patchObject["id"]; ok && id != nil && id != float64(c.Id) {
+		return nil, fieldError("id", "cannot be changed")
+	}
+
+	target, err := c.toMap()
+	if err != nil {
+		return nil, err
+	}
+	patched, _ := mergePatch(target, patchObject).(map[string]any)
+	return customerFromMap(c.Id, patched)
+}
+
+// mergePatch implements the MergePatch algorithm described in RFC 7396
+func mergePatch(target any, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = map[string]any{}
+	}
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+		} else {
+			targetObject[name] = mergePatch(targetObject[name], value)
+		}
+	}
+	return targetObject
+}
+
+// toMap returns the generic json representation of a customer
+func (c *Customer) toMap() (map[string]any, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	object := map[string]any{}
+	err = json.Unmarshal(data, &object)
+	return object, err
+}
+
+// customerFromMap builds a customer from its generic json representation,
+// rejecting unknown members and forcing the given id
+func customerFromMap(id int64, object map[string]any) (*Customer, error) {
+	data, err := json.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+	customer := &Customer{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(customer); err != nil {
+		return nil, err
+	}
+	customer.Id = id
+	return customer, nil
+}
+
+// PatchOperation is a single RFC 6902 JSON Patch operation
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// JSONPatch applies an RFC 6902 JSON Patch document to the customer,
+// returning the patched copy. The "add", "remove", "replace" and "test"
+// operations are supported. Operations are applied in order to a copy,
+// so either all of them succeed or the customer is left unchanged;
+// a failing "test" operation returns ErrPatchTestFailed.
+func (c *Customer) JSONPatch(patch []byte) (*Customer, error) {
+	var operations []PatchOperation
+	if err := json.Unmarshal(patch, &operations); err != nil {
+		return nil, fmt.Errorf("json patch must be an array of operations: %w", err)
+	}
+	target := c.toFullMap()
+	for index, operation := range operations {
+		if err := operation.apply(target); err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s): %w", index, operation.Op, operation.Path, err)
+		}
+	}
+	if id, ok := target["id"].(float64); !ok || id != float64(c.Id) {
+		return nil, fieldError("id", "cannot be changed")
+	}
+	return customerFromMap(c.Id, target)
+}
+
+func (operation PatchOperation) apply(target map[string]any) error {
+	member, err := operation.member()
+	if err != nil {
+		return err
+	}
+	current, exists := target[member]
+	if !exists {
+		return fieldError(member, "does not exist")
+	}
+
+	var value any
+	switch operation.Op {
+	case "add", "replace", "test":
+		if operation.Value == nil {
+			return fieldError(member, "requires a value")
+		}
+		if err = json.Unmarshal(operation.Value, &value); err != nil {
+			return err
+		}
+	}
+
+	switch operation.Op {
+	case "add", "replace":
+		// customers have a fixed set of members, so add behaves as replace
+		target[member] = value
+	case "remove":
+		// members cannot be removed from a customer, only cleared
+		if current != nil {
+			target[member] = reflect.Zero(reflect.TypeOf(current)).Interface()
+		}
+	case "test":
+		if !reflect.DeepEqual(current, value) {
+			return fmt.Errorf("%w: %s is %v", ErrPatchTestFailed, operation.Path, current)
+		}
+	default:
+		return fieldError(member, "unsupported operation %q", operation.Op)
+	}
+	return nil
+}
+
+// member returns the customer member addressed by the operation's JSON Pointer
+func (operation PatchOperation) member() (string, error) {
+	if !strings.HasPrefix(operation.Path, "/") || strings.Count(operation.Path, "/") != 1 {
+		return "", fieldError(operation.Path, "does not address a customer field")
+	}
+	member := strings.NewReplacer("~1", "/", "~0", "~").Replace(operation.Path[1:])
+	return member, nil
+}
+
+// toFullMap returns the generic json representation of a customer including
+// members that would otherwise be omitted because they are empty
+func (c *Customer) toFullMap() map[string]any {
+	object := map[string]any{}
+	value := reflect.ValueOf(*c)
+	for index := 0; index < value.NumField(); index++ {
+		name := strings.Split(value.Type().Field(index).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		var member any
+		data, _ := json.Marshal(value.Field(index).Interface())
+		_ = json.Unmarshal(data, &member)
+		object[name] = member
+	}
+	return object
+}
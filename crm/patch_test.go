@@ -0,0 +1,110 @@
+package crm
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMergePatch(t *testing.T) {
+	original := Customer{Id: 5, Name: "Bianca Bruxner", Role: "student", Email: "bbruxner@dayrep.com", Phone: "(07) 4938 5904", Contacted: true}
+
+	tests := []struct {
+		name     string
+		patch    string
+		expected Customer
+	}{
+		{"empty patch", `{}`, original},
+		{"replace members", `{"role":"staff","email":"bianca@people.au"}`,
+			Customer{Id: 5, Name: "Bianca Bruxner", Role: "staff", Email: "bianca@people.au", Phone: "(07) 4938 5904", Contacted: true}},
+		{"null clears", `{"phone":null}`,
+			Customer{Id: 5, Name: "Bianca Bruxner", Role: "student", Email: "bbruxner@dayrep.com", Contacted: true}},
+		{"contacted back to false", `{"contacted":false}`,
+			Customer{Id: 5, Name: "Bianca Bruxner", Role: "student", Email: "bbruxner@dayrep.com", Phone: "(07) 4938 5904"}},
+		{"same id allowed", `{"id":5,"name":"B. Bruxner"}`,
+			Customer{Id: 5, Name: "B. Bruxner", Role: "student", Email: "bbruxner@dayrep.com", Phone: "(07) 4938 5904", Contacted: true}},
+	}
+	for _, test := range tests {
+		customer := original
+		patched, err := customer.MergePatch([]byte(test.patch))
+		if err != nil {
+			t.Errorf("%s: MergePatch: %v", test.name, err)
+		} else if *patched != test.expected {
+			t.Errorf("%s: MergePatch failed\n Expected: %v\n   Actual: %v", test.name, test.expected, *patched)
+		}
+		if customer != original {
+			t.Errorf("%s: MergePatch modified the original customer", test.name)
+		}
+	}
+}
+
+func TestMergePatchErrors(t *testing.T) {
+	customer := Customer{Id: 5, Name: "Bianca Bruxner"}
+	for _, patch := range []string{
+		`["name"]`,
+		`{"id":6}`,
+		`{"nmae":"Bianca"}`,
+		`{"contacted":"yes"}`,
+		`{"name":`,
+	} {
+		if _, err := customer.MergePatch([]byte(patch)); err == nil {
+			t.Errorf("MergePatch(%s) did not fail", patch)
+		}
+	}
+}
+
+func TestJSONPatch(t *testing.T) {
+	original := Customer{Id: 5, Name: "Bianca Bruxner", Role: "student", Email: "bbruxner@dayrep.com", Phone: "(07) 4938 5904"}
+
+	tests := []struct {
+		name     string
+		patch    string
+		expected Customer
+	}{
+		{"empty patch", `[]`, original},
+		{"replace", `[{"op":"replace","path":"/role","value":"staff"}]`,
+			Customer{Id: 5, Name: "Bianca Bruxner", Role: "staff", Email: "bbruxner@dayrep.com", Phone: "(07) 4938 5904"}},
+		{"test then replace", `[{"op":"test","path":"/contacted","value":false},{"op":"add","path":"/contacted","value":true}]`,
+			Customer{Id: 5, Name: "Bianca Bruxner", Role: "student", Email: "bbruxner@dayrep.com", Phone: "(07) 4938 5904", Contacted: true}},
+		{"remove", `[{"op":"test","path":"/id","value":5},{"op":"remove","path":"/phone"}]`,
+			Customer{Id: 5, Name: "Bianca Bruxner", Role: "student", Email: "bbruxner@dayrep.com"}},
+		{"applied in order", `[{"op":"replace","path":"/name","value":"B. Bruxner"},{"op":"test","path":"/name","value":"B. Bruxner"}]`,
+			Customer{Id: 5, Name: "B. Bruxner", Role: "student", Email: "bbruxner@dayrep.com", Phone: "(07) 4938 5904"}},
+	}
+	for _, test := range tests {
+		customer := original
+		patched, err := customer.JSONPatch([]byte(test.patch))
+		if err != nil {
+			t.Errorf("%s: JSONPatch: %v", test.name, err)
+		} else if *patched != test.expected {
+			t.Errorf("%s: JSONPatch failed\n Expected: %v\n   Actual: %v", test.name, test.expected, *patched)
+		}
+		if customer != original {
+			t.Errorf("%s: JSONPatch modified the original customer", test.name)
+		}
+	}
+}
+
+func TestJSONPatchErrors(t *testing.T) {
+	customer := Customer{Id: 5, Name: "Bianca Bruxner", Role: "student"}
+	for _, patch := range []string{
+		`{"op":"replace","path":"/role","value":"staff"}`,
+		`[{"op":"replace","path":"/role"}]`,
+		`[{"op":"replace","path":"/nmae","value":"Bianca"}]`,
+		`[{"op":"replace","path":"role","value":"staff"}]`,
+		`[{"op":"replace","path":"/name/first","value":"Bianca"}]`,
+		`[{"op":"move","from":"/name","path":"/role"}]`,
+		`[{"op":"replace","path":"/id","value":6}]`,
+		`[{"op":"replace","path":"/contacted","value":"yes"}]`,
+	} {
+		if _, err := customer.JSONPatch([]byte(patch)); err == nil {
+			t.Errorf("JSONPatch(%s) did not fail", patch)
+		} else if errors.Is(err, ErrPatchTestFailed) {
+			t.Errorf("JSONPatch(%s) returned a test failure: %v", patch, err)
+		}
+	}
+
+	patch := `[{"op":"replace","path":"/role","value":"staff"},{"op":"test","path":"/role","value":"student"}]`
+	if _, err := customer.JSONPatch([]byte(patch)); !errors.Is(err, ErrPatchTestFailed) {
+		t.Errorf("JSONPatch(%s) expected test failure, got %v", patch, err)
+	}
+}
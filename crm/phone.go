@@ -0,0 +1,155 @@
+package crm
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Region describes the parts of a country's numbering plan needed to
+// convert between national and international (E.164) phone numbers
+type Region struct {
+	Code           string   // ISO 3166-1 alpha-2 code
+	CountryCode    string   // international calling code
+	TrunkPrefix    string   // dialled before national numbers, if any
+	IDDPrefixes    []string // dialled before international numbers
+	NationalLength []int    // valid lengths of the national significant number
+}
+
+// Regions holds the numbering plans known to ParsePhone, keyed by region code
+var Regions = map[string]Region{
+	"AU": {Code: "AU", CountryCode: "61", TrunkPrefix: "0", IDDPrefixes: []string{"0011"}, NationalLength: []int{9}},
+	"NZ": {Code: "NZ", CountryCode: "64", TrunkPrefix: "0", IDDPrefixes: []string{"00"}, NationalLength: []int{8, 9, 10}},
+	"GB": {Code: "GB", CountryCode: "44", TrunkPrefix: "0", IDDPrefixes: []string{"00"}, NationalLength: []int{9, 10}},
+	"US": {Code: "US", CountryCode: "1", TrunkPrefix: "1", IDDPrefixes: []string{"011"}, NationalLength: []int{10}},
+}
+
+// DefaultRegion is the region assumed for phone numbers written in national
+// format when no other is given
+const DefaultRegion = "AU"
+
+// Phone is a parsed phone number
+type Phone struct {
+	E164     string // canonical international form, e.g. +61749385904
+	Region   string // region code, empty if the country code is not in Regions
+	National string // national significant number
+}
+
+// ParsePhone parses a phone number written in national or international format.
+// National numbers (e.g. "(07) 4938 5904") are interpreted using the numbering
+// plan of region, or DefaultRegion if region is empty. International numbers
+// may be written with a leading + (e.g. "+61 7 4938 5904") or the region's
+// international dialling prefix (e.g. "0011 61 7 4938 5904").
+func ParsePhone(input, region string) (Phone, error) {
+	if region == "" {
+		region = DefaultRegion
+	}
+	plan, ok := Regions[region]
+	if !ok {
+		return Phone{}, fmt.Errorf("unknown phone region %q", region)
+	}
+
+	digits, international, err := phoneDigits(input)
+	if err != nil {
+		return Phone{}, err
+	}
+	if !international {
+		for _, prefix := range plan.IDDPrefixes {
+			if strings.HasPrefix(digits, prefix) {
+				digits, international = digits[len(prefix):], true
+				break
+			}
+		}
+	}
+	if international {
+		return parseInternational(input, digits)
+	}
+	national := strings.TrimPrefix(digits, plan.TrunkPrefix)
+	if !plan.validLength(national) {
+		return Phone{}, fmt.Errorf("%q is not a valid %s phone number", input, plan.Code)
+	}
+	return Phone{E164: "+" + plan.CountryCode + national, Region: plan.Code, National: national}, nil
+}
+
+// parseInternational parses the digits of a number following the international prefix
+func parseInternational(input, digits string) (Phone, error) {
+	var plan *Region
+	for code := range Regions {
+		candidate := Regions[code]
+		if strings.HasPrefix(digits, candidate.CountryCode) && (plan == nil || len(candidate.CountryCode) > len(plan.CountryCode)) {
+			plan = &candidate
+		}
+	}
+	if plan == nil {
+		// unknown numbering plan, so accept anything of a length E.164 allows
+		if len(digits) < 8 || len(digits) > 15 {
+			return Phone{}, fmt.Errorf("%q is not a valid phone number", input)
+		}
+		return Phone{E164: "+" + digits}, nil
+	}
+	national := digits[len(plan.CountryCode):]
+	if !plan.validLength(national) && plan.TrunkPrefix != "" && strings.HasPrefix(national, plan.TrunkPrefix) {
+		// allow for the trunk prefix being included, as in +61 (0)7 4938 5904
+		national = national[len(plan.TrunkPrefix):]
+	}
+	if !plan.validLength(national) {
+		return Phone{}, fmt.Errorf("%q is not a valid %s phone number", input, plan.Code)
+	}
+	return Phone{E164: "+" + plan.CountryCode + national, Region: plan.Code, National: national}, nil
+}
+
+func (r Region) validLength(national string) bool {
+	for _, length := range r.NationalLength {
+		if len(national) == length {
+			return true
+		}
+	}
+	return false
+}
+
+// phoneDigits extracts the digits from a phone number, reporting whether it
+// was written with a leading + and rejecting characters not used in phone numbers
+func phoneDigits(input string) (string, bool, error) {
+	input = strings.TrimSpace(input)
+	international := strings.HasPrefix(input, "+")
+	var digits strings.Builder
+	for _, r := range strings.TrimPrefix(input, "+") {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case unicode.IsSpace(r) || strings.ContainsRune("()-./", r):
+		default:
+			return "", false, fmt.Errorf("%q is not a valid phone number", input)
+		}
+	}
+	if digits.Len() == 0 {
+		return "", false, fmt.Errorf("%q is not a valid phone number", input)
+	}
+	return digits.String(), international, nil
+}
+
+// PhoneKey returns the form of a phone number used to compare and search:
+// the E.164 form if it can be parsed in region (DefaultRegion if empty),
+// otherwise just its digits
+func PhoneKey(phone, region string) string {
+	if parsed, err := ParsePhone(phone, region); err == nil {
+		return parsed.E164
+	}
+	digits, _, _ := phoneDigits(phone)
+	return digits
+}
+
+// CanonicalPhone returns the E.164 form of a phone number written in region
+// (DefaultRegion if empty), or an empty string if it cannot be parsed
+func CanonicalPhone(phone, region string) string {
+	parsed, _ := ParsePhone(phone, region)
+	return parsed.E164
+}
+
+// phoneKey returns the search key for a customer's phone number, read in region if it has no canonical form
+func phoneKey(c Customer, region string) string {
+	if c.PhoneE164 != "" {
+		return c.PhoneE164
+	}
+	return PhoneKey(c.Phone, region)
+}
@@ -0,0 +1,104 @@
+package crm
+
+import "testing"
+
+func TestParsePhone(t *testing.T) {
+	tests := []struct {
+		input, region string
+		expected      Phone
+	}{
+		{"(07) 5398 6183", "", Phone{E164: "+61753986183", Region: "AU", National: "753986183"}},
+		{"0412 345 678", "AU", Phone{E164: "+61412345678", Region: "AU", National: "412345678"}},
+		{"+61 7 5398 6183", "", Phone{E164: "+61753986183", Region: "AU", National: "753986183"}},
+		{"+61 (0)7 5398 6183", "", Phone{E164: "+61753986183", Region: "AU", National: "753986183"}},
+		{"0011 61 7 5398 6183", "AU", Phone{E164: "+61753986183", Region: "AU", National: "753986183"}},
+		{"09-123 4567", "NZ", Phone{E164: "+6491234567", Region: "NZ", National: "91234567"}},
+		{"00 64 9 123 4567", "GB", Phone{E164: "+6491234567", Region: "NZ", National: "91234567"}},
+		{"020 7946 0018", "GB", Phone{E164: "+442079460018", Region: "GB", National: "2079460018"}},
+		{"(555) 555 5555", "US", Phone{E164: "+15555555555", Region: "US", National: "5555555555"}},
+		{"1-555-555-5555", "US", Phone{E164: "+15555555555", Region: "US", National: "5555555555"}},
+		{"011 61 7 5398 6183", "US", Phone{E164: "+61753986183", Region: "AU", National: "753986183"}},
+		{"+49 30 1234567", "AU", Phone{E164: "+49301234567"}},
+	}
+	for _, test := range tests {
+		if phone, err := ParsePhone(test.input, test.region); err != nil {
+			t.Errorf("ParsePhone(%q, %q): %v", test.input, test.region, err)
+		} else if phone != test.expected {
+			t.Errorf("ParsePhone(%q, %q) = %+v, expected %+v", test.input, test.region, phone, test.expected)
+		}
+	}
+}
+
+func TestParsePhoneInvalid(t *testing.T) {
+	tests := []struct{ input, region string }{
+		{"", ""},
+		{"call me", ""},
+		{"5550199", ""},
+		{"(555) 555 5555", "AU"},
+		{"+61 7 5398", ""},
+		{"+12", ""},
+		{"(07) 5398 6183", "XX"},
+	}
+	for _, test := range tests {
+		if phone, err := ParsePhone(test.input, test.region); err == nil {
+			t.Errorf("ParsePhone(%q, %q) = %+v, expected an error", test.input, test.region, phone)
+		}
+	}
+}
+
+func TestPhoneKey(t *testing.T) {
+	tests := []struct{ input, region, expected string }{
+		{"(07) 5398 6183", "", "+61753986183"},
+		{"+61753986183", "", "+61753986183"},
+		{"555-0199", "", "5550199"},
+		{"call me", "", ""},
+		{"(555) 555 5555", "US", "+15555555555"},
+		{"09-123 4567", "NZ", "+6491234567"},
+		{"+61753986183", "US", "+61753986183"},
+	}
+	for _, test := range tests {
+		if key := PhoneKey(test.input, test.region); key != test.expected {
+			t.Errorf("PhoneKey(%q, %q) = %q, expected %q", test.input, test.region, key, test.expected)
+		}
+	}
+}
+
+func TestSetRegion(t *testing.T) {
+	customerTable := ReadCustomers(t)
+	customer := customerTable.NewCustomer("Bill Gates", "founder", "", "(555) 555 5555")
+	if customers := customerTable.FindByPhone("+15555555555"); len(customers) != 0 {
+		t.Errorf("expected no customer read in AU, found %v", customers)
+	}
+
+	if err := customerTable.SetRegion("US"); err != nil {
+		t.Fatalf("SetRegion: %v", err)
+	}
+	// the phone index, lookups and queries all read national numbers in the region
+	for _, phone := range []string{"+1 555 555 5555", "1-555-555-5555"} {
+		if customers := customerTable.FindByPhone(phone); len(customers) != 1 || customers[0].Id != customer.Id {
+			t.Errorf("FindByPhone(%q) found %v", phone, customers)
+		}
+	}
+	if ids, _ := queryIds(t, customerTable, Query{Filters: []Condition{{Field: "phone", Op: OpEq, Values: []string{"(555) 555 5555"}}}}); !equalIds(ids, []int64{customer.Id}) {
+		t.Errorf("query found %v", ids)
+	}
+	filter, _ := ParseFilter("phone eq '555 555 5555'")
+	if ids, _ := queryIds(t, customerTable, Query{Filter: filter, Sort: []SortKey{{Field: "name"}}}); !equalIds(ids, []int64{customer.Id}) {
+		t.Errorf("filter found %v", ids)
+	}
+	// customers already canonical keep their E.164 form
+	if customers := customerTable.FindByPhone("+61749385904"); len(customers) != 1 || customers[0].Id != 5 {
+		t.Errorf("expected customer 5, found %v", customers)
+	}
+
+	if err := customerTable.SetRegion("XX"); err == nil {
+		t.Errorf("SetRegion accepted an unknown region")
+	}
+}
+
+func TestReadCustomerDataSetsPhoneE164(t *testing.T) {
+	customerTable := ReadCustomers(t)
+	if customer := customerTable.GetCustomerById(5); customer == nil || customer.PhoneE164 != "+61749385904" {
+		t.Errorf("customer 5 is %v, expected phone_e164 +61749385904", customer)
+	}
+}
@@ -0,0 +1,127 @@
+package crm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// Projection selects the fields of customers to be written, so that clients
+// needing only a few fields receive only those. Fields are always written in
+// CustomerFields order and, as for a full customer, omitted when empty.
+// A nil Projection selects every field.
+type Projection []CustomerField
+
+// ParseProjection builds a projection from url query parameters: either
+// fields=id,name listing the fields wanted, or exclude=phone,phone_e164 listing
+// those not wanted. It returns nil, selecting every field, if neither is given.
+func ParseProjection(values url.Values) (Projection, error) {
+	fields, exclude := values.Has("fields"), values.Has("exclude")
+	if !fields && !exclude {
+		return nil, nil
+	}
+	if fields && exclude {
+		return nil, fmt.Errorf("%w: fields and exclude may not be used together", ErrInvalidQuery)
+	}
+	key := "fields"
+	if exclude {
+		key = "exclude"
+	}
+	named := map[string]bool{}
+	for _, value := range values[key] {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				if _, ok := LookupField(name); !ok {
+					return nil, fmt.Errorf("%w: unknown field %q in %s", ErrInvalidQuery, name, key)
+				}
+				named[name] = true
+			}
+		}
+	}
+	projection := Projection{}
+	for _, field := range CustomerFields {
+		if named[field.Name] != exclude {
+			projection = append(projection, field)
+		}
+	}
+	if len(projection) == 0 {
+		return nil, fmt.Errorf("%w: %s leaves no fields", ErrInvalidQuery, key)
+	}
+	return projection, nil
+}
+
+// fields returns the fields selected
+func (p Projection) fields() []CustomerField {
+	if p == nil {
+		return CustomerFields
+	}
+	return p
+}
+
+// ToJSON writes the selected fields of a customer as a json object
+func (p Projection) ToJSON(c *Customer) (string, error) {
+	if p == nil {
+		return c.ToJSON()
+	}
+	buffer := bytes.NewBuffer(nil)
+	err := p.writeJSON(buffer, c)
+	buffer.WriteByte('\n')
+	return buffer.String(), err
+}
+
+// WriteJSON writes the selected fields of a customer as a json object, as ToJSON returns it
+func (p Projection) WriteJSON(writer io.Writer, c *Customer) error {
+	data, err := p.ToJSON(c)
+	if err == nil {
+		_, err = io.WriteString(writer, data)
+	}
+	return err
+}
+
+// CustomersToJSON writes the selected fields of a list of customers as a json array
+func (p Projection) CustomersToJSON(customers Customers) (string, error) {
+	buffer := bytes.NewBuffer(nil)
+	encoder := NewJSONEncoder(buffer, p)
+	for index := range customers {
+		if err := encoder.Encode(&customers[index]); err != nil {
+			return "", err
+		}
+	}
+	err := encoder.Close()
+	return buffer.String(), err
+}
+
+// writeJSON writes the selected fields of a customer as a json object, without a newline
+func (p Projection) writeJSON(writer io.Writer, c *Customer) error {
+	if p == nil {
+		data, err := json.Marshal(c)
+		if err == nil {
+			_, err = writer.Write(data)
+		}
+		return err
+	}
+	buffer := bytes.NewBuffer(nil)
+	buffer.WriteByte('{')
+	first := true
+	for _, field := range p {
+		if field.isEmpty(c) {
+			continue
+		}
+		value, err := json.Marshal(field.Value(c))
+		if err != nil {
+			return err
+		}
+		if !first {
+			buffer.WriteByte(',')
+		}
+		first = false
+		buffer.WriteString(`"` + field.Name + `":`)
+		buffer.Write(value)
+	}
+	buffer.WriteByte('}')
+	_, err := writer.Write(buffer.Bytes())
+	return err
+}
@@ -0,0 +1,70 @@
+package crm
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+)
+
+func TestParseProjection(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{"", nil},
+		{"sort=name", nil},
+		{"fields=id,name", []string{"id", "name"}},
+		{"fields=email,id&fields=name", []string{"id", "name", "email"}},
+		{"fields= name , id ,", []string{"id", "name"}},
+		{"exclude=phone,phone_e164,version", []string{"id", "name", "role", "email", "contacted"}},
+	}
+	for _, test := range tests {
+		values, _ := url.ParseQuery(test.query)
+		projection, err := ParseProjection(values)
+		if err != nil {
+			t.Errorf("ParseProjection(%q): %v", test.query, err)
+			continue
+		}
+		if (projection == nil) != (test.expected == nil) || len(projection) != len(test.expected) {
+			t.Errorf("ParseProjection(%q) selected %d fields, expected %v", test.query, len(projection), test.expected)
+			continue
+		}
+		for index, field := range projection {
+			if field.Name != test.expected[index] {
+				t.Errorf("ParseProjection(%q) field %d is %s, expected %s", test.query, index, field.Name, test.expected[index])
+			}
+		}
+	}
+
+	for _, query := range []string{"fields=id,nickname", "exclude=age", "fields=id&exclude=name", "fields=", "fields=,", "exclude=id,name,role,email,phone,phone_e164,contacted,version"} {
+		values, _ := url.ParseQuery(query)
+		if _, err := ParseProjection(values); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("ParseProjection(%q) returned %v, expected ErrInvalidQuery", query, err)
+		}
+	}
+}
+
+func TestProjectionToJSON(t *testing.T) {
+	customer := Customer{Id: 5, Name: "Bianca \"B\" Bruxner", Email: "bbruxner@dayrep.com", Version: 2}
+	values, _ := url.ParseQuery("fields=version,contacted,name,id,role")
+	projection, _ := ParseProjection(values)
+
+	// in field order, omitting empty fields
+	if data, err := projection.ToJSON(&customer); err != nil || data != `{"id":5,"name":"Bianca \"B\" Bruxner","version":2}`+"\n" {
+		t.Errorf("ToJSON returned %q, %v", data, err)
+	}
+	if data, err := projection.CustomersToJSON(Customers{customer, {Id: 7, Contacted: true}}); err != nil ||
+		data != `[{"id":5,"name":"Bianca \"B\" Bruxner","version":2},{"id":7,"contacted":true}]`+"\n" {
+		t.Errorf("CustomersToJSON returned %q, %v", data, err)
+	}
+	if data, err := projection.CustomersToJSON(Customers{}); err != nil || data != "[]\n" {
+		t.Errorf("CustomersToJSON of no customers returned %q, %v", data, err)
+	}
+
+	// a nil projection writes every field
+	var all Projection
+	expected, _ := customer.ToJSON()
+	if data, _ := all.ToJSON(&customer); data != expected {
+		t.Errorf("nil projection wrote %q, expected %q", data, expected)
+	}
+}
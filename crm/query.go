@@ -0,0 +1,474 @@
+package crm
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MaxQueryLimit is the largest page of customers a query may ask for
+const MaxQueryLimit = 1000
+
+// Condition operators
+const (
+	OpEq       = "eq"       // the field equals the value (strings compare case-insensitively)
+	OpContains = "contains" // the field contains the value, ignoring case
+)
+
+// Condition filters customers on a single field. A customer matches if
+// its field matches any of the values.
+type Condition struct {
+	Field  string
+	Op     string
+	Values []string
+}
+
+// SortKey orders customers by a field
+type SortKey struct {
+	Field      string
+	Descending bool
+}
+
+// Query selects, orders and pages through customers. Customers must match
+// every filter and the filter expression, if any; they are ordered by the sort
+// keys and then by id, and returned Limit at a time (all at once if Limit is 0)
+// starting after Cursor. Phone numbers written in national format are read in
+// the region of the store running the query, or in Region (DefaultRegion if
+// empty) when it is run over a list of customers.
+type Query struct {
+	Filters []Condition
+	Filter  Filter
+	Sort    []SortKey
+	Limit   int
+	Cursor  string
+	Region  string
+}
+
+// Page is one page of the results of a query. Total counts all the customers
+// matching the query, and Next is the cursor for the following page, if any.
+type Page struct {
+	Customers Customers
+	Total     int
+	Next      string
+}
+
+// Querier is implemented by stores that can run queries themselves,
+// typically using their indexes; otherwise a query may be run over List()
+type Querier interface {
+	Query(q Query) (Page, error)
+}
+
+// IdQuerier is implemented by stores that can run a query for the ids of the
+// customers it selects, in order, and then fetch those customers a batch at a
+// time. A sorted query read in pages is otherwise sorted afresh for each page.
+type IdQuerier interface {
+	// QueryIds returns the ids of the page of customers selected by a query,
+	// and the total matching it
+	QueryIds(q Query) ([]int64, int, error)
+	// GetMany returns the customers with the given ids, in the same order,
+	// leaving out any that no longer exist
+	GetMany(ids []int64) Customers
+}
+
+// NewCondition returns a condition on the named field, checking that the
+// operator applies to it and normalizing boolean and number values
+func NewCondition(name, op string, values ...string) (Condition, error) {
+	field, ok := LookupField(name)
+	if !ok {
+		return Condition{}, fmt.Errorf("%w: unknown field %q", ErrInvalidQuery, name)
+	}
+	if op != OpEq && (op != OpContains || field.Kind != StringField) {
+		return Condition{}, fmt.Errorf("%w: operator %s does not apply to %s", ErrInvalidQuery, op, name)
+	}
+	condition := Condition{Field: name, Op: op, Values: make([]string, 0, len(values))}
+	for _, value := range values {
+		switch field.Kind {
+		case BoolField:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return Condition{}, fmt.Errorf("%w: %s must be true or false, not %q", ErrInvalidQuery, name, value)
+			}
+			value = strconv.FormatBool(b)
+		case IntField:
+			i, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return Condition{}, fmt.Errorf("%w: %s must be a number, not %q", ErrInvalidQuery, name, value)
+			}
+			value = strconv.FormatInt(i, 10)
+		}
+		condition.Values = append(condition.Values, value)
+	}
+	return condition, nil
+}
+
+// matches reports whether a customer satisfies the condition, phone numbers
+// written in national format being read in region
+func (c Condition) matches(customer *Customer, region string) bool {
+	field, _ := LookupField(c.Field)
+	value := field.String(customer)
+	if field.normalize != nil && c.Op == OpEq {
+		value = field.normalize(value, region)
+	}
+	for _, want := range c.Values {
+		if field.normalize != nil && c.Op == OpEq {
+			want = field.normalize(want, region)
+		}
+		if c.Op == OpContains {
+			if strings.Contains(strings.ToLower(value), strings.ToLower(want)) {
+				return true
+			}
+		} else if strings.EqualFold(value, want) {
+			return true
+		}
+	}
+	return false
+}
+
+// queryParameter reports whether a parameter is one left to the caller of ParseQuery
+func queryParameter(key string, others []string) bool {
+	if key == "fields" || key == "exclude" {
+		return true
+	}
+	for _, other := range others {
+		if key == other {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseQuery builds a query from url query parameters:
+//   - field=value filters on a field being equal to the value, and field~=value
+//     on it containing the value; repeating a parameter matches any of its values
+//   - filter=expression filters with an expression (see Filter)
+//   - sort=-id,name orders by the listed fields, descending if prefixed by -
+//   - limit=n returns at most n customers
+//   - cursor=c continues from the page whose Next cursor is c
+//
+// The fields and exclude parameters, read by ParseProjection, are left to the
+// caller, as are those it names as others, while any other parameter is an
+// ErrInvalidQuery, so that a mistyped one is not silently ignored.
+func ParseQuery(values url.Values, others ...string) (Query, error) {
+	var query Query
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		var err error
+		switch key {
+		case "sort":
+			query.Sort, err = ParseSort(values.Get(key))
+		case "limit":
+			query.Limit, err = strconv.Atoi(values.Get(key))
+			if err != nil || query.Limit < 1 || query.Limit > MaxQueryLimit {
+				err = fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidQuery, MaxQueryLimit)
+			}
+		case "cursor":
+			query.Cursor = values.Get(key)
+		case "filter":
+			query.Filter, err = ParseFilter(values.Get(key))
+		default:
+			name, op := key, OpEq
+			if strings.HasSuffix(key, "~") {
+				name, op = strings.TrimSuffix(key, "~"), OpContains
+			}
+			if _, ok := LookupField(name); ok {
+				var condition Condition
+				if condition, err = NewCondition(name, op, values[key]...); err == nil {
+					query.Filters = append(query.Filters, condition)
+				}
+			} else if !queryParameter(key, others) {
+				err = fmt.Errorf("%w: unknown parameter %q", ErrInvalidQuery, key)
+			}
+		}
+		if err != nil {
+			return Query{}, err
+		}
+	}
+	return query, nil
+}
+
+// ParseSort parses a comma separated list of fields to sort by,
+// each prefixed by - to sort in descending order
+func ParseSort(spec string) ([]SortKey, error) {
+	var keys []SortKey
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		key := SortKey{Field: strings.TrimLeft(name, "+-"), Descending: strings.HasPrefix(name, "-")}
+		if _, ok := LookupField(key.Field); !ok {
+			return nil, fmt.Errorf("%w: unknown sort field %q", ErrInvalidQuery, name)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Run applies the query to a list of customers
+func (q Query) Run(customers Customers) (Page, error) {
+	matched := Customers{}
+	for index := range customers {
+		if q.matches(&customers[index]) {
+			matched = append(matched, customers[index])
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		return q.less(&matched[i], &matched[j])
+	})
+
+	page := Page{Total: len(matched)}
+	start, end := 0, len(matched)
+	if q.Cursor != "" {
+		after, err := q.decodeCursor()
+		if err != nil {
+			return Page{}, err
+		}
+		start = sort.Search(len(matched), func(i int) bool {
+			return q.less(&after, &matched[i])
+		})
+	}
+	if q.Limit > 0 && start+q.Limit < end {
+		end = start + q.Limit
+		page.Next = q.encodeCursor(&matched[end-1])
+	}
+	page.Customers = matched[start:end]
+	return page, nil
+}
+
+func (q Query) matches(customer *Customer) bool {
+	for _, condition := range q.Filters {
+		if !condition.matches(customer, q.Region) {
+			return false
+		}
+	}
+	return q.Filter == nil || q.Filter.Matches(customer, q.Region)
+}
+
+// less orders customers by the sort keys, then by id
+func (q Query) less(a, b *Customer) bool {
+	for _, key := range q.Sort {
+		field, _ := LookupField(key.Field)
+		if order := field.compare(a, b); order != 0 {
+			return (order < 0) != key.Descending
+		}
+	}
+	return a.Id < b.Id
+}
+
+// compare orders two customers by a field: strings ignoring case, and false before true
+func (f CustomerField) compare(a, b *Customer) int {
+	switch x := f.Value(a).(type) {
+	case string:
+		y := f.Value(b).(string)
+		if order := strings.Compare(strings.ToLower(x), strings.ToLower(y)); order != 0 {
+			return order
+		}
+		return strings.Compare(x, y)
+	case int64:
+		y := f.Value(b).(int64)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	case bool:
+		y := f.Value(b).(bool)
+		switch {
+		case !x && y:
+			return -1
+		case x && !y:
+			return 1
+		}
+	}
+	return 0
+}
+
+// sortSpec describes the sort order, to tie cursors to it
+func (q Query) sortSpec() string {
+	names := make([]string, 0, len(q.Sort))
+	for _, key := range q.Sort {
+		if key.Descending {
+			names = append(names, "-"+key.Field)
+		} else {
+			names = append(names, key.Field)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// cursor identifies a position in the results of a query by the sort
+// fields of the last customer returned, so that pages remain consistent
+// when customers are added or removed between requests
+type cursor struct {
+	Sort  string   `json:"s"`
+	After Customer `json:"a"`
+}
+
+func (q Query) encodeCursor(last *Customer) string {
+	position := cursor{Sort: q.sortSpec(), After: Customer{Id: last.Id}}
+	for _, key := range q.Sort {
+		field, _ := LookupField(key.Field)
+		field.Copy(&position.After, last)
+	}
+	data, _ := json.Marshal(position)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func (q Query) decodeCursor() (Customer, error) {
+	var position cursor
+	data, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err == nil {
+		err = json.Unmarshal(data, &position)
+	}
+	if err != nil {
+		return Customer{}, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	if position.Sort != q.sortSpec() {
+		return Customer{}, fmt.Errorf("%w: cursor does not match the sort order", ErrInvalidQuery)
+	}
+	return position.After, nil
+}
+
+// Query runs a query against the table, using the indexes to narrow the
+// customers considered where the query filters on an indexed field
+func (t *CustomerTable) Query(q Query) (Page, error) {
+	if len(q.Sort) == 0 {
+		return t.queryById(q)
+	}
+	t.mu.RLock()
+	q.Region = t.region
+	customers := t.collect(t.plan(q))
+	t.mu.RUnlock()
+	return q.Run(customers)
+}
+
+// QueryIds runs a query against the table, as Query does, returning only the
+// ids of the customers on the page, so that one sort serves a whole list
+func (t *CustomerTable) QueryIds(q Query) ([]int64, int, error) {
+	page, err := t.Query(q)
+	if err != nil {
+		return nil, 0, err
+	}
+	ids := make([]int64, 0, len(page.Customers))
+	for index := range page.Customers {
+		ids = append(ids, page.Customers[index].Id)
+	}
+	return ids, page.Total, nil
+}
+
+// GetMany returns the customers with the given ids that still exist, in order
+func (t *CustomerTable) GetMany(ids []int64) Customers {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	customers := make(Customers, 0, len(ids))
+	for _, id := range ids {
+		if customer, ok := t.customers[id]; ok {
+			customers = append(customers, customer)
+		}
+	}
+	return customers
+}
+
+// queryById runs a query in id order, the order in which the table holds its
+// ids, copying only the customers on the page requested. Paging through every
+// customer a page at a time therefore needs no more memory than a page.
+func (t *CustomerTable) queryById(q Query) (Page, error) {
+	var after Customer
+	if q.Cursor != "" {
+		var err error
+		if after, err = q.decodeCursor(); err != nil {
+			return Page{}, err
+		}
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	q.Region = t.region
+	page := Page{Customers: Customers{}}
+	for _, id := range t.plan(q) {
+		customer := t.customers[id]
+		if !q.matches(&customer) {
+			continue
+		}
+		page.Total++
+		if id <= after.Id {
+			continue
+		}
+		if q.Limit == 0 || len(page.Customers) < q.Limit {
+			page.Customers = append(page.Customers, customer)
+		} else if page.Next == "" {
+			page.Next = q.encodeCursor(&page.Customers[len(page.Customers)-1])
+		}
+	}
+	return page, nil
+}
+
+// plan returns the ids of the customers that may match a query: those found
+// in the indexes for every condition and filter expression that can use them,
+// or else every customer. The caller must hold the lock.
+func (t *CustomerTable) plan(q Query) []int64 {
+	var (
+		candidates []int64
+		narrowed   bool
+	)
+	narrow := func(ids []int64) {
+		if narrowed {
+			candidates = intersectIds(candidates, ids)
+		} else {
+			candidates, narrowed = ids, true
+		}
+	}
+	for _, condition := range q.Filters {
+		if ids, ok := t.lookup(condition); ok {
+			narrow(ids)
+		}
+	}
+	if q.Filter != nil {
+		if ids, ok := t.candidates(q.Filter); ok {
+			narrow(ids)
+		}
+	}
+	if !narrowed {
+		return t.ids
+	}
+	return candidates
+}
+
+// lookup returns the ids of the customers matching an equality condition on
+// an indexed field. The caller must hold the lock.
+func (t *CustomerTable) lookup(condition Condition) ([]int64, bool) {
+	if condition.Op != OpEq {
+		return nil, false
+	}
+	var (
+		byKey index
+		key   func(string) string
+	)
+	switch condition.Field {
+	case "email":
+		byKey, key = t.byEmail, emailKey
+	case "role":
+		byKey, key = t.byRole, roleKey
+	case "phone":
+		byKey, key = t.byPhone, func(phone string) string {
+			return PhoneKey(phone, t.region)
+		}
+	case "contacted":
+		byKey, key = t.byContacted, strings.TrimSpace
+	default:
+		return nil, false
+	}
+	var ids []int64
+	for _, value := range condition.Values {
+		for _, id := range byKey.ids(key(value)) {
+			ids = insertId(ids, id)
+		}
+	}
+	return ids, true
+}
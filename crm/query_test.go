@@ -0,0 +1,220 @@
+package crm
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+)
+
+func queryIds(t *testing.T, querier Querier, query Query) ([]int64, Page) {
+	page, err := querier.Query(query)
+	if err != nil {
+		t.Fatalf("Query(%v): %v", query, err)
+	}
+	ids := make([]int64, 0, len(page.Customers))
+	for _, customer := range page.Customers {
+		ids = append(ids, customer.Id)
+	}
+	return ids, page
+}
+
+func equalIds(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for index := range a {
+		if a[index] != b[index] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestParseQuery(t *testing.T) {
+	values, _ := url.ParseQuery("role=Student&contacted=0&email~=dayrep&sort=-id,name&limit=5&cursor=abc&fields=name")
+	query, err := ParseQuery(values)
+	if err != nil {
+		t.Fatalf("ParseQuery: %v", err)
+	}
+	if len(query.Filters) != 3 {
+		t.Fatalf("expected 3 filters, got %v", query.Filters)
+	}
+	// filters are in parameter order, and values normalized
+	expected := []Condition{
+		{Field: "contacted", Op: OpEq, Values: []string{"false"}},
+		{Field: "email", Op: OpContains, Values: []string{"dayrep"}},
+		{Field: "role", Op: OpEq, Values: []string{"Student"}},
+	}
+	for index, condition := range expected {
+		filter := query.Filters[index]
+		if filter.Field != condition.Field || filter.Op != condition.Op || filter.Values[0] != condition.Values[0] {
+			t.Errorf("filter %d is %v, expected %v", index, filter, condition)
+		}
+	}
+	if len(query.Sort) != 2 || query.Sort[0] != (SortKey{"id", true}) || query.Sort[1] != (SortKey{"name", false}) {
+		t.Errorf("unexpected sort %v", query.Sort)
+	}
+	if query.Limit != 5 || query.Cursor != "abc" {
+		t.Errorf("unexpected limit %d, cursor %q", query.Limit, query.Cursor)
+	}
+
+	for _, raw := range []string{"contacted=maybe", "id=five", "contacted~=true", "sort=age", "sort=", "limit=0", "limit=1001", "limit=ten", "colour=red", "rol=student", "format=csv"} {
+		values, _ = url.ParseQuery(raw)
+		if _, err = ParseQuery(values); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("ParseQuery(%s): expected ErrInvalidQuery, got %v", raw, err)
+		}
+	}
+	// parameters named by the caller are left to it
+	values, _ = url.ParseQuery("format=csv&exclude=phone&role=student")
+	if query, err = ParseQuery(values, "format"); err != nil || len(query.Filters) != 1 {
+		t.Errorf("unexpected query %+v (error %v)", query, err)
+	}
+}
+
+func TestQueryFilters(t *testing.T) {
+	customerTable := ReadCustomers(t)
+	if _, err := customerTable.UpdateCustomerById(12, &Customer{Contacted: true, Role: "staff"}); err != nil {
+		t.Fatalf("UpdateCustomerById: %v", err)
+	}
+	condition := func(field, op string, values ...string) Condition {
+		c, err := NewCondition(field, op, values...)
+		if err != nil {
+			t.Fatalf("NewCondition: %v", err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		filters  []Condition
+		expected []int64
+	}{
+		{[]Condition{condition("role", OpEq, "STAFF")}, []int64{12}},
+		{[]Condition{condition("contacted", OpEq, "true")}, []int64{12}},
+		{[]Condition{condition("email", OpContains, "DayRep")}, []int64{2, 5, 17}},
+		{[]Condition{condition("email", OpEq, "bbruxner@dayrep.com")}, []int64{5}},
+		{[]Condition{condition("phone", OpEq, "+61 7 4938 5904")}, []int64{5}},
+		{[]Condition{condition("phone", OpContains, "4938")}, []int64{5}},
+		{[]Condition{condition("id", OpEq, "5", "7", "4")}, []int64{5, 7}},
+		{[]Condition{condition("role", OpEq, "student", "staff"), condition("contacted", OpEq, "true")}, []int64{12}},
+		{[]Condition{condition("role", OpEq, "staff"), condition("contacted", OpEq, "false")}, []int64{}},
+	}
+	for _, test := range tests {
+		query := Query{Filters: test.filters}
+		// the table's planner must agree with a plain scan
+		ids, page := queryIds(t, customerTable, query)
+		scanned, _ := query.Run(*customerTable.GetAllCustomers())
+		if !equalIds(ids, test.expected) {
+			t.Errorf("Query(%v) returned %v, expected %v", test.filters, ids, test.expected)
+		} else if page.Total != len(test.expected) || len(scanned.Customers) != len(test.expected) {
+			t.Errorf("Query(%v): total %d, scan found %d, expected %d", test.filters, page.Total, len(scanned.Customers), len(test.expected))
+		}
+	}
+}
+
+func TestQuerySortAndPages(t *testing.T) {
+	customerTable := ReadCustomers(t)
+
+	ids, _ := queryIds(t, customerTable, Query{Sort: []SortKey{{Field: "id", Descending: true}}, Limit: 3})
+	if !equalIds(ids, []int64{19, 18, 17}) {
+		t.Errorf("sort=-id returned %v", ids)
+	}
+	ids, _ = queryIds(t, customerTable, Query{Sort: []SortKey{{Field: "name"}}, Limit: 2})
+	if !equalIds(ids, []int64{8, 5}) {
+		t.Errorf("sort=name returned %v", ids)
+	}
+
+	// paging through the whole table visits every customer once, in order
+	query := Query{Sort: []SortKey{{Field: "role"}, {Field: "name", Descending: true}}, Limit: 4}
+	all, _ := queryIds(t, customerTable, Query{Sort: query.Sort})
+	var paged []int64
+	for pages := 0; ; pages++ {
+		ids, page := queryIds(t, customerTable, query)
+		if page.Total != 14 {
+			t.Errorf("page total is %d, expected 14", page.Total)
+		}
+		paged = append(paged, ids...)
+		if page.Next == "" {
+			if pages != 3 {
+				t.Errorf("expected 4 pages, got %d", pages+1)
+			}
+			break
+		}
+		query.Cursor = page.Next
+	}
+	if !equalIds(paged, all) {
+		t.Errorf("pages returned %v, expected %v", paged, all)
+	}
+
+	// a cursor remains valid when the customer it follows is deleted
+	query = Query{Limit: 2}
+	_, page := queryIds(t, customerTable, query)
+	if _, err := customerTable.DeleteCustomerById(2); err != nil {
+		t.Fatalf("DeleteCustomerById: %v", err)
+	}
+	query.Cursor = page.Next
+	if ids, _ = queryIds(t, customerTable, query); !equalIds(ids, []int64{3, 5}) {
+		t.Errorf("page after deleted customer returned %v", ids)
+	}
+
+	for _, cursor := range []string{"!!!", page.Next} {
+		query = Query{Sort: []SortKey{{Field: "name"}}, Cursor: cursor}
+		if _, err := customerTable.Query(query); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("Query with cursor %q: expected ErrInvalidQuery, got %v", cursor, err)
+		}
+	}
+}
+
+func TestQueryById(t *testing.T) {
+	customerTable := ReadCustomers(t)
+	customers, _ := customerTable.List()
+	for _, spec := range []string{"", "limit=3", "role=student&limit=5", "email~=dayrep&limit=1", "filter=id%20gt%2010&limit=2", "phone=nobody"} {
+		values, _ := url.ParseQuery(spec)
+		query, err := ParseQuery(values)
+		if err != nil {
+			t.Fatalf("ParseQuery(%q): %v", spec, err)
+		}
+		// page through both, expecting the same pages as running the query over the list
+		for pages := 0; pages < 20; pages++ {
+			ids, page := queryIds(t, customerTable, query)
+			expected, err := query.Run(customers)
+			if err != nil {
+				t.Fatalf("Run(%q): %v", spec, err)
+			}
+			expectedIds := make([]int64, 0, len(expected.Customers))
+			for _, customer := range expected.Customers {
+				expectedIds = append(expectedIds, customer.Id)
+			}
+			if !equalIds(ids, expectedIds) || page.Total != expected.Total || page.Next != expected.Next {
+				t.Errorf("%q page %d: got %v of %d next %q, expected %v of %d next %q",
+					spec, pages, ids, page.Total, page.Next, expectedIds, expected.Total, expected.Next)
+			}
+			if page.Next == "" {
+				break
+			}
+			query.Cursor = page.Next
+		}
+	}
+}
+
+func TestQueryIds(t *testing.T) {
+	customerTable := ReadCustomers(t)
+	query := Query{Filters: []Condition{{Field: "role", Op: OpEq, Values: []string{"student"}}}, Sort: []SortKey{{Field: "name"}}}
+	expected, page := queryIds(t, customerTable, query)
+	ids, total, err := customerTable.QueryIds(query)
+	if err != nil || !equalIds(ids, expected) || total != page.Total {
+		t.Errorf("QueryIds returned %v of %d (error %v), expected %v of %d", ids, total, err, expected, page.Total)
+	}
+
+	// customers deleted since are left out
+	if _, err = customerTable.DeleteCustomerById(ids[1]); err != nil {
+		t.Fatalf("DeleteCustomerById: %v", err)
+	}
+	customers := customerTable.GetMany(ids[:3])
+	if len(customers) != 2 || customers[0].Id != ids[0] || customers[1].Id != ids[2] {
+		t.Errorf("GetMany(%v) returned %v", ids[:3], customers)
+	}
+
+	if _, _, err = customerTable.QueryIds(Query{Sort: query.Sort, Cursor: "!!!"}); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("expected ErrInvalidQuery, got %v", err)
+	}
+}
@@ -0,0 +1,382 @@
+package crm
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Markers placed around the matched parts of highlighted fields
+const (
+	HighlightStart = "<em>"
+	HighlightEnd   = "</em>"
+)
+
+// DefaultSearchLimit is the number of search results returned unless asked otherwise
+const DefaultSearchLimit = 20
+
+// minPhoneTerm is the fewest digits of a phone number that are indexed for searching
+const minPhoneTerm = 3
+
+// SearchResult is a customer found by a search, with its relevance score and
+// the fields that matched, the matched text marked by HighlightStart and HighlightEnd.
+// Highlights are html: the customer's text is escaped, so that only the markers are markup.
+type SearchResult struct {
+	Customer   Customer          `json:"customer"`
+	Score      float64           `json:"score"`
+	Highlights map[string]string `json:"highlights"`
+}
+
+// Searcher is implemented by stores that maintain their own search index
+type Searcher interface {
+	Search(query string, limit int) ([]SearchResult, error)
+}
+
+// searchable fields, and the weight given to a match in each
+type searchField uint8
+
+const (
+	searchName searchField = 1 << iota
+	searchEmail
+	searchPhone
+	searchRole
+)
+
+var searchFields = []struct {
+	field  searchField
+	name   string
+	weight float64
+}{
+	{searchName, "name", 3},
+	{searchEmail, "email", 2},
+	{searchPhone, "phone", 2},
+	{searchRole, "role", 1},
+}
+
+// searchIndex is an inverted index from terms to the customers containing
+// them, and the fields in which they occur. Terms are also kept in sorted
+// order so that those starting with a prefix can be found; new terms are
+// sorted into them when next searched, so that adding one costs no more
+// than a map insertion however many there are.
+type searchIndex struct {
+	postings map[string]map[int64]searchField
+	// mu guards the terms, which are sorted by searches holding only the table's read lock
+	mu      sync.Mutex
+	terms   []string // sorted, possibly including some since removed
+	added   []string // terms added since terms was sorted
+	removed int      // the number of terms removed since terms was sorted
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{postings: map[string]map[int64]searchField{}}
+}
+
+// searchTerms returns the terms under which a customer is indexed:
+// the words of the name, role and email (both its local part and domain),
+// and every run of at least minPhoneTerm digits ending a
+// phone number so that any few digits of it may be found
+func searchTerms(c Customer) map[string]searchField {
+	terms := map[string]searchField{}
+	add := func(field searchField, words ...string) {
+		for _, word := range words {
+			if word != "" {
+				terms[word] |= field
+			}
+		}
+	}
+	add(searchName, textTerms(c.Name)...)
+	add(searchRole, textTerms(c.Role)...)
+	add(searchEmail, textTerms(c.Email)...)
+	for _, digits := range []string{digitsOf(c.Phone), digitsOf(c.PhoneE164)} {
+		for start := 0; start+minPhoneTerm <= len(digits); start++ {
+			add(searchPhone, digits[start:])
+		}
+	}
+	return terms
+}
+
+func (s *searchIndex) add(c Customer) {
+	for term, fields := range searchTerms(c) {
+		ids, ok := s.postings[term]
+		if !ok {
+			ids = map[int64]searchField{}
+			s.postings[term] = ids
+			s.mu.Lock()
+			s.added = append(s.added, term)
+			s.mu.Unlock()
+		}
+		ids[c.Id] = fields
+	}
+}
+
+func (s *searchIndex) remove(c Customer) {
+	for term := range searchTerms(c) {
+		if ids, ok := s.postings[term]; ok {
+			delete(ids, c.Id)
+			if len(ids) == 0 {
+				delete(s.postings, term)
+				s.mu.Lock()
+				s.removed++
+				s.mu.Unlock()
+			}
+		}
+	}
+}
+
+// sortedTerms returns the terms in order, first merging those added since they
+// were last sorted and dropping those removed. The slice returned is never
+// changed, so it may be used while other searches sort the terms again.
+func (s *searchIndex) sortedTerms() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.added) == 0 && s.removed == 0 {
+		return s.terms
+	}
+	sort.Strings(s.added)
+	terms := make([]string, 0, len(s.terms)+len(s.added)-s.removed)
+	keep := func(term string) {
+		// a term removed and added again may appear twice
+		if _, ok := s.postings[term]; ok && (len(terms) == 0 || terms[len(terms)-1] != term) {
+			terms = append(terms, term)
+		}
+	}
+	i, j := 0, 0
+	for i < len(s.terms) || j < len(s.added) {
+		if j == len(s.added) || (i < len(s.terms) && s.terms[i] <= s.added[j]) {
+			keep(s.terms[i])
+			i++
+		} else {
+			keep(s.added[j])
+			j++
+		}
+	}
+	s.terms, s.added, s.removed = terms, nil, 0
+	return terms
+}
+
+// match finds the customers having a term that starts with the token, scoring
+// each by the best field matched: an exact match scores the field's weight, and
+// a prefix match less the more of the term is left unmatched
+func (s *searchIndex) match(token string) (map[int64]float64, map[int64]searchField) {
+	scores, fields := map[int64]float64{}, map[int64]searchField{}
+	terms := s.sortedTerms()
+	for pos := sort.SearchStrings(terms, token); pos < len(terms) && strings.HasPrefix(terms[pos], token); pos++ {
+		term := terms[pos]
+		closeness := 1.0
+		if term != token {
+			closeness = 0.5 + 0.5*float64(len(token))/float64(len(term))
+		}
+		for id, found := range s.postings[term] {
+			fields[id] |= found
+			for _, field := range searchFields {
+				if found&field.field != 0 && field.weight*closeness > scores[id] {
+					scores[id] = field.weight * closeness
+				}
+			}
+		}
+	}
+	return scores, fields
+}
+
+// search returns the ids of the customers matching every word of the query
+// (as a word or the start of one), with their scores and the fields matched
+func (s *searchIndex) search(query string) (map[int64]float64, map[int64]searchField, error) {
+	tokens := textTerms(query)
+	if len(tokens) == 0 {
+		return nil, nil, fmt.Errorf("%w: search query has no words", ErrInvalidQuery)
+	}
+	var scores map[int64]float64
+	fields := map[int64]searchField{}
+	for index, token := range tokens {
+		matched, matchedFields := s.match(token)
+		if index == 0 {
+			scores = matched
+		} else {
+			for id := range scores {
+				if score, ok := matched[id]; ok {
+					scores[id] += score
+				} else {
+					delete(scores, id)
+				}
+			}
+		}
+		for id, found := range matchedFields {
+			fields[id] |= found
+		}
+	}
+	return scores, fields, nil
+}
+
+// rankResults orders the matched customers by descending score, then by id,
+// and highlights the matched fields of at most limit of them
+func rankResults(query string, customers Customers, scores map[int64]float64, fields map[int64]searchField, limit int) []SearchResult {
+	results := make([]SearchResult, 0, len(customers))
+	tokens := textTerms(query)
+	for _, customer := range customers {
+		results = append(results, SearchResult{
+			Customer: customer,
+			Score:    math.Round(scores[customer.Id]*100) / 100,
+		})
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Customer.Id < results[j].Customer.Id
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	for index := range results {
+		results[index].Highlights = highlights(&results[index].Customer, fields[results[index].Customer.Id], tokens)
+	}
+	return results
+}
+
+// highlights marks the words of the query found in each matched field of a customer
+func highlights(c *Customer, matched searchField, tokens []string) map[string]string {
+	marked := map[string]string{}
+	for _, field := range searchFields {
+		if matched&field.field == 0 {
+			continue
+		}
+		switch field.field {
+		case searchName:
+			marked[field.name] = highlightWords(c.Name, tokens)
+		case searchRole:
+			marked[field.name] = highlightWords(c.Role, tokens)
+		case searchEmail:
+			marked[field.name] = highlightWords(c.Email, tokens)
+		case searchPhone:
+			marked[field.name] = highlightDigits(c.Phone, tokens)
+		}
+	}
+	return marked
+}
+
+// highlightWords marks the places in text where a word starts with one of the tokens
+func highlightWords(text string, tokens []string) string {
+	folded := []rune(foldText(text))
+	marks := make([]bool, len(folded))
+	for start := range folded {
+		if start > 0 && isWordRune(folded[start-1]) {
+			continue
+		}
+		for _, token := range tokens {
+			if length := utf8.RuneCountInString(token); hasRunePrefix(folded[start:], token) {
+				for i := start; i < start+length; i++ {
+					marks[i] = true
+				}
+			}
+		}
+	}
+	return markRunes([]rune(text), marks)
+}
+
+// highlightDigits marks the digits of a phone number matching any numeric token,
+// along with any spaces and punctuation between them
+func highlightDigits(phone string, tokens []string) string {
+	runes := []rune(phone)
+	var (
+		digits    []rune
+		positions []int
+	)
+	for index, r := range runes {
+		if r >= '0' && r <= '9' {
+			digits = append(digits, r)
+			positions = append(positions, index)
+		}
+	}
+	marks := make([]bool, len(runes))
+	for _, token := range tokens {
+		if digitsOf(token) != token {
+			continue
+		}
+		for start := range digits {
+			if hasRunePrefix(digits[start:], token) {
+				for i := positions[start]; i <= positions[start+len(token)-1]; i++ {
+					marks[i] = true
+				}
+			}
+		}
+	}
+	return markRunes(runes, marks)
+}
+
+func hasRunePrefix(runes []rune, prefix string) bool {
+	index := 0
+	for _, r := range prefix {
+		if index >= len(runes) || runes[index] != r {
+			return false
+		}
+		index++
+	}
+	return true
+}
+
+// markRunes wraps each run of marked runes in the highlight markers,
+// escaping the text as html
+func markRunes(runes []rune, marks []bool) string {
+	var text strings.Builder
+	start := 0
+	for index := range runes {
+		if index == len(runes)-1 || marks[index] != marks[index+1] {
+			run := html.EscapeString(string(runes[start : index+1]))
+			if marks[index] {
+				run = HighlightStart + run + HighlightEnd
+			}
+			text.WriteString(run)
+			start = index + 1
+		}
+	}
+	return text.String()
+}
+
+// SearchCustomers searches a list of customers, indexing them first.
+// Stores that maintain a search index implement Searcher instead.
+func SearchCustomers(customers Customers, query string, limit int) ([]SearchResult, error) {
+	index := newSearchIndex()
+	byId := map[int64]Customer{}
+	for _, customer := range customers {
+		index.add(customer)
+		byId[customer.Id] = customer
+	}
+	scores, fields, err := index.search(query)
+	if err != nil {
+		return nil, err
+	}
+	matched := make(Customers, 0, len(scores))
+	for id := range scores {
+		matched = append(matched, byId[id])
+	}
+	return rankResults(query, matched, scores, fields, limit), nil
+}
+
+// Search finds the customers matching every word of the query, as a word
+// of their name, role or email, or digits of their phone number, or the
+// start of one. Results are ranked by relevance, at most limit of them
+// (all if limit is 0) being returned.
+func (t *CustomerTable) Search(query string, limit int) ([]SearchResult, error) {
+	t.mu.RLock()
+	index := t.search
+	if index == nil {
+		index = newSearchIndex()
+	}
+	scores, fields, err := index.search(query)
+	var matched Customers
+	if err == nil {
+		matched = make(Customers, 0, len(scores))
+		for id := range scores {
+			matched = append(matched, t.customers[id])
+		}
+	}
+	t.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	return rankResults(query, matched, scores, fields, limit), nil
+}
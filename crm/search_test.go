@@ -0,0 +1,193 @@
+package crm
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func searchIds(t *testing.T, searcher Searcher, query string, limit int) ([]int64, []SearchResult) {
+	results, err := searcher.Search(query, limit)
+	if err != nil {
+		t.Fatalf("Search(%q): %v", query, err)
+	}
+	ids := make([]int64, 0, len(results))
+	for _, result := range results {
+		ids = append(ids, result.Customer.Id)
+	}
+	return ids, results
+}
+
+func TestSearch(t *testing.T) {
+	customerTable := ReadCustomers(t)
+
+	tests := []struct {
+		query    string
+		expected []int64
+	}{
+		{"dayrep", []int64{2, 5, 17}},           // email domain, equally ranked so in id order
+		{"charl", []int64{7, 15}},               // prefix, the closer match first
+		{"CHARLES rhyta", []int64{15}},          // every word must match
+		{"lamond", []int64{15}},                 // name and email local part
+		{"0404", []int64{11}},                   // phone digits
+		{"61353820404", []int64{11}},            // E.164 digits
+		{"teleworm au", []int64{3, 8}},          // domain labels
+		{"student jett", []int64{19}},           // role
+		{"nobody", []int64{}},                   // no match
+		{"charli lamond", []int64{}},            // words matching different customers
+		{"Bianca, Bruxner!", []int64{5}},        // punctuation is ignored
+		{"Biança", []int64{5}},                  // accents are ignored
+		{"evie.allan@jourrapide", []int64{10}},  // the whole email
+		{"jourrapide", []int64{10, 11}},         // domain
+		{"armyspy com", []int64{9, 12, 18, 19}}, // both domain labels
+	}
+	for _, test := range tests {
+		ids, _ := searchIds(t, customerTable, test.query, 0)
+		if !equalIds(ids, test.expected) {
+			t.Errorf("Search(%q) found %v, expected %v", test.query, ids, test.expected)
+		}
+	}
+
+	if ids, _ := searchIds(t, customerTable, "dayrep", 2); !equalIds(ids, []int64{2, 5}) {
+		t.Errorf("limit 2 found %v", ids)
+	}
+	for _, query := range []string{"", "  ", "-@."} {
+		if _, err := customerTable.Search(query, 0); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("Search(%q) returned %v, expected ErrInvalidQuery", query, err)
+		}
+	}
+}
+
+func TestSearchRanking(t *testing.T) {
+	customerTable := ReadCustomers(t)
+	_, results := searchIds(t, customerTable, "charl", 0)
+	if results[0].Score <= results[1].Score {
+		t.Errorf("expected descending scores, got %v", results)
+	}
+	// a match on the name outranks one on the email
+	customerTable.NewCustomer("Rhyta Jones", "student", "", "")
+	ids, _ := searchIds(t, customerTable, "rhyta", 0)
+	if !equalIds(ids, []int64{20, 7, 15}) {
+		t.Errorf("expected the name match first, found %v", ids)
+	}
+}
+
+func TestSearchHighlights(t *testing.T) {
+	customerTable := ReadCustomers(t)
+	customerTable.NewCustomer("Zoë Ångström", "Manager", "zoe.angstrom@example.com", "0412 345 678")
+	customerTable.NewCustomer("Mallory <img src=x onerror=alert(1)>", "Tester & Co", "", "")
+
+	tests := []struct {
+		query    string
+		id       int64
+		expected map[string]string
+	}{
+		{"charli ang", 7, map[string]string{"name": "<em>Charli</em> <em>Ang</em>les", "email": "<em>ang</em>les@rhyta.com"}},
+		{"lamond", 15, map[string]string{"name": "Charles <em>Lamond</em>", "email": "<em>lamond</em>@rhyta.com"}},
+		{"5382 0404", 11, map[string]string{"phone": "(03) <em>5382</em> <em>0404</em>"}},
+		{"820", 11, map[string]string{"phone": "(03) 53<em>82 0</em>404"}},
+		{"zoe angs", 20, map[string]string{
+			"name":  "<em>Zoë</em> <em>Ångs</em>tröm",
+			"email": "<em>zoe</em>.<em>angs</em>trom@example.com",
+		}},
+		{"345678 manager", 20, map[string]string{"phone": "0412 <em>345 678</em>", "role": "<em>Manager</em>"}},
+		// the customer's text is escaped, around and within the markers
+		{"mallory img tester", 21, map[string]string{
+			"name": "<em>Mallory</em> &lt;<em>img</em> src=x onerror=alert(1)&gt;",
+			"role": "<em>Tester</em> &amp; Co",
+		}},
+	}
+	for _, test := range tests {
+		_, results := searchIds(t, customerTable, test.query, 0)
+		if len(results) != 1 || results[0].Customer.Id != test.id {
+			t.Errorf("Search(%q) returned %v, expected customer %d", test.query, results, test.id)
+			continue
+		}
+		highlights := results[0].Highlights
+		if len(highlights) != len(test.expected) {
+			t.Errorf("Search(%q) highlighted %v, expected %v", test.query, highlights, test.expected)
+		}
+		for field, expected := range test.expected {
+			if highlights[field] != expected {
+				t.Errorf("Search(%q) highlighted %s as %q, expected %q", test.query, field, highlights[field], expected)
+			}
+		}
+	}
+}
+
+func TestSearchIndexMaintained(t *testing.T) {
+	customerTable := ReadCustomers(t)
+
+	customer := customerTable.NewCustomer("Harriet Vane", "author", "hvane@example.com", "")
+	if ids, _ := searchIds(t, customerTable, "harriet", 0); !equalIds(ids, []int64{customer.Id}) {
+		t.Errorf("new customer not found, found %v", ids)
+	}
+	if _, err := customerTable.UpdateCustomerById(customer.Id, &Customer{Name: "Harriet Wimsey"}); err != nil {
+		t.Fatalf("UpdateCustomerById: %v", err)
+	}
+	if ids, _ := searchIds(t, customerTable, "hvane", 0); !equalIds(ids, []int64{customer.Id}) {
+		t.Errorf("expected the email still to match, found %v", ids)
+	}
+	if ids, _ := searchIds(t, customerTable, "harriet wimsey", 0); !equalIds(ids, []int64{customer.Id}) {
+		t.Errorf("updated name not found, found %v", ids)
+	}
+	if _, err := customerTable.DeleteCustomerById(customer.Id); err != nil {
+		t.Fatalf("DeleteCustomerById: %v", err)
+	}
+	if ids, _ := searchIds(t, customerTable, "harriet", 0); len(ids) != 0 {
+		t.Errorf("deleted customer found: %v", ids)
+	}
+	if len(customerTable.search.postings["harriet"]) != 0 || len(customerTable.search.sortedTerms()) != len(customerTable.search.postings) {
+		t.Errorf("index not cleaned up after delete")
+	}
+
+	// reloading rebuilds the index
+	if err := customerTable.ReadCustomerData(DATAFILE); err != nil {
+		t.Fatalf("ReadCustomerData: %v", err)
+	}
+	if ids, _ := searchIds(t, customerTable, "wimsey", 0); len(ids) != 0 {
+		t.Errorf("stale customer found after reload: %v", ids)
+	}
+}
+
+func TestSearchCustomers(t *testing.T) {
+	customerTable := ReadCustomers(t)
+	customers, _ := customerTable.List()
+	for _, query := range []string{"dayrep", "charl", "0404", "armyspy com"} {
+		expected, _ := customerTable.Search(query, 0)
+		results, err := SearchCustomers(customers, query, 0)
+		if err != nil {
+			t.Fatalf("SearchCustomers(%q): %v", query, err)
+		}
+		if len(results) != len(expected) {
+			t.Errorf("SearchCustomers(%q) returned %v, expected %v", query, results, expected)
+			continue
+		}
+		for index := range results {
+			if results[index].Customer != expected[index].Customer || results[index].Score != expected[index].Score {
+				t.Errorf("SearchCustomers(%q) result %d is %v, expected %v", query, index, results[index], expected[index])
+			}
+		}
+	}
+}
+
+func TestSearchIndexTerms(t *testing.T) {
+	index := newSearchIndex()
+	harriet := Customer{Id: 1, Name: "Harriet Vane"}
+	peter := Customer{Id: 2, Name: "Peter Wimsey"}
+	index.add(harriet)
+	index.add(peter)
+	if terms := index.sortedTerms(); strings.Join(terms, " ") != "harriet peter vane wimsey" {
+		t.Errorf("unexpected terms %q", terms)
+	}
+	// a term removed and added again before the next search appears once
+	index.remove(harriet)
+	index.add(Customer{Id: 3, Name: "Harriet Deborah"})
+	index.remove(peter)
+	if terms := index.sortedTerms(); strings.Join(terms, " ") != "deborah harriet" {
+		t.Errorf("unexpected terms %q", terms)
+	}
+	if scores, _ := index.match("harr"); len(scores) != 1 || scores[3] == 0 {
+		t.Errorf("unexpected matches %v", scores)
+	}
+}
@@ -0,0 +1,152 @@
+package crm
+
+import (
+	"strings"
+	"unicode"
+)
+
+// jaroWinkler returns the Jaro-Winkler similarity of two strings,
+// from 0 (nothing in common) to 1 (identical)
+func jaroWinkler(a, b string) float64 {
+	s1, s2 := []rune(a), []rune(b)
+	if len(s1) == 0 && len(s2) == 0 {
+		return 1
+	}
+	if len(s1) == 0 || len(s2) == 0 {
+		return 0
+	}
+	window := len(s1)
+	if len(s2) > window {
+		window = len(s2)
+	}
+	window = window/2 - 1
+	if window < 0 {
+		window = 0
+	}
+
+	matched1, matched2 := make([]bool, len(s1)), make([]bool, len(s2))
+	matches := 0
+	for i := range s1 {
+		start, end := i-window, i+window+1
+		if start < 0 {
+			start = 0
+		}
+		if end > len(s2) {
+			end = len(s2)
+		}
+		for j := start; j < end; j++ {
+			if !matched2[j] && s1[i] == s2[j] {
+				matched1[i], matched2[j] = true, true
+				matches++
+				break
+			}
+		}
+	}
+	if matches == 0 {
+		return 0
+	}
+
+	transpositions, j := 0, 0
+	for i := range s1 {
+		if !matched1[i] {
+			continue
+		}
+		for !matched2[j] {
+			j++
+		}
+		if s1[i] != s2[j] {
+			transpositions++
+		}
+		j++
+	}
+	m := float64(matches)
+	jaro := (m/float64(len(s1)) + m/float64(len(s2)) + (m-float64(transpositions)/2)/m) / 3
+
+	// boost strings sharing a common prefix of up to 4 characters
+	prefix := 0
+	for prefix < 4 && prefix < len(s1) && prefix < len(s2) && s1[prefix] == s2[prefix] {
+		prefix++
+	}
+	return jaro + float64(prefix)*0.1*(1-jaro)
+}
+
+// soundex returns the American Soundex code of a word, e.g. "R163" for Robert,
+// so that names which sound alike (Allan, Allen) have the same code
+func soundex(word string) string {
+	codes := map[rune]byte{
+		'b': '1', 'f': '1', 'p': '1', 'v': '1',
+		'c': '2', 'g': '2', 'j': '2', 'k': '2', 'q': '2', 's': '2', 'x': '2', 'z': '2',
+		'd': '3', 't': '3',
+		'l': '4',
+		'm': '5', 'n': '5',
+		'r': '6',
+	}
+	code := make([]byte, 0, 4)
+	var last byte
+	for _, r := range strings.ToLower(word) {
+		if r < 'a' || r > 'z' {
+			continue
+		}
+		digit := codes[r]
+		if len(code) == 0 {
+			code = append(code, byte(unicode.ToUpper(r)))
+		} else if digit != 0 && digit != last {
+			code = append(code, digit)
+			if len(code) == 4 {
+				break
+			}
+		}
+		// h and w do not separate letters with the same code, vowels do
+		if r != 'h' && r != 'w' {
+			last = digit
+		}
+	}
+	if len(code) == 0 {
+		return ""
+	}
+	for len(code) < 4 {
+		code = append(code, '0')
+	}
+	return string(code)
+}
+
+// nameTokens splits a name into lower case words, dropping punctuation
+// so that "B. Bruxner" gives [b bruxner]
+func nameTokens(name string) []string {
+	return strings.FieldsFunc(strings.ToLower(foldAccents(name)), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+}
+
+// nameSimilarity scores how likely two names are to belong to the same person,
+// from 0 to 1. Where both have a given name and surname, the surnames are
+// compared by spelling and sound, and the given names allowing for initials.
+func nameSimilarity(a, b string) float64 {
+	tokensA, tokensB := nameTokens(a), nameTokens(b)
+	if len(tokensA) == 0 || len(tokensB) == 0 {
+		return 0
+	}
+	whole := jaroWinkler(strings.Join(tokensA, " "), strings.Join(tokensB, " "))
+	if len(tokensA) < 2 || len(tokensB) < 2 {
+		return whole
+	}
+
+	surnameA, surnameB := tokensA[len(tokensA)-1], tokensB[len(tokensB)-1]
+	surname := jaroWinkler(surnameA, surnameB)
+	if surname < 0.9 && soundex(surnameA) == soundex(surnameB) {
+		surname = 0.9
+	}
+	givenA, givenB := []rune(tokensA[0]), []rune(tokensB[0])
+	var given float64
+	if len(givenA) == 1 || len(givenB) == 1 {
+		if givenA[0] == givenB[0] {
+			given = 0.9
+		}
+	} else {
+		given = jaroWinkler(string(givenA), string(givenB))
+	}
+	if score := 0.6*surname + 0.4*given; score > whole {
+		return score
+	}
+	return whole
+}
@@ -0,0 +1,66 @@
+package crm
+
+import (
+	"math"
+	"testing"
+)
+
+func TestJaroWinkler(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected float64
+	}{
+		{"martha", "marhta", 0.961},
+		{"dwayne", "duane", 0.84},
+		{"dixon", "dicksonx", 0.813},
+		{"bruxner", "bruxner", 1},
+		{"", "", 1},
+		{"abc", "", 0},
+		{"abc", "xyz", 0},
+	}
+	for _, test := range tests {
+		if score := jaroWinkler(test.a, test.b); math.Abs(score-test.expected) > 0.001 {
+			t.Errorf("jaroWinkler(%q, %q) = %.3f, expected %.3f", test.a, test.b, score, test.expected)
+		}
+	}
+}
+
+func TestSoundex(t *testing.T) {
+	tests := map[string]string{
+		"Robert":   "R163",
+		"Rupert":   "R163",
+		"Ashcraft": "A261",
+		"Tymczak":  "T522",
+		"Pfister":  "P236",
+		"Allan":    "A450",
+		"Allen":    "A450",
+		"Lee":      "L000",
+		"":         "",
+	}
+	for word, expected := range tests {
+		if code := soundex(word); code != expected {
+			t.Errorf("soundex(%q) = %q, expected %q", word, code, expected)
+		}
+	}
+}
+
+func TestNameSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b    string
+		similar bool
+	}{
+		{"Bianca Bruxner", "B. Bruxner", true},
+		{"Bianca Bruxner", "bianca  BRUXNER", true},
+		{"Evie Allan", "Evie Allen", true},
+		{"Zoë Sodeman", "Zoe Sodeman", true},
+		{"Bianca Bruxner", "C. Bruxner", false},
+		{"Bianca Bruxner", "Bianca Hindwood", false},
+		{"Tyson Danks", "Savannah Stout", false},
+		{"Bianca Bruxner", "", false},
+	}
+	for _, test := range tests {
+		if score := nameSimilarity(test.a, test.b); (score >= DefaultDuplicateThreshold) != test.similar {
+			t.Errorf("nameSimilarity(%q, %q) = %.3f, expected similar to be %v", test.a, test.b, score, test.similar)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package crm
+
+// CustomerStore is the storage abstraction used by the api handlers.
+// CustomerTable provides the default in-memory implementation; any other
+// implementation (persistent, remote, instrumented...) may be substituted.
+type CustomerStore interface {
+	// Get returns the customer with the given id or an error if it does not exist
+	Get(id int64) (*Customer, error)
+	// List returns all customers
+	List() (Customers, error)
+	// Create adds a new customer, assigning its id, and returns the stored record
+	Create(c *Customer) (*Customer, error)
+	// Update replaces an existing customer in full and returns the stored record.
+	// If c.Version is non-zero the stored customer must be at that version,
+	// otherwise ErrVersionMismatch is returned; the store assigns the new version.
+	Update(id int64, c *Customer) (*Customer, error)
+	// Delete removes a customer and returns the deleted record.
+	// If version is non-zero the stored customer must be at that version.
+	Delete(id int64, version int64) (*Customer, error)
+	// Count returns the number of customers held by the store
+	Count() int
+}
+
+// CustomerLoader is implemented by stores that can be bulk loaded from a data file
+type CustomerLoader interface {
+	ReadCustomerData(filename string) error
+}
+
+// PhoneFinder is implemented by stores that can look customers up by phone number
+// written in any format, matching on the canonical E.164 form
+type PhoneFinder interface {
+	FindByPhone(phone string) Customers
+}
+
+// UniqueEmailEnforcer is implemented by stores that can require customer emails to be unique
+type UniqueEmailEnforcer interface {
+	SetUniqueEmail(unique bool) error
+}
+
+// RegionSetter is implemented by stores that read phone numbers written in
+// national format in a region that can be set
+type RegionSetter interface {
+	SetRegion(region string) error
+}
+
+// DuplicateFinder is implemented by stores that can find likely duplicate customers
+type DuplicateFinder interface {
+	FindDuplicates(threshold float64) []DuplicateMatch
+}
+
+// Merger is implemented by stores that can merge one customer into another,
+// recording each merge
+type Merger interface {
+	Merge(targetId, sourceId int64, policy MergePolicy, version int64) (*Customer, *MergeRecord, error)
+	Merges(id int64) []MergeRecord
+}
+
+// CustomerTable implements CustomerStore
+
+var _ CustomerStore = (*CustomerTable)(nil)
+var _ CustomerLoader = (*CustomerTable)(nil)
+var _ PhoneFinder = (*CustomerTable)(nil)
+var _ UniqueEmailEnforcer = (*CustomerTable)(nil)
+var _ RegionSetter = (*CustomerTable)(nil)
+var _ DuplicateFinder = (*CustomerTable)(nil)
+var _ Merger = (*CustomerTable)(nil)
+var _ Querier = (*CustomerTable)(nil)
+var _ IdQuerier = (*CustomerTable)(nil)
+var _ Searcher = (*CustomerTable)(nil)
+var _ Suggester = (*CustomerTable)(nil)
+
+func (t *CustomerTable) Get(id int64) (*Customer, error) {
+	if customer := t.GetCustomerById(id); customer != nil {
+		return customer, nil
+	}
+	return nil, notFound(id)
+}
+
+func (t *CustomerTable) List() (Customers, error) {
+	return *t.GetAllCustomers(), nil
+}
+
+func (t *CustomerTable) Create(c *Customer) (*Customer, error) {
+	customer, err := t.insert(*c)
+	if err != nil {
+		return nil, err
+	}
+	return &customer, nil
+}
+
+func (t *CustomerTable) Update(id int64, c *Customer) (*Customer, error) {
+	return t.ReplaceCustomerById(id, c)
+}
+
+func (t *CustomerTable) Delete(id int64, version int64) (*Customer, error) {
+	return t.DeleteCustomerVersion(id, version)
+}
@@ -0,0 +1,47 @@
+package crm
+
+import "testing"
+
+func TestCustomerTableStore(t *testing.T) {
+	var store CustomerStore = ReadCustomers(t)
+
+	if count := store.Count(); count != 14 {
+		t.Errorf("store count is %d, expected 14", count)
+	}
+	if all, err := store.List(); err != nil {
+		t.Errorf("List: %v", err)
+	} else if len(all) != 14 {
+		t.Errorf("List returned %d customers, expected 14", len(all))
+	}
+
+	created, err := store.Create(&Customer{Name: "Peter Rabbit", Role: "teacher", Email: "pr@bunbun.com.au"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	} else if created.Id != 20 {
+		t.Errorf("created customer id is %d, expected 20", created.Id)
+	}
+
+	if customer, err := store.Get(created.Id); err != nil {
+		t.Errorf("Get: %v", err)
+	} else if customer.Name != "Peter Rabbit" {
+		t.Errorf("customer name is %s, expected Peter Rabbit", customer.Name)
+	}
+
+	// update replaces the record in full
+	if customer, err := store.Update(created.Id, &Customer{Name: "Peter Rabbit", Role: "student"}); err != nil {
+		t.Errorf("Update: %v", err)
+	} else if customer.Role != "student" {
+		t.Errorf("customer role is %s, expected student", customer.Role)
+	} else if customer.Email != "" {
+		t.Errorf("customer email is %s, expected it to be cleared", customer.Email)
+	} else if customer.Id != created.Id {
+		t.Errorf("customer id is %d, expected %d", customer.Id, created.Id)
+	}
+
+	if _, err := store.Delete(created.Id, 0); err != nil {
+		t.Errorf("Delete: %v", err)
+	}
+	if _, err := store.Get(created.Id); err == nil {
+		t.Errorf("Get returned deleted customer %d", created.Id)
+	}
+}
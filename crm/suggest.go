@@ -0,0 +1,379 @@
+package crm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// DefaultSuggestLimit is the number of suggestions returned unless asked otherwise
+const DefaultSuggestLimit = 10
+
+// Suggestion is a customer whose name or email starts with the text typed so far
+type Suggestion struct {
+	Id   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+// Suggester is implemented by stores that maintain their own index of suggestions
+type Suggester interface {
+	Suggest(prefix string, limit int) ([]Suggestion, error)
+}
+
+// how a suggestion matched, best first
+type suggestMatch uint8
+
+const (
+	suggestName  suggestMatch = iota // the start of the name
+	suggestWord                      // the start of a later word of the name
+	suggestEmail                     // the start of the email
+)
+
+// suggestKey returns the form in which names, emails and prefixes are compared:
+// folded to lower case without accents, and with runs of spaces collapsed
+func suggestKey(s string) string {
+	return strings.Join(strings.Fields(foldText(s)), " ")
+}
+
+// suggestKeys returns the keys under which a customer is suggested: the whole
+// name and from the start of each of its later words, and the email
+func suggestKeys(c Customer) map[string]suggestMatch {
+	keys := map[string]suggestMatch{}
+	add := func(key string, match suggestMatch) {
+		if current, ok := keys[key]; key != "" && (!ok || match < current) {
+			keys[key] = match
+		}
+	}
+	name := suggestKey(c.Name)
+	add(name, suggestName)
+	previous := ' '
+	for index, r := range name {
+		if index > 0 && isWordRune(r) && !isWordRune(previous) {
+			add(name[index:], suggestWord)
+		}
+		previous = r
+	}
+	add(suggestKey(c.Email), suggestEmail)
+	return keys
+}
+
+// suggestRanked is the number of best suggestions cached at each node of the trie,
+// so that asking for no more than this many does not walk the node's subtree
+const suggestRanked = 100
+
+// suggestEntry is a customer suggested by a key, with its name as compared when ranking
+type suggestEntry struct {
+	id    int64
+	match suggestMatch
+	name  string
+}
+
+// before reports whether an entry ranks ahead of another: by how it matched,
+// then by name and id
+func (e suggestEntry) before(other suggestEntry) bool {
+	if e.match != other.match {
+		return e.match < other.match
+	}
+	if e.name != other.name {
+		return e.name < other.name
+	}
+	return e.id < other.id
+}
+
+func sortEntries(entries []suggestEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].before(entries[j])
+	})
+}
+
+// suggestTrie is a prefix tree of the suggestion keys of customers. Nodes with
+// more entries below them than suggestRanked keep the best of those, updated as
+// customers are added; removing one of them leaves the node to rank again when
+// next asked, which readers may do, so mu guards the rankings.
+type suggestTrie struct {
+	mu   sync.Mutex
+	root *suggestNode
+}
+
+// suggestNode is reached from its parent by a rune, and holds the customers whose
+// keys end there, the number of entries at and below it, and, if that is more
+// than suggestRanked, the best of them (nil when a removal has made them stale).
+// Most nodes have a single child and entry, so these are held in slices.
+type suggestNode struct {
+	r        rune
+	children []*suggestNode
+	ids      []suggestEntry
+	size     int
+	top      []suggestEntry
+}
+
+// child returns the child reached by a rune, or nil
+func (n *suggestNode) child(r rune) *suggestNode {
+	for _, child := range n.children {
+		if child.r == r {
+			return child
+		}
+	}
+	return nil
+}
+
+func newSuggestTrie() *suggestTrie {
+	return &suggestTrie{root: &suggestNode{}}
+}
+
+func (t *suggestTrie) add(c Customer) {
+	name := suggestKey(c.Name)
+	var path []*suggestNode
+	for key, match := range suggestKeys(c) {
+		entry := suggestEntry{id: c.Id, match: match, name: name}
+		path = append(path[:0], t.root)
+		node := t.root
+		for _, r := range key {
+			child := node.child(r)
+			if child == nil {
+				child = &suggestNode{r: r}
+				node.children = append(node.children, child)
+			}
+			node = child
+			path = append(path, node)
+		}
+		node.ids = append(node.ids, entry)
+		// from the bottom up, so that a node ranked for the first time can use its children's rankings
+		for index := len(path) - 1; index >= 0; index-- {
+			node = path[index]
+			node.size++
+			if node.top != nil {
+				node.top = insertEntry(node.top, entry)
+			} else if node.size == suggestRanked+1 {
+				node.top = node.rank()
+			}
+		}
+	}
+}
+
+// insertEntry adds an entry to a ranking, in order, keeping the best suggestRanked
+func insertEntry(top []suggestEntry, entry suggestEntry) []suggestEntry {
+	if len(top) == suggestRanked && !entry.before(top[len(top)-1]) {
+		// not among the best, nor better than an entry of the same customer
+		return top
+	}
+	for index, existing := range top {
+		if existing.id == entry.id {
+			if !entry.before(existing) {
+				return top
+			}
+			top = append(top[:index], top[index+1:]...)
+			break
+		}
+	}
+	index := sort.Search(len(top), func(i int) bool {
+		return entry.before(top[i])
+	})
+	if index >= suggestRanked {
+		return top
+	}
+	top = append(top, suggestEntry{})
+	copy(top[index+1:], top[index:])
+	top[index] = entry
+	if len(top) > suggestRanked {
+		top = top[:suggestRanked]
+	}
+	return top
+}
+
+func (t *suggestTrie) remove(c Customer) {
+	for key := range suggestKeys(c) {
+		t.root.removeKey(key, c.Id)
+	}
+}
+
+// removeKey removes an id from the node reached by key, pruning nodes left
+// empty. It returns whether the id was found there.
+func (n *suggestNode) removeKey(key string, id int64) bool {
+	if key == "" {
+		found := false
+		for index, entry := range n.ids {
+			if entry.id == id {
+				n.ids, found = append(n.ids[:index], n.ids[index+1:]...), true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	} else {
+		r, size := utf8.DecodeRuneInString(key)
+		child := n.child(r)
+		if child == nil || !child.removeKey(key[size:], id) {
+			return false
+		}
+		if child.size == 0 {
+			for index := range n.children {
+				if n.children[index] == child {
+					n.children = append(n.children[:index], n.children[index+1:]...)
+					break
+				}
+			}
+		}
+	}
+	n.size--
+	if n.size <= suggestRanked {
+		n.top = nil
+	}
+	for _, entry := range n.top {
+		if entry.id == id {
+			// another customer may now be among the best
+			n.top = nil
+			break
+		}
+	}
+	return true
+}
+
+// find returns the node reached by a prefix, or nil if no key starts with it
+func (n *suggestNode) find(prefix string) *suggestNode {
+	node := n
+	for _, r := range prefix {
+		if node = node.child(r); node == nil {
+			return nil
+		}
+	}
+	return node
+}
+
+// collect adds the customers with keys below this node to matches, keeping the best match of each
+func (n *suggestNode) collect(matches map[int64]suggestEntry) {
+	for _, entry := range n.ids {
+		if current, ok := matches[entry.id]; !ok || entry.match < current.match {
+			matches[entry.id] = entry
+		}
+	}
+	for _, child := range n.children {
+		child.collect(matches)
+	}
+}
+
+// ranked returns the entries in matches in order, at most limit (all if 0) of them
+func ranked(matches map[int64]suggestEntry, limit int) []suggestEntry {
+	entries := make([]suggestEntry, 0, len(matches))
+	for _, entry := range matches {
+		entries = append(entries, entry)
+	}
+	sortEntries(entries)
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries
+}
+
+// rank returns the best suggestRanked entries below this node, in order. A node
+// with few entries below collects them, while the ranking of one with more is
+// made from its own entries and the rankings of its children, and kept: a
+// customer not among the best of the child where it matched best cannot be
+// among the best here.
+func (n *suggestNode) rank() []suggestEntry {
+	if n.top != nil {
+		return n.top
+	}
+	matches := make(map[int64]suggestEntry, len(n.ids))
+	if n.size <= suggestRanked {
+		n.collect(matches)
+		return ranked(matches, 0)
+	}
+	for _, entry := range n.ids {
+		matches[entry.id] = entry
+	}
+	for _, child := range n.children {
+		for _, entry := range child.rank() {
+			if current, ok := matches[entry.id]; !ok || entry.match < current.match {
+				matches[entry.id] = entry
+			}
+		}
+	}
+	n.top = ranked(matches, suggestRanked)
+	return n.top
+}
+
+// suggest returns the best entries of customers having a key starting with the
+// prefix, at most limit (all if 0) of them. Up to suggestRanked come from the
+// rankings, while more require all those matching to be collected.
+func (t *suggestTrie) suggest(prefix string, limit int) ([]suggestEntry, error) {
+	key := suggestKey(prefix)
+	if key == "" {
+		return nil, fmt.Errorf("%w: prefix is required", ErrInvalidQuery)
+	}
+	// a trailing space means the word typed is complete
+	if strings.HasSuffix(foldText(prefix), " ") {
+		key += " "
+	}
+	node := t.root.find(key)
+	if node == nil {
+		return nil, nil
+	}
+	if limit > 0 && limit <= suggestRanked {
+		t.mu.Lock()
+		entries := node.rank()
+		t.mu.Unlock()
+		if len(entries) > limit {
+			entries = entries[:limit]
+		}
+		return entries, nil
+	}
+	matches := map[int64]suggestEntry{}
+	node.collect(matches)
+	return ranked(matches, limit), nil
+}
+
+// suggestions returns the suggestions for entries, found by id. The display
+// name is the customer's name, or their email if they have no name.
+func suggestions(entries []suggestEntry, customer func(id int64) Customer) []Suggestion {
+	suggestions := make([]Suggestion, 0, len(entries))
+	for _, entry := range entries {
+		c := customer(entry.id)
+		name := c.Name
+		if name == "" {
+			name = c.Email
+		}
+		suggestions = append(suggestions, Suggestion{Id: c.Id, Name: name})
+	}
+	return suggestions
+}
+
+// SuggestCustomers suggests customers from a list, indexing them first.
+// Stores that maintain an index of suggestions implement Suggester instead.
+func SuggestCustomers(customers Customers, prefix string, limit int) ([]Suggestion, error) {
+	trie := newSuggestTrie()
+	byId := map[int64]Customer{}
+	for _, customer := range customers {
+		trie.add(customer)
+		byId[customer.Id] = customer
+	}
+	entries, err := trie.suggest(prefix, limit)
+	if err != nil {
+		return nil, err
+	}
+	return suggestions(entries, func(id int64) Customer {
+		return byId[id]
+	}), nil
+}
+
+// Suggest returns the customers whose name, a word of their name, or email
+// starts with the prefix, ignoring case and accents: those whose name starts
+// with it first, then by name. At most limit (all if 0) are returned.
+func (t *CustomerTable) Suggest(prefix string, limit int) ([]Suggestion, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	trie := t.suggest
+	if trie == nil {
+		trie = newSuggestTrie()
+	}
+	entries, err := trie.suggest(prefix, limit)
+	if err != nil {
+		return nil, err
+	}
+	return suggestions(entries, func(id int64) Customer {
+		return t.customers[id]
+	}), nil
+}
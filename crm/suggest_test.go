@@ -0,0 +1,177 @@
+package crm
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func suggestIds(t *testing.T, suggester Suggester, prefix string, limit int) []int64 {
+	suggestions, err := suggester.Suggest(prefix, limit)
+	if err != nil {
+		t.Fatalf("Suggest(%q): %v", prefix, err)
+	}
+	ids := make([]int64, 0, len(suggestions))
+	for _, suggestion := range suggestions {
+		ids = append(ids, suggestion.Id)
+	}
+	return ids
+}
+
+func TestSuggest(t *testing.T) {
+	customerTable := ReadCustomers(t)
+	customerTable.NewCustomer("Zoë Ångström", "manager", "", "")
+
+	tests := []struct {
+		prefix   string
+		expected []int64
+	}{
+		{"b", []int64{5}},
+		{"s", []int64{18, 2, 11, 17}}, // names first, then later words of names
+		{"char", []int64{15, 7}},      // in name order
+		{"CHARLI", []int64{7}},        // ignoring case
+		{"charles ", []int64{15}},     // a completed word
+		{"bianca  b", []int64{5}},     // spaces collapsed
+		{"bianca x", []int64{}},       // no match
+		{"ang", []int64{7, 20}},       // later words, ignoring accents
+		{"ÅNGSTRÖM", []int64{20}},     // accents in the prefix
+		{"zoë å", []int64{20}},        // across words
+		{"evie.allan@", []int64{10}},  // emails
+		{"js3", []int64{17}},          // emails, even when the name does not match
+		{"x", []int64{}},              // nothing
+	}
+	for _, test := range tests {
+		if ids := suggestIds(t, customerTable, test.prefix, 0); !equalIds(ids, test.expected) {
+			t.Errorf("Suggest(%q) found %v, expected %v", test.prefix, ids, test.expected)
+		}
+	}
+
+	if ids := suggestIds(t, customerTable, "s", 2); !equalIds(ids, []int64{18, 2}) {
+		t.Errorf("limit 2 found %v", ids)
+	}
+	suggestions, _ := customerTable.Suggest("zo", 0)
+	if len(suggestions) != 1 || suggestions[0] != (Suggestion{Id: 20, Name: "Zoë Ångström"}) {
+		t.Errorf("unexpected suggestions %v", suggestions)
+	}
+	for _, prefix := range []string{"", "   "} {
+		if _, err := customerTable.Suggest(prefix, 0); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("Suggest(%q) returned %v, expected ErrInvalidQuery", prefix, err)
+		}
+	}
+}
+
+func TestSuggestMaintained(t *testing.T) {
+	customerTable := &CustomerTable{}
+	customer := customerTable.NewCustomer("", "author", "hvane@example.com", "")
+	suggestions, _ := customerTable.Suggest("hv", 0)
+	if len(suggestions) != 1 || suggestions[0].Name != "hvane@example.com" {
+		t.Errorf("expected the email for a customer without a name, got %v", suggestions)
+	}
+	if _, err := customerTable.UpdateCustomerById(customer.Id, &Customer{Name: "Harriet Vane"}); err != nil {
+		t.Fatalf("UpdateCustomerById: %v", err)
+	}
+	if ids := suggestIds(t, customerTable, "va", 0); !equalIds(ids, []int64{customer.Id}) {
+		t.Errorf("updated name not suggested, found %v", ids)
+	}
+	if _, err := customerTable.ReplaceCustomerById(customer.Id, &Customer{Name: "Harriet Wimsey"}); err != nil {
+		t.Fatalf("ReplaceCustomerById: %v", err)
+	}
+	for _, prefix := range []string{"va", "hv"} {
+		if ids := suggestIds(t, customerTable, prefix, 0); len(ids) != 0 {
+			t.Errorf("Suggest(%q) found replaced values: %v", prefix, ids)
+		}
+	}
+	if _, err := customerTable.DeleteCustomerById(customer.Id); err != nil {
+		t.Fatalf("DeleteCustomerById: %v", err)
+	}
+	if ids := suggestIds(t, customerTable, "h", 0); len(ids) != 0 {
+		t.Errorf("deleted customer suggested: %v", ids)
+	}
+	if len(customerTable.suggest.root.children) != 0 {
+		t.Errorf("trie not pruned after delete: %v", customerTable.suggest.root.children)
+	}
+}
+
+func TestSuggestCustomers(t *testing.T) {
+	customerTable := ReadCustomers(t)
+	customers, _ := customerTable.List()
+	for _, prefix := range []string{"s", "char", "ang", "evie"} {
+		expected := suggestIds(t, customerTable, prefix, 0)
+		suggestions, err := SuggestCustomers(customers, prefix, 0)
+		if err != nil {
+			t.Fatalf("SuggestCustomers(%q): %v", prefix, err)
+		}
+		ids := make([]int64, 0, len(suggestions))
+		for _, suggestion := range suggestions {
+			ids = append(ids, suggestion.Id)
+		}
+		if !equalIds(ids, expected) {
+			t.Errorf("SuggestCustomers(%q) found %v, expected %v", prefix, ids, expected)
+		}
+	}
+}
+
+func TestSuggestRanked(t *testing.T) {
+	customerTable := benchmarkTable(300)
+	customerTable.NewCustomer("Cuthbert", "student", "", "")
+	prefixes := []string{"c", "cu", "customer 1", "customer 29", "5", "customer1", "x"}
+	check := func() {
+		t.Helper()
+		for _, prefix := range prefixes {
+			all := suggestIds(t, customerTable, prefix, 0)
+			for _, limit := range []int{1, DefaultSuggestLimit, suggestRanked} {
+				expected := all
+				if len(expected) > limit {
+					expected = expected[:limit]
+				}
+				if ids := suggestIds(t, customerTable, prefix, limit); !equalIds(ids, expected) {
+					t.Errorf("Suggest(%q, %d) found %v, expected %v", prefix, limit, ids, expected)
+				}
+			}
+		}
+	}
+	// the rankings are kept as customers are added, so are ready before the first suggestion
+	if top := customerTable.suggest.root.find("c").top; len(top) != suggestRanked {
+		t.Errorf("expected a ranking of %d, got %d", suggestRanked, len(top))
+	}
+	check()
+	// the rankings follow changes
+	if _, err := customerTable.UpdateCustomerById(301, &Customer{Name: "Customer 0"}); err != nil {
+		t.Fatalf("UpdateCustomerById: %v", err)
+	}
+	if _, err := customerTable.DeleteCustomerById(1); err != nil {
+		t.Fatalf("DeleteCustomerById: %v", err)
+	}
+	customerTable.NewCustomer("Aaron Customer", "student", "customer10@example.com", "")
+	check()
+	if ids := suggestIds(t, customerTable, "customer ", 2); !equalIds(ids, []int64{301, 2}) {
+		t.Errorf("unexpected suggestions %v", ids)
+	}
+}
+
+func BenchmarkSuggest(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		customerTable := benchmarkTable(size)
+		for _, prefix := range []string{"c", "cu", "customer 12"} {
+			b.Run(fmt.Sprintf("%d/%s", size, prefix), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					_, _ = customerTable.Suggest(prefix, DefaultSuggestLimit)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkSuggestAdd(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		trie := newSuggestTrie()
+		for i := 0; i < size; i++ {
+			trie.add(Customer{Id: int64(i + 1), Name: fmt.Sprintf("Customer %d", i), Email: fmt.Sprintf("customer%d@example.com", i)})
+		}
+		b.Run(fmt.Sprint(size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				trie.add(Customer{Id: int64(size + i + 1), Name: fmt.Sprintf("Peter Rabbit%d", i), Email: fmt.Sprintf("rabbit%d@bunbun.com.au", i)})
+			}
+		})
+	}
+}
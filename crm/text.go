@@ -0,0 +1,69 @@
+package crm
+
+import (
+	"strings"
+	"unicode"
+)
+
+// foldAccents replaces accented latin letters with their unaccented equivalents.
+// Each rune is replaced by exactly one rune, so positions are preserved.
+func foldAccents(s string) string {
+	return strings.Map(foldRune, s)
+}
+
+// foldText lower-cases a string and removes its accents, the form in which text is compared
+func foldText(s string) string {
+	return strings.Map(func(r rune) rune {
+		return unicode.ToLower(foldRune(r))
+	}, s)
+}
+
+func foldRune(r rune) rune {
+	if folded, ok := accentFolds[r]; ok {
+		return folded
+	}
+	return r
+}
+
+var accentFolds = func() map[rune]rune {
+	folds := map[rune]rune{}
+	for plain, accented := range map[rune]string{
+		'a': "àáâãäåā", 'A': "ÀÁÂÃÄÅĀ",
+		'c': "çćč", 'C': "ÇĆČ",
+		'e': "èéêëēėę", 'E': "ÈÉÊËĒĖĘ",
+		'i': "ìíîïī", 'I': "ÌÍÎÏĪ",
+		'n': "ñń", 'N': "ÑŃ",
+		'o': "òóôõöøō", 'O': "ÒÓÔÕÖØŌ",
+		's': "śš", 'S': "ŚŠ",
+		'u': "ùúûüū", 'U': "ÙÚÛÜŪ",
+		'y': "ýÿ", 'Y': "Ý",
+		'z': "źżž", 'Z': "ŹŻŽ",
+	} {
+		for _, r := range accented {
+			folds[r] = plain
+		}
+	}
+	return folds
+}()
+
+// isWordRune reports whether a rune forms part of a word (rather than separating words)
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// textTerms splits text into folded, lower case words of letters and digits
+func textTerms(s string) []string {
+	return strings.FieldsFunc(foldText(s), func(r rune) bool {
+		return !isWordRune(r)
+	})
+}
+
+// digitsOf returns just the digits of a string
+func digitsOf(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, s)
+}
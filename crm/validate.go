@@ -0,0 +1,107 @@
+package crm
+
+import (
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+)
+
+// Validator checks customer records before they are stored, normalizing them first.
+//
+// Names and roles have surrounding whitespace removed and internal runs of
+// whitespace collapsed; emails are trimmed and lower-cased; phone numbers are
+// parsed in Region (DefaultRegion if empty) to set PhoneE164. A name is required,
+// every field is limited in length, emails must be syntactically valid and
+// phone numbers must parse (see ParsePhone). In Lenient mode the email and phone
+// format checks are skipped, which allows legacy records (and test fixtures) to
+// be accepted.
+type Validator struct {
+	Lenient        bool
+	Region         string
+	MaxNameLength  int
+	MaxRoleLength  int
+	MaxEmailLength int
+	MaxPhoneLength int
+}
+
+var (
+	// DefaultValidator applies all checks
+	DefaultValidator = Validator{
+		MaxNameLength:  100,
+		MaxRoleLength:  50,
+		MaxEmailLength: 254,
+		MaxPhoneLength: 30,
+	}
+	// LenientValidator only checks required fields and lengths
+	LenientValidator = Validator{
+		Lenient:        true,
+		MaxNameLength:  DefaultValidator.MaxNameLength,
+		MaxRoleLength:  DefaultValidator.MaxRoleLength,
+		MaxEmailLength: DefaultValidator.MaxEmailLength,
+		MaxPhoneLength: DefaultValidator.MaxPhoneLength,
+	}
+)
+
+// Validate checks that a customer record is acceptable using the default validator
+func (c *Customer) Validate() error {
+	return DefaultValidator.Validate(c)
+}
+
+// Normalize tidies the fields of a customer in place
+func (v Validator) Normalize(c *Customer) {
+	c.Name = collapseSpace(c.Name)
+	c.Role = collapseSpace(c.Role)
+	c.Email = strings.ToLower(strings.TrimSpace(c.Email))
+	c.Phone = collapseSpace(c.Phone)
+	c.PhoneE164 = ""
+	if parsed, err := ParsePhone(c.Phone, v.Region); err == nil {
+		c.PhoneE164 = parsed.E164
+	}
+}
+
+// Validate normalizes a customer in place and checks that it is acceptable,
+// returning a ValidationError describing every problem found
+func (v Validator) Validate(c *Customer) error {
+	v.Normalize(c)
+	problems := &ValidationError{}
+
+	if c.Name == "" {
+		problems.Add("name", "is required")
+	}
+	checkLength(problems, "name", c.Name, v.MaxNameLength)
+	checkLength(problems, "role", c.Role, v.MaxRoleLength)
+	checkLength(problems, "email", c.Email, v.MaxEmailLength)
+	checkLength(problems, "phone", c.Phone, v.MaxPhoneLength)
+
+	if !v.Lenient {
+		if c.Email != "" && !validEmail(c.Email) {
+			problems.Add("email", "is not a valid email address")
+		}
+		if _, err := ParsePhone(c.Phone, v.Region); c.Phone != "" && err != nil {
+			problems.Add("phone", "is not a valid phone number")
+		}
+	}
+	return problems.Err()
+}
+
+func checkLength(problems *ValidationError, field, value string, max int) {
+	if max > 0 && utf8.RuneCountInString(value) > max {
+		problems.Add(field, "must be at most %d characters", max)
+	}
+}
+
+// collapseSpace trims a string and replaces internal runs of whitespace with a single space
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// validEmail checks for a bare address (no display name) with a qualified domain
+func validEmail(email string) bool {
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email || address.Name != "" {
+		return false
+	}
+	at := strings.LastIndexByte(email, '@')
+	domain := email[at+1:]
+	return strings.Contains(domain, ".") && !strings.HasPrefix(domain, ".") && !strings.HasSuffix(domain, ".")
+}
@@ -0,0 +1,100 @@
+package crm
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidatorNormalize(t *testing.T) {
+	customer := Customer{
+		Name:  "  Bianca \t Bruxner ",
+		Role:  " student ",
+		Email: " BBruxner@DayRep.com ",
+		Phone: " (07)  4938 5904",
+	}
+	if err := DefaultValidator.Validate(&customer); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	expected := Customer{Name: "Bianca Bruxner", Role: "student", Email: "bbruxner@dayrep.com", Phone: "(07) 4938 5904", PhoneE164: "+61749385904"}
+	if customer != expected {
+		t.Errorf("Normalize failed\n Expected: %v\n   Actual: %v", expected, customer)
+	}
+}
+
+func TestValidatorFieldErrors(t *testing.T) {
+	tests := []struct {
+		customer Customer
+		fields   []string
+	}{
+		{Customer{Name: "Bianca Bruxner", Email: "bbruxner@dayrep.com", Phone: "+61 7 4938 5904"}, nil},
+		{Customer{Name: "   "}, []string{"name"}},
+		{Customer{Name: "Example Name", Email: "Example Email"}, []string{"email"}},
+		{Customer{Name: "Example Name", Email: "Bianca <bbruxner@dayrep.com>"}, []string{"email"}},
+		{Customer{Name: "Example Name", Email: "bbruxner@localhost"}, []string{"email"}},
+		{Customer{Name: "Example Name", Phone: "call me"}, []string{"phone"}},
+		{Customer{Name: "Example Name", Phone: "123"}, []string{"phone"}},
+		{Customer{Name: "Example Name", Phone: "07 4938 5904 4938 5904"}, []string{"phone"}},
+		{Customer{Name: strings.Repeat("x", 101), Role: strings.Repeat("x", 51)}, []string{"name", "role"}},
+		{Customer{Email: "nobody", Phone: "?"}, []string{"name", "email", "phone"}},
+	}
+	for _, test := range tests {
+		customer := test.customer
+		err := DefaultValidator.Validate(&customer)
+		var validationError *ValidationError
+		if test.fields == nil {
+			if err != nil {
+				t.Errorf("Validate(%v): unexpected error %v", test.customer, err)
+			}
+			continue
+		} else if !errors.As(err, &validationError) {
+			t.Errorf("Validate(%v): expected a ValidationError, got %v", test.customer, err)
+			continue
+		}
+		var fields []string
+		for _, field := range validationError.Fields {
+			fields = append(fields, field.Field)
+		}
+		if strings.Join(fields, ",") != strings.Join(test.fields, ",") {
+			t.Errorf("Validate(%v): expected errors for %v, got %v", test.customer, test.fields, validationError.Fields)
+		}
+	}
+}
+
+func TestLenientValidator(t *testing.T) {
+	customer := Customer{Name: "Example Name", Role: "Example Role", Email: "Example Email", Phone: "5550199"}
+	if err := LenientValidator.Validate(&customer); err != nil {
+		t.Errorf("Validate: %v", err)
+	} else if customer.Email != "example email" {
+		t.Errorf("email was not normalized: %q", customer.Email)
+	}
+	if err := LenientValidator.Validate(&Customer{Email: "Example Email"}); err == nil {
+		t.Errorf("Validate accepted a customer without a name")
+	}
+}
+
+func TestValidatorRegion(t *testing.T) {
+	validator := DefaultValidator
+	validator.Region = "US"
+	customer := Customer{Name: "Bill Gates", Phone: "(555) 555 5555"}
+	if err := validator.Validate(&customer); err != nil {
+		t.Fatalf("Validate: %v", err)
+	} else if customer.PhoneE164 != "+15555555555" {
+		t.Errorf("phone_e164 is %q, expected +15555555555", customer.PhoneE164)
+	}
+
+	// numbers that cannot be parsed in the region are rejected, or when lenient
+	// kept without a canonical form
+	customer = Customer{Name: "Bill Gates", Phone: "(555) 555 5555"}
+	var problems *ValidationError
+	if err := DefaultValidator.Validate(&customer); !errors.As(err, &problems) || len(problems.Fields) != 1 ||
+		problems.Fields[0].Field != "phone" || problems.Fields[0].Message != "is not a valid phone number" {
+		t.Errorf("Validate: expected the phone to be rejected, got %v", err)
+	}
+	customer = Customer{Name: "Bill Gates", Phone: "(555) 555 5555", PhoneE164: "+15555555555"}
+	if err := LenientValidator.Validate(&customer); err != nil {
+		t.Fatalf("Validate: %v", err)
+	} else if customer.PhoneE164 != "" {
+		t.Errorf("phone_e164 is %q, expected it to be cleared", customer.PhoneE164)
+	}
+}
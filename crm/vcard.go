@@ -0,0 +1,456 @@
+package crm
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// vCard versions written: 4.0 (RFC 6350) by default, and 3.0 (RFC 2426) for
+// older address books
+const (
+	VCardVersion3 = "3.0"
+	VCardVersion4 = "4.0"
+)
+
+// Extended vCard properties holding the customer fields that have no standard property
+const (
+	vcardId        = "X-CRM-ID"
+	vcardContacted = "X-CRM-CONTACTED"
+)
+
+// vcardMaxLine is the length in octets at which lines are folded
+const vcardMaxLine = 75
+
+// ToVCard writes the customer as a vCard 4.0
+func (c *Customer) ToVCard() (string, error) {
+	buffer := bytes.NewBuffer(nil)
+	err := Projection(nil).writeVCard(buffer, c, VCardVersion4)
+	return buffer.String(), err
+}
+
+// FromVCard reads the customer from the first card of a vCard 3.0 or 4.0,
+// mapping FN (or N) to the name, TITLE (or ROLE) to the role, and the
+// preferred EMAIL and TEL to the email and phone
+func (c *Customer) FromVCard(data []byte) error {
+	decoder, err := NewVCardDecoder(bytes.NewReader(data))
+	if err == nil {
+		var customer *Customer
+		if customer, err = decoder.Decode(); err == nil {
+			*c = *customer
+		}
+	}
+	return err
+}
+
+// ToVCard writes the customers as vCard 4.0 cards, one after another
+func (C *Customers) ToVCard() (string, error) {
+	buffer := bytes.NewBuffer(nil)
+	for index := range *C {
+		if err := Projection(nil).writeVCard(buffer, &(*C)[index], VCardVersion4); err != nil {
+			return "", err
+		}
+	}
+	return buffer.String(), nil
+}
+
+// FromVCard reads every card of a vCard file, as Customer.FromVCard reads one
+func (C *Customers) FromVCard(data []byte) error {
+	decoder, err := NewVCardDecoder(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	customers := Customers{}
+	for {
+		customer, err := decoder.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		customers = append(customers, *customer)
+	}
+	*C = customers
+	return nil
+}
+
+// vcardEncoder writes customers as vCards
+type vcardEncoder struct {
+	writer     io.Writer
+	projection Projection
+	version    string
+}
+
+// NewVCardEncoder returns an encoder writing the selected fields of customers as
+// vCard 4.0 cards, one after another. The formatted name (FN) is always written,
+// as every card must have one.
+func NewVCardEncoder(writer io.Writer, projection Projection) CustomerEncoder {
+	return &vcardEncoder{writer: writer, projection: projection, version: VCardVersion4}
+}
+
+// NewVCard3Encoder returns an encoder writing customers as vCard 3.0 cards
+func NewVCard3Encoder(writer io.Writer, projection Projection) CustomerEncoder {
+	return &vcardEncoder{writer: writer, projection: projection, version: VCardVersion3}
+}
+
+func (e *vcardEncoder) Encode(c *Customer) error {
+	return e.projection.writeVCard(e.writer, c, e.version)
+}
+
+func (e *vcardEncoder) Close() error {
+	return nil
+}
+
+// WriteVCard writes the selected fields of a customer as a vCard 4.0
+func (p Projection) WriteVCard(writer io.Writer, c *Customer) error {
+	return p.writeVCard(writer, c, VCardVersion4)
+}
+
+// WriteVCard3 writes the selected fields of a customer as a vCard 3.0
+func (p Projection) WriteVCard3(writer io.Writer, c *Customer) error {
+	return p.writeVCard(writer, c, VCardVersion3)
+}
+
+func (p Projection) writeVCard(writer io.Writer, c *Customer, version string) error {
+	buffer := bytes.NewBuffer(nil)
+	writeLine := func(line string) {
+		writeVCardLine(buffer, line)
+	}
+	writeLine("BEGIN:VCARD")
+	writeLine("VERSION:" + version)
+	writeLine("FN:" + vcardEscape(c.Name))
+	for _, field := range p.fields() {
+		if field.isEmpty(c) {
+			continue
+		}
+		switch field.Name {
+		case "id":
+			writeLine(vcardId + ":" + strconv.FormatInt(c.Id, 10))
+		case "name":
+			writeLine("N:" + vcardName(c.Name))
+		case "role":
+			writeLine("TITLE:" + vcardEscape(c.Role))
+		case "email":
+			if version == VCardVersion3 {
+				writeLine("EMAIL;TYPE=INTERNET:" + vcardEscape(c.Email))
+			} else {
+				writeLine("EMAIL:" + vcardEscape(c.Email))
+			}
+		case "phone":
+			switch {
+			case version == VCardVersion3:
+				writeLine("TEL;TYPE=VOICE:" + vcardEscape(c.Phone))
+			case c.PhoneE164 != "":
+				writeLine("TEL;VALUE=uri:tel:" + c.PhoneE164)
+			default:
+				writeLine("TEL;VALUE=text:" + vcardEscape(c.Phone))
+			}
+		case "contacted":
+			writeLine(vcardContacted + ":TRUE")
+		}
+	}
+	writeLine("END:VCARD")
+	_, err := writer.Write(buffer.Bytes())
+	return err
+}
+
+// vcardName gives the structured name (N) of a name: the last word is taken as
+// the family name, the first as the given name and any others as additional names
+func vcardName(name string) string {
+	words := strings.Fields(name)
+	for index := range words {
+		words[index] = vcardEscape(words[index])
+	}
+	switch len(words) {
+	case 0:
+		return ";;;;"
+	case 1:
+		return ";" + words[0] + ";;;"
+	}
+	last := len(words) - 1
+	return words[last] + ";" + words[0] + ";" + strings.Join(words[1:last], ",") + ";;"
+}
+
+// writeVCardLine writes a content line ending in CRLF, folded so that no line is
+// longer than 75 octets, without splitting a utf-8 sequence
+func writeVCardLine(buffer *bytes.Buffer, line string) {
+	start, limit := 0, vcardMaxLine
+	for index, r := range line {
+		if index+utf8.RuneLen(r)-start > limit {
+			buffer.WriteString(line[start:index] + "\r\n ")
+			// continuation lines start with a space, which counts towards their length
+			start, limit = index, vcardMaxLine-1
+		}
+	}
+	buffer.WriteString(line[start:] + "\r\n")
+}
+
+var vcardEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, ",", `\,`, ";", `\;`)
+
+// vcardEscape escapes a text value
+func vcardEscape(value string) string {
+	return vcardEscaper.Replace(value)
+}
+
+// vcardUnescape reverses vcardEscape, and removes the escaping of other characters
+func vcardUnescape(value string) string {
+	if !strings.Contains(value, `\`) {
+		return value
+	}
+	builder := strings.Builder{}
+	escaped := false
+	for _, r := range value {
+		switch {
+		case escaped && (r == 'n' || r == 'N'):
+			builder.WriteByte('\n')
+		case escaped:
+			builder.WriteRune(r)
+		case r == '\\':
+			escaped = true
+			continue
+		default:
+			builder.WriteRune(r)
+		}
+		escaped = false
+	}
+	return builder.String()
+}
+
+// vcardProperty is a content line of a vCard: a property's name, parameters and value
+type vcardProperty struct {
+	name   string
+	params map[string][]string
+	value  string
+}
+
+// preferred reports whether the property is marked as the preferred of its kind,
+// by PREF=1 (vCard 4.0) or TYPE=pref (vCard 3.0)
+func (p vcardProperty) preferred() bool {
+	for _, value := range p.params["PREF"] {
+		if value == "1" {
+			return true
+		}
+	}
+	for _, value := range p.params["TYPE"] {
+		if strings.EqualFold(value, "pref") {
+			return true
+		}
+	}
+	return false
+}
+
+// vcardDecoder reads customers from the cards of a vCard file
+type vcardDecoder struct {
+	reader  *bufio.Reader
+	next    string // the line read ahead, to find those folded onto it
+	hasNext bool
+	line    int  // the number of the line last read
+	begun   bool // the BEGIN of the next card has been read
+}
+
+// NewVCardDecoder returns a decoder reading customers from the cards of a vCard
+// 3.0 or 4.0 file (RFC 2426 or RFC 6350), as Customer.FromVCard reads one.
+// An error is returned if the file is empty or does not start with a card.
+func NewVCardDecoder(reader io.Reader) (CustomerDecoder, error) {
+	d := &vcardDecoder{reader: bufio.NewReader(reader)}
+	line, err := d.readLine()
+	if err == io.EOF {
+		return nil, fmt.Errorf("%w: the file is empty", ErrInvalidImport)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+	}
+	if !strings.EqualFold(strings.TrimPrefix(line, "\ufeff"), "BEGIN:VCARD") {
+		return nil, fmt.Errorf("%w: the file is not a vCard", ErrInvalidImport)
+	}
+	d.begun = true
+	return d, nil
+}
+
+// readRaw returns the next physical line, without its line ending
+func (d *vcardDecoder) readRaw() (string, error) {
+	if d.hasNext {
+		d.hasNext = false
+		return d.next, nil
+	}
+	line, err := d.reader.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// readLine returns the next content line that is not blank, joining the lines
+// folded onto it
+func (d *vcardDecoder) readLine() (string, error) {
+	var line string
+	for line == "" {
+		var err error
+		if line, err = d.readRaw(); err != nil {
+			return "", err
+		}
+		d.line++
+	}
+	for {
+		next, err := d.readRaw()
+		if err == io.EOF {
+			return line, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if next == "" || (next[0] != ' ' && next[0] != '\t') {
+			d.next, d.hasNext = next, true
+			return line, nil
+		}
+		d.line++
+		line += next[1:]
+	}
+}
+
+func (d *vcardDecoder) Decode() (*Customer, error) {
+	if !d.begun {
+		line, err := d.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if !strings.EqualFold(line, "BEGIN:VCARD") {
+			return nil, fmt.Errorf("%w: line %d: expected BEGIN:VCARD", ErrInvalidImport, d.line)
+		}
+	}
+	d.begun = false
+	var properties []vcardProperty
+	for {
+		line, err := d.readLine()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%w: the card begun before line %d has no END:VCARD", ErrInvalidImport, d.line)
+		}
+		if err != nil {
+			return nil, err
+		}
+		property, ok := parseVCardLine(line)
+		if !ok {
+			// lines that are not properties are ignored, as address books may write them
+			continue
+		}
+		if property.name == "END" && strings.EqualFold(property.value, "VCARD") {
+			return customerFromVCard(properties)
+		}
+		if property.name == "BEGIN" {
+			return nil, fmt.Errorf("%w: line %d: a card may not begin within another", ErrInvalidImport, d.line)
+		}
+		properties = append(properties, property)
+	}
+}
+
+// parseVCardLine splits a content line, [group.]name[;param=value...]:value,
+// into its parts; parameter values may be quoted, and hold several values
+// separated by commas. A parameter without a value (vCard 2.1) is a TYPE.
+func parseVCardLine(line string) (vcardProperty, bool) {
+	parts := []string{}
+	quoted, start := false, 0
+	colon := -1
+	for index := 0; index < len(line) && colon < 0; index++ {
+		switch line[index] {
+		case '"':
+			quoted = !quoted
+		case ';', ':':
+			if !quoted {
+				parts = append(parts, line[start:index])
+				start = index + 1
+				if line[index] == ':' {
+					colon = index
+				}
+			}
+		}
+	}
+	if colon < 0 {
+		return vcardProperty{}, false
+	}
+	name := parts[0]
+	if dot := strings.LastIndexByte(name, '.'); dot >= 0 {
+		name = name[dot+1:]
+	}
+	property := vcardProperty{name: strings.ToUpper(strings.TrimSpace(name)), params: map[string][]string{}, value: line[colon+1:]}
+	for _, param := range parts[1:] {
+		key, value, ok := strings.Cut(param, "=")
+		if !ok {
+			key, value = "TYPE", param
+		}
+		key = strings.ToUpper(strings.TrimSpace(key))
+		for _, item := range strings.Split(value, ",") {
+			property.params[key] = append(property.params[key], strings.Trim(item, `"`))
+		}
+	}
+	return property, property.name != ""
+}
+
+// customerFromVCard maps the properties of a card onto a customer: FN (or N
+// where there is none) to the name, TITLE (or ROLE) to the role, and the
+// preferred (or else the first) EMAIL and TEL to the email and phone
+func customerFromVCard(properties []vcardProperty) (*Customer, error) {
+	chosen := map[string]vcardProperty{}
+	for _, property := range properties {
+		if current, ok := chosen[property.name]; !ok || (property.preferred() && !current.preferred()) {
+			chosen[property.name] = property
+		}
+	}
+	text := func(name string) string {
+		return strings.TrimSpace(vcardUnescape(chosen[name].value))
+	}
+	customer := &Customer{
+		Name:  text("FN"),
+		Role:  text("TITLE"),
+		Email: strings.TrimPrefix(text("EMAIL"), "mailto:"),
+		Phone: strings.TrimPrefix(text("TEL"), "tel:"),
+	}
+	if customer.Name == "" {
+		customer.Name = nameFromVCard(chosen["N"].value)
+	}
+	if customer.Role == "" {
+		customer.Role = text("ROLE")
+	}
+	if contacted := text(vcardContacted); contacted != "" {
+		var err error
+		if customer.Contacted, err = strconv.ParseBool(contacted); err != nil {
+			return customer, fieldError("contacted", "must be true or false, not %q", contacted)
+		}
+	}
+	return customer, nil
+}
+
+// nameFromVCard joins the components of a structured name (N),
+// family;given;additional;prefix;suffix, as the given, additional and family names
+func nameFromVCard(value string) string {
+	components := splitVCard(value, ';')
+	for len(components) < 3 {
+		components = append(components, "")
+	}
+	var words []string
+	for _, component := range []string{components[1], components[2], components[0]} {
+		for _, name := range splitVCard(component, ',') {
+			words = append(words, vcardUnescape(name))
+		}
+	}
+	return strings.Join(strings.Fields(strings.Join(words, " ")), " ")
+}
+
+// splitVCard splits a value at each separator that is not escaped
+func splitVCard(value string, separator byte) []string {
+	var parts []string
+	start := 0
+	for index := 0; index < len(value); index++ {
+		if value[index] == '\\' {
+			index++
+		} else if value[index] == separator {
+			parts = append(parts, value[start:index])
+			start = index + 1
+		}
+	}
+	return append(parts, value[start:])
+}
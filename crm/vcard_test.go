@@ -0,0 +1,196 @@
+package crm
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCustomerVCard(t *testing.T) {
+	customer := Customer{Id: 7, Name: "Mary Jane Watson", Role: "photographer; freelance", Email: "mj@example.com",
+		Phone: "(07) 4938 5904", PhoneE164: "+61749385904", Contacted: true, Version: 3}
+	expected := "BEGIN:VCARD\r\nVERSION:4.0\r\nFN:Mary Jane Watson\r\nX-CRM-ID:7\r\nN:Watson;Mary;Jane;;\r\n" +
+		"TITLE:photographer\\; freelance\r\nEMAIL:mj@example.com\r\nTEL;VALUE=uri:tel:+61749385904\r\n" +
+		"X-CRM-CONTACTED:TRUE\r\nEND:VCARD\r\n"
+	card, err := customer.ToVCard()
+	if err != nil || card != expected {
+		t.Fatalf("unexpected vCard %q (error %v)", card, err)
+	}
+
+	var read Customer
+	if err = read.FromVCard([]byte(card)); err != nil {
+		t.Fatalf("FromVCard: %v", err)
+	}
+	if read != (Customer{Name: customer.Name, Role: customer.Role, Email: customer.Email, Phone: "+61749385904", Contacted: true}) {
+		t.Errorf("unexpected customer %+v", read)
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	if err = (Projection{CustomerFields[1], CustomerFields[4]}).WriteVCard3(buffer, &customer); err != nil {
+		t.Fatal(err)
+	}
+	expected = "BEGIN:VCARD\r\nVERSION:3.0\r\nFN:Mary Jane Watson\r\nN:Watson;Mary;Jane;;\r\nTEL;TYPE=VOICE:(07) 4938 5904\r\nEND:VCARD\r\n"
+	if buffer.String() != expected {
+		t.Errorf("unexpected vCard 3.0 %q", buffer.String())
+	}
+}
+
+func TestVCardEncoder(t *testing.T) {
+	customerTable := ReadCustomers(t)
+	customers, _ := customerTable.List()
+
+	for _, newEncoder := range []func(io.Writer, Projection) CustomerEncoder{NewVCardEncoder, NewVCard3Encoder} {
+		buffer := bytes.NewBuffer(nil)
+		encoder := newEncoder(buffer, nil)
+		for index := range customers {
+			if err := encoder.Encode(&customers[index]); err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+		}
+		if err := encoder.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+		var read Customers
+		if err := read.FromVCard(buffer.Bytes()); err != nil {
+			t.Fatalf("FromVCard: %v", err)
+		}
+		if len(read) != len(customers) {
+			t.Fatalf("expected %d customers, got %d", len(customers), len(read))
+		}
+		for index, customer := range read {
+			original := customers[index]
+			if customer.Name != original.Name || customer.Role != original.Role || customer.Email != original.Email ||
+				CanonicalPhone(customer.Phone, "") != original.PhoneE164 || customer.Contacted != original.Contacted {
+				t.Errorf("customer %d read back as %+v", original.Id, customer)
+			}
+		}
+	}
+
+	// Customers.ToVCard writes the same cards as the encoder
+	all, _ := customers.ToVCard()
+	buffer := bytes.NewBuffer(nil)
+	encoder := NewVCardEncoder(buffer, nil)
+	for index := range customers {
+		_ = encoder.Encode(&customers[index])
+	}
+	if all != buffer.String() {
+		t.Errorf("Customers.ToVCard differs from the encoder")
+	}
+}
+
+func TestVCardFolding(t *testing.T) {
+	customer := Customer{Name: strings.Repeat("Zoë Ångström ", 12) + "Ng"}
+	card, _ := customer.ToVCard()
+	for _, line := range strings.Split(strings.TrimSuffix(card, "\r\n"), "\r\n") {
+		if len(line) > vcardMaxLine {
+			t.Errorf("line longer than %d octets: %q", vcardMaxLine, line)
+		}
+	}
+	if !strings.Contains(card, "\r\n ") {
+		t.Errorf("expected folded lines, got %q", card)
+	}
+	var read Customer
+	if err := read.FromVCard([]byte(card)); err != nil || read.Name != customer.Name {
+		t.Errorf("unexpected name %q (error %v)", read.Name, err)
+	}
+}
+
+func TestVCardDecoder(t *testing.T) {
+	data := "\ufeffBEGIN:VCARD\r\n" +
+		"N:Vane;Harriet;Deborah;;\r\n" +
+		"VERSION:3.0\r\n" +
+		"item1.EMAIL;TYPE=INTERNET,HOME:harriet@home.example.com\r\n" +
+		"item2.EMAIL;TYPE=INTERNET,pref:hvane@example.com\r\n" +
+		"TEL;TYPE=CELL:0412 345 678\r\n" +
+		"ROLE:author\r\n" +
+		"NOTE:Writes detective novels\\, mostly\\nand poetry\r\n" +
+		"END:VCARD\r\n" +
+		"\r\n" +
+		"begin:vcard\n" +
+		"version:4.0\n" +
+		"fn:Lord Peter\n" +
+		"  Wimsey\n" +
+		"title:detective\\, amateur\n" +
+		"role:investigator\n" +
+		"email;type=work:pwimsey@example.com\n" +
+		"tel;value=uri;pref=2:tel:+44-20-7946-0000\n" +
+		"tel;value=uri;pref=1;type=\"voice,cell\":tel:+44 7700 900123\n" +
+		"x-crm-contacted:false\n" +
+		"end:vcard\n" +
+		"BEGIN:VCARD\n" +
+		"VERSION:4.0\n" +
+		"FN:Mervyn Bunter\n" +
+		"X-CRM-CONTACTED:sometimes\n" +
+		"END:VCARD\n"
+	decoder, err := NewVCardDecoder(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewVCardDecoder: %v", err)
+	}
+	expected := []Customer{
+		{Name: "Harriet Deborah Vane", Role: "author", Email: "hvane@example.com", Phone: "0412 345 678"},
+		{Name: "Lord Peter Wimsey", Role: "detective, amateur", Email: "pwimsey@example.com", Phone: "+44 7700 900123"},
+		{Name: "Mervyn Bunter"},
+	}
+	for index, want := range expected {
+		customer, err := decoder.Decode()
+		if index == 2 {
+			var invalid *ValidationError
+			if !errors.As(err, &invalid) || invalid.Fields[0].Field != "contacted" {
+				t.Errorf("card 3: expected a validation error, got %v", err)
+			}
+		} else if err != nil {
+			t.Fatalf("card %d: %v", index+1, err)
+		}
+		if customer == nil || *customer != want {
+			t.Errorf("card %d: expected %+v, got %+v", index+1, want, customer)
+		}
+	}
+	if _, err = decoder.Decode(); err != io.EOF {
+		t.Errorf("expected the end of the file, got %v", err)
+	}
+}
+
+func TestVCardDecoderErrors(t *testing.T) {
+	for _, data := range []string{"", "\r\n\r\n", "name,email\nHarriet Vane,hvane@example.com\n"} {
+		if _, err := NewVCardDecoder(strings.NewReader(data)); !errors.Is(err, ErrInvalidImport) {
+			t.Errorf("%q: expected an invalid import, got %v", data, err)
+		}
+	}
+	for _, data := range []string{
+		"BEGIN:VCARD\nFN:Harriet Vane\n",
+		"BEGIN:VCARD\nFN:Harriet Vane\nBEGIN:VCARD\nEND:VCARD\n",
+		"BEGIN:VCARD\nFN:Harriet Vane\nEND:VCARD\nFN:Peter Wimsey\n",
+	} {
+		decoder, err := NewVCardDecoder(strings.NewReader(data))
+		if err != nil {
+			t.Fatalf("%q: %v", data, err)
+		}
+		for err == nil {
+			_, err = decoder.Decode()
+		}
+		if !errors.Is(err, ErrInvalidImport) {
+			t.Errorf("%q: expected an invalid import, got %v", data, err)
+		}
+	}
+}
+
+func TestImportVCard(t *testing.T) {
+	customerTable := ReadCustomers(t)
+	data := "BEGIN:VCARD\nVERSION:4.0\nFN:Harriet Vane\nEMAIL:hvane@example.com\nTEL:(02) 9876 5432\nEND:VCARD\n" +
+		"BEGIN:VCARD\nVERSION:4.0\nFN:Bianca Again\nEMAIL:bbruxner@dayrep.com\nEND:VCARD\n" +
+		"BEGIN:VCARD\nVERSION:4.0\nFN:\nEMAIL:nobody@example.com\nEND:VCARD\n"
+	decoder, err := NewVCardDecoder(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := Importer{Validator: DefaultValidator}.Import(customerTable, decoder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRows(t, report, ImportCreated, ImportSkipped, ImportFailed)
+	if customer, err := customerTable.Get(report.Rows[0].Id); err != nil || customer.PhoneE164 != "+61298765432" {
+		t.Errorf("unexpected customer %+v (error %v)", customer, err)
+	}
+}
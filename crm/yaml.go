@@ -0,0 +1,107 @@
+package crm
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// yamlEncoder writes customers as a yaml sequence
+type yamlEncoder struct {
+	writer     io.Writer
+	projection Projection
+	count      int
+}
+
+// NewYAMLEncoder returns an encoder writing the selected fields of customers as
+// a yaml sequence of mappings, omitting fields that are empty
+func NewYAMLEncoder(writer io.Writer, projection Projection) CustomerEncoder {
+	return &yamlEncoder{writer: writer, projection: projection}
+}
+
+func (e *yamlEncoder) Encode(c *Customer) error {
+	e.count++
+	return e.projection.writeYAML(e.writer, c, "- ", "  ")
+}
+
+func (e *yamlEncoder) Close() error {
+	if e.count == 0 {
+		_, err := io.WriteString(e.writer, "[]\n")
+		return err
+	}
+	return nil
+}
+
+// WriteYAML writes the selected fields of a customer as a yaml mapping
+func (p Projection) WriteYAML(writer io.Writer, c *Customer) error {
+	return p.writeYAML(writer, c, "", "")
+}
+
+// writeYAML writes a customer as a mapping, the first line prefixed by first
+// and the others by indent, so that it can be written as an item of a sequence
+func (p Projection) writeYAML(writer io.Writer, c *Customer, first, indent string) error {
+	buffer := bytes.NewBuffer(nil)
+	prefix := first
+	written := false
+	for _, field := range p.fields() {
+		if field.isEmpty(c) {
+			continue
+		}
+		value := field.String(c)
+		if field.Kind == StringField {
+			value = yamlString(value)
+		}
+		buffer.WriteString(prefix + field.Name + ": " + value + "\n")
+		prefix, written = indent, true
+	}
+	if !written {
+		// every field is empty
+		buffer.WriteString(first + "{}\n")
+	}
+	_, err := writer.Write(buffer.Bytes())
+	return err
+}
+
+// yamlString returns a string as a yaml scalar: plain where that will be read
+// back as the same string, and otherwise double quoted
+func yamlString(s string) string {
+	if yamlPlain(s) {
+		return s
+	}
+	// a json string is a valid yaml double quoted scalar
+	buffer := bytes.NewBuffer(nil)
+	encoder := json.NewEncoder(buffer)
+	encoder.SetEscapeHTML(false)
+	_ = encoder.Encode(s)
+	return strings.TrimSuffix(buffer.String(), "\n")
+}
+
+// yamlPlain reports whether a string can be written as a plain yaml scalar
+// without being read as something else: a number, boolean or null, or yaml syntax
+func yamlPlain(s string) bool {
+	if s == "" || s != strings.TrimSpace(s) || strings.ContainsAny(s[:1], "-?:,[]{}#&*!|>'\"%@`") {
+		return false
+	}
+	if strings.Contains(s, ": ") || strings.Contains(s, " #") || strings.HasSuffix(s, ":") {
+		return false
+	}
+	for _, r := range s {
+		if unicode.IsControl(r) || r == unicode.ReplacementChar {
+			return false
+		}
+	}
+	switch strings.ToLower(s) {
+	case "true", "false", "yes", "no", "on", "off", "y", "n", "null", "~", ".inf", "-.inf", ".nan":
+		return false
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return false
+	}
+	if _, err := strconv.ParseInt(strings.ReplaceAll(s, "_", ""), 0, 64); err == nil {
+		return false
+	}
+	return true
+}
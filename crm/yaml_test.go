@@ -0,0 +1,74 @@
+package crm
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestYAMLEncoder(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	encodeAll(t, NewYAMLEncoder(buffer, nil), append(encodeCustomers, Customer{}))
+	expected := `- id: 5
+  name: Bianca Bruxner
+  role: student
+  email: bbruxner@dayrep.com
+  phone: (07) 4938 5904
+  phone_e164: "+61749385904"
+  version: 1
+- id: 7
+  name: O'Brien, "Charli" <&>
+  contacted: true
+- {}
+`
+	if buffer.String() != expected {
+		t.Errorf("unexpected yaml:\n%s\nexpected:\n%s", buffer.String(), expected)
+	}
+
+	buffer.Reset()
+	encodeAll(t, NewYAMLEncoder(buffer, nil), nil)
+	if buffer.String() != "[]\n" {
+		t.Errorf("expected an empty sequence, got %q", buffer.String())
+	}
+
+	buffer.Reset()
+	if err := Projection(nil).WriteYAML(buffer, &encodeCustomers[0]); err != nil {
+		t.Fatalf("WriteYAML: %v", err)
+	}
+	if buffer.String()[:6] != "id: 5\n" {
+		t.Errorf("unexpected mapping:\n%s", buffer.String())
+	}
+}
+
+func TestYAMLString(t *testing.T) {
+	tests := map[string]string{
+		"Bianca Bruxner":      "Bianca Bruxner",
+		"bbruxner@dayrep.com": "bbruxner@dayrep.com",
+		"(07) 4938 5904":      "(07) 4938 5904",
+		"O'Brien":             "O'Brien",
+		"":                    `""`,
+		" padded":             `" padded"`,
+		"+61749385904":        `"+61749385904"`,
+		"0412345678":          `"0412345678"`,
+		"0x1F":                `"0x1F"`,
+		"1e3":                 `"1e3"`,
+		"true":                `"true"`,
+		"No":                  `"No"`,
+		"null":                `"null"`,
+		"~":                   `"~"`,
+		"- item":              `"- item"`,
+		"key: value":          `"key: value"`,
+		"note#1":              "note#1",
+		"note #1":             `"note #1"`,
+		"note #1 # comment":   `"note #1 # comment"`,
+		"trailing:":           `"trailing:"`,
+		"@handle":             `"@handle"`,
+		"line\nbreak":         `"line\nbreak"`,
+		"-<b>":                `"-<b>"`,
+		"Zoë":                 "Zoë",
+	}
+	for input, expected := range tests {
+		if actual := yamlString(input); actual != expected {
+			t.Errorf("yamlString(%q) = %s, expected %s", input, actual, expected)
+		}
+	}
+}
@@ -2,9 +2,12 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"github.com/deeprave/go-crm/api"
+	"github.com/deeprave/go-crm/crm"
 	"io"
+	"log"
 	"net/http"
 	"os"
 	"path"
@@ -13,9 +16,50 @@ import (
 func main() {
 	port := 4000
 	host := "localhost"
+	dataDir := flag.String("data", "", "directory for durable customer storage (default: in-memory only)")
+	lenient := flag.Bool("lenient", false, "skip email and phone format validation")
+	uniqueEmail := flag.Bool("unique-email", false, "require each customer to have a different email")
+	region := flag.String("region", crm.DefaultRegion, "region assumed for phone numbers in national format")
+	flag.Parse()
 
-	// set up our routes and possible middleware
-	router := api.ApiMiddleware(api.ApiRoutes("/customers"))
+	// choose the customer store
+	var store crm.CustomerStore = &crm.CustomerTable{}
+	if *dataDir != "" {
+		fileStore, err := crm.OpenFileStore(*dataDir)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer fileStore.Close()
+		store = fileStore
+	}
+
+	// read phone numbers in national format in the same region when storing and finding them
+	if err := store.(crm.RegionSetter).SetRegion(*region); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if *uniqueEmail {
+		if err := store.(crm.UniqueEmailEnforcer).SetUniqueEmail(true); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	validator := crm.DefaultValidator
+	if *lenient {
+		validator = crm.LenientValidator
+	}
+	validator.Region = *region
+
+	// set up the api server, its routes and middleware
+	server := api.NewServer(store,
+		api.WithLogger(log.New(os.Stderr, "api: ", log.LstdFlags)),
+		api.WithBasePath("/customers"),
+		api.WithValidator(validator))
+	defer server.Close()
+	router := server.Router()
 
 	// add a way to add data to the "database" from a local file on the server
 	router.HandleFunc("/load-test-data", func(writer http.ResponseWriter, request *http.Request) {
@@ -29,15 +73,15 @@ func main() {
 			jsmap := map[string]string{}
 			err = json.Unmarshal(body, &jsmap)
 			if err == nil {
-				if path := jsmap["path"]; path != "" {
-					if err = api.ReadCustomerData(path); err == nil {
-						writer.WriteHeader(http.StatusNoContent)
-						return
-					}
+				if path := jsmap["path"]; path == "" {
+					err = fmt.Errorf("path is required")
+				} else if err = server.ReadCustomerData(path); err == nil {
+					writer.WriteHeader(http.StatusNoContent)
+					return
 				}
 			}
 		}
-		api.Error(writer, err.Error(), http.StatusBadRequest)
+		api.NewProblem(request, api.BadRequest(err)).Write(writer)
 	}).Methods(http.MethodPost)
 
 	router.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {